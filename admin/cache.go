@@ -0,0 +1,102 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"web-app/cache"
+	"web-app/httperror"
+	"web-app/server"
+	"web-app/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// init binds cache management endpoints.
+func init() {
+
+	// create the permission required to use the cache endpoints
+	if err := user.CreatePrivatePermissions(context.Background(), []string{
+		cachePermission,
+	}, nil); err != nil {
+		logrus.Fatal(err)
+	}
+
+	cacheGroup := server.AdminRouter().Group(cacheEndpoint, user.AuthMiddleware(),
+		user.RequireAllPermissionsMiddleware(cachePermission))
+
+	cacheGroup.GET(cacheStatsEndpoint, getCacheStats)
+	cacheGroup.POST(cachePurgeEndpoint, postCachePurge)
+
+}
+
+const (
+	// cacheEndpoint the API endpoint group used to manage the cache.
+	cacheEndpoint = "/admin/cache"
+	// cacheStatsEndpoint the API endpoint used to get local cache statistics.
+	cacheStatsEndpoint = "/stats"
+	// cachePurgeEndpoint the API endpoint used to remove cached values.
+	cachePurgeEndpoint = "/purge"
+	// cachePermission allows a user to manage the cache.
+	cachePermission = "cache"
+)
+
+// getCacheStats responds with the usage of the local cache.
+func getCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, cache.Stats())
+}
+
+// postCachePurge removes the cached values matching a key, key prefix, or tag
+// from every server instance, or every cached value if requested.
+func postCachePurge(c *gin.Context) {
+
+	var req cachePurgeRequest
+
+	// read request parameters
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: "invalid request body",
+		})
+		return
+	}
+
+	// exactly one of the purge criteria must be supplied
+	count := 0
+	for _, set := range []bool{req.Key != "", req.Prefix != "", req.Tag != "",
+		req.All} {
+		if set {
+			count++
+		}
+	}
+
+	if count != 1 {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: "exactly one of key, prefix, tag, or all is required",
+		})
+		return
+	}
+
+	var err error
+	switch {
+	case req.Key != "":
+		err = cache.Delete(req.Key)
+	case req.Prefix != "":
+		err = cache.DeleteByPrefix(req.Prefix)
+	case req.Tag != "":
+		err = cache.InvalidateTag(req.Tag)
+	default:
+		err = cache.Purge()
+	}
+
+	if err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+
+}
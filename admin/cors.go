@@ -0,0 +1,118 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"web-app/httperror"
+	"web-app/server"
+	"web-app/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// init binds CORS origin allowlist endpoints.
+func init() {
+
+	// create the permission required to manage the origin allowlist
+	if err := user.CreatePrivatePermissions(context.Background(), []string{
+		corsPermission,
+	}, nil); err != nil {
+		logrus.Fatal(err)
+	}
+
+	server.AdminRouter().GET(corsOriginsEndpoint, user.AuthMiddleware(),
+		user.RequireAllPermissionsMiddleware(corsPermission), getCORSOrigins)
+	server.AdminRouter().POST(corsOriginsEndpoint, user.AuthMiddleware(),
+		user.RequireAllPermissionsMiddleware(corsPermission), postCORSOrigin)
+	server.AdminRouter().DELETE(corsOriginsEndpoint+"/:id",
+		user.AuthMiddleware(),
+		user.RequireAllPermissionsMiddleware(corsPermission), deleteCORSOrigin)
+
+}
+
+const (
+	// corsOriginsEndpoint the API endpoint used to manage origins allowed to
+	// execute cross-domain requests.
+	corsOriginsEndpoint = "/admin/cors/origins"
+	// corsPermission allows a user to manage the CORS origin allowlist.
+	corsPermission = "cors"
+)
+
+// getCORSOrigins responds with every origin in the allowlist.
+func getCORSOrigins(c *gin.Context) {
+
+	items, err := server.ListCORSOrigins()
+	if err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+
+}
+
+// postCORSOrigin adds an origin to the allowlist.
+func postCORSOrigin(c *gin.Context) {
+
+	var req corsOriginRequest
+
+	// read request parameters
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: "invalid request body",
+		})
+		return
+	}
+
+	item, err := server.AddCORSOrigin(req.Origin)
+	if errors.Is(err, server.ErrInvalidOrigin) {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: err.Error(),
+		})
+		return
+	} else if err == server.ErrOriginExists {
+		c.JSON(http.StatusConflict, httperror.ErrorResponse{
+			ErrorMessage: err.Error(),
+		})
+		return
+	} else if err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, item)
+
+}
+
+// deleteCORSOrigin removes an origin from the allowlist.
+func deleteCORSOrigin(c *gin.Context) {
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: "invalid origin id",
+		})
+		return
+	}
+
+	if err := server.DeleteCORSOrigin(uint(id)); err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+
+}
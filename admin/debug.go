@@ -0,0 +1,189 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"web-app/httperror"
+	"web-app/server"
+	"web-app/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// init binds runtime debug endpoints.
+func init() {
+
+	// create the permission required to use the debug endpoints
+	if err := user.CreatePrivatePermissions(context.Background(), []string{
+		debugPermission,
+	}, nil); err != nil {
+		logrus.Fatal(err)
+	}
+
+	debugGroup := server.AdminRouter().Group(debugEndpoint, user.AuthMiddleware(),
+		user.RequireAllPermissionsMiddleware(debugPermission))
+
+	// bind pprof endpoints
+	debugGroup.GET(pprofEndpoint+"/*name", getPprof)
+	debugGroup.POST(pprofEndpoint+"/symbol", gin.WrapF(pprof.Symbol))
+
+	// bind runtime endpoints
+	debugGroup.GET(goroutinesEndpoint, getGoroutines)
+	debugGroup.GET(gcEndpoint, getGC)
+	debugGroup.GET(logLevelEndpoint, getLogLevel)
+	debugGroup.PUT(logLevelEndpoint, putLogLevel)
+
+}
+
+const (
+	// debugEndpoint the base API endpoint for runtime debug endpoints.
+	debugEndpoint = "/debug"
+	// pprofEndpoint the API endpoint that serves pprof profiles.
+	pprofEndpoint = "/pprof"
+	// goroutinesEndpoint the API endpoint that dumps the stack of every
+	// goroutine.
+	goroutinesEndpoint = "/goroutines"
+	// gcEndpoint the API endpoint that reports memory and garbage collection
+	// statistics.
+	gcEndpoint = "/gc"
+	// logLevelEndpoint the API endpoint used to view and change the log level.
+	logLevelEndpoint = "/log-level"
+	// debugPermission allows a user to access runtime debug endpoints.
+	debugPermission = "debug"
+	// pprofProfileSeconds is the default duration of a CPU profile, matching
+	// the default of the pprof package.
+	pprofProfileSeconds = 30
+	// pprofTraceSeconds is the default duration of an execution trace,
+	// matching the default of the pprof package.
+	pprofTraceSeconds = 1
+)
+
+// getPprof serves the requested pprof profile. The write deadline of the CPU
+// profile and execution trace is extended by the profile duration so that the
+// server write timeout does not cut them off.
+func getPprof(c *gin.Context) {
+	switch name := strings.TrimPrefix(c.Param("name"), "/"); name {
+	case "":
+		pprof.Index(c.Writer, c.Request)
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		extendPprofDeadline(c, pprofProfileSeconds)
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		extendPprofDeadline(c, pprofTraceSeconds)
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// extendPprofDeadline extends the write deadline of the response by the
+// duration requested with the seconds query parameter, using the supplied
+// default if no duration was requested.
+func extendPprofDeadline(c *gin.Context, defaultSeconds float64) {
+
+	seconds, err := strconv.ParseFloat(c.Query("seconds"), 64)
+	if err != nil || seconds <= 0 {
+		seconds = defaultSeconds
+	}
+
+	duration := time.Duration(seconds * float64(time.Second))
+
+	if err := server.SetWriteDeadline(c,
+		time.Now().Add(duration+server.WriteTimeout())); err != nil {
+		logrus.WithContext(c).Debug(err)
+	}
+
+}
+
+// getGoroutines responds with the stack of every running goroutine.
+func getGoroutines(c *gin.Context) {
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	if err := runtimepprof.Lookup("goroutine").WriteTo(c.Writer, 2); err != nil {
+		logrus.WithContext(c).Error(err)
+	}
+
+}
+
+// getGC responds with memory and garbage collection statistics.
+func getGC(c *gin.Context) {
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	var gcStats debug.GCStats
+	debug.ReadGCStats(&gcStats)
+
+	var lastGC *time.Time
+	if !gcStats.LastGC.IsZero() {
+		lastGC = &gcStats.LastGC
+	}
+
+	c.JSON(http.StatusOK, gcResponse{
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    memStats.HeapAlloc,
+		HeapInuse:    memStats.HeapInuse,
+		HeapObjects:  memStats.HeapObjects,
+		HeapSys:      memStats.HeapSys,
+		TotalAlloc:   memStats.TotalAlloc,
+		Sys:          memStats.Sys,
+		NumGC:        memStats.NumGC,
+		LastGC:       lastGC,
+		PauseTotal:   gcStats.PauseTotal,
+		NextGCTarget: memStats.NextGC,
+	})
+
+}
+
+// getLogLevel responds with the current log level.
+func getLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, logLevelResponse{
+		Level: logrus.GetLevel().String(),
+	})
+}
+
+// putLogLevel changes the log level of this server instance.
+func putLogLevel(c *gin.Context) {
+
+	var req logLevelRequest
+
+	// read request parameters
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: "invalid request body",
+		})
+		return
+	}
+
+	// validate request parameters
+	level, err := logrus.ParseLevel(req.Level)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: "invalid log level",
+		})
+		return
+	}
+
+	logrus.SetLevel(level)
+	logrus.WithContext(c).Infof("log level set to %s", level)
+
+	c.JSON(http.StatusOK, logLevelResponse{
+		Level: logrus.GetLevel().String(),
+	})
+
+}
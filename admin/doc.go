@@ -0,0 +1,5 @@
+// Package admin exposes API endpoints for managing the application server at
+// runtime. These endpoints are restricted to users with the appropriate
+// permissions and are bound to the server admin router so that they may be
+// served by a separate internal listener.
+package admin
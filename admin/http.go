@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"web-app/httperror"
+	"web-app/server"
+	"web-app/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// init creates admin permissions and binds admin API endpoints.
+func init() {
+
+	// create the permissions required to use the admin API
+	if err := user.CreatePrivatePermissions(context.Background(), []string{
+		maintenancePermission,
+		maintenanceBypassPermission,
+	}, nil); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// allow privileged users to use the application during maintenance
+	server.SetMaintenanceBypass(maintenanceBypass)
+
+	// the maintenance endpoint must remain available so that users with the
+	// maintenance permission can end maintenance, access is still restricted
+	// by the endpoint's permission check
+	server.AllowDuringMaintenance(server.MaintenanceOn, maintenanceEndpoint)
+
+	// bind maintenance endpoints
+	server.AdminRouter().GET(maintenanceEndpoint, user.AuthMiddleware(),
+		user.RequireAllPermissionsMiddleware(maintenancePermission),
+		getMaintenance)
+	server.AdminRouter().PUT(maintenanceEndpoint, user.AuthMiddleware(),
+		user.RequireAllPermissionsMiddleware(maintenancePermission),
+		putMaintenance)
+
+}
+
+const (
+	// maintenanceEndpoint the API endpoint used to view and change the
+	// maintenance mode.
+	maintenanceEndpoint = "/admin/maintenance"
+	// maintenancePermission allows a user to view and change the maintenance
+	// mode.
+	maintenancePermission = "maintenance"
+	// maintenanceBypassPermission allows a user to use the application while
+	// maintenance is being performed.
+	maintenanceBypassPermission = "maintenance_bypass"
+)
+
+// getMaintenance responds with the current maintenance mode.
+func getMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, maintenanceResponse{
+		Mode: server.GetMaintenanceMode(),
+	})
+}
+
+// putMaintenance changes the maintenance mode.
+func putMaintenance(c *gin.Context) {
+
+	var req maintenanceRequest
+
+	// read request parameters
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: "invalid request body",
+		})
+		return
+	}
+
+	// validate request parameters
+	switch req.Mode {
+	case server.MaintenanceOff, server.MaintenanceOn,
+		server.MaintenanceReadOnly:
+	default:
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: "mode must be one of off, on, or read-only",
+		})
+		return
+	}
+
+	// change the maintenance mode
+	if err := server.SetMaintenanceMode(req.Mode); err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, maintenanceResponse{
+		Mode: server.GetMaintenanceMode(),
+	})
+
+}
+
+// maintenanceBypass checks whether the request was made by a user that may
+// use the application during maintenance.
+func maintenanceBypass(c *gin.Context) bool {
+
+	u, err := user.AuthenticateRequest(c)
+	if err != nil {
+		return false
+	}
+
+	ok, err := user.HasAnyPermissions(c, u, maintenanceBypassPermission)
+	if err != nil {
+		logrus.WithContext(c).Error(err)
+		return false
+	}
+
+	return ok
+
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"web-app/data"
+	"web-app/httperror"
+	"web-app/jobs"
+	"web-app/server"
+	"web-app/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// init binds scheduled job endpoints.
+func init() {
+
+	// create the permission required to view scheduled jobs
+	if err := user.CreatePrivatePermissions(context.Background(), []string{
+		jobsPermission,
+	}, nil); err != nil {
+		logrus.Fatal(err)
+	}
+
+	server.AdminRouter().GET(jobsEndpoint, user.AuthMiddleware(),
+		user.RequireAllPermissionsMiddleware(jobsPermission), getJobs)
+
+}
+
+const (
+	// jobsEndpoint the API endpoint used to view scheduled jobs and their
+	// recent runs.
+	jobsEndpoint = "/admin/jobs"
+	// jobsPermission allows a user to view scheduled jobs.
+	jobsPermission = "jobs"
+	// jobRunHistoryLimit is the number of recent runs reported for each job.
+	jobRunHistoryLimit = 10
+)
+
+// getJobs responds with every registered job and its most recent runs.
+func getJobs(c *gin.Context) {
+
+	resp := []jobResponse{}
+
+	for _, job := range jobs.Jobs() {
+
+		runs, err := jobs.ListJobRunByName(c, data.DB(), job.Name,
+			jobRunHistoryLimit)
+		if err != nil {
+			logrus.WithContext(c).Error(err)
+			c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+				ErrorMessage: httperror.InternalServerError,
+			})
+			return
+		}
+
+		resp = append(resp, jobResponse{
+			Name:     job.Name,
+			Schedule: job.Schedule,
+			NextRun:  job.Next(time.Now()),
+			Runs:     runs,
+		})
+
+	}
+
+	c.JSON(http.StatusOK, resp)
+
+}
@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"time"
+
+	"web-app/jobs"
+	"web-app/server"
+)
+
+// maintenanceRequest is used to read a request to the maintenance endpoint.
+type maintenanceRequest struct {
+	Mode server.MaintenanceMode `json:"mode"`
+}
+
+// maintenanceResponse is used to format responses from the maintenance
+// endpoint.
+type maintenanceResponse struct {
+	Mode server.MaintenanceMode `json:"mode"`
+}
+
+// gcResponse is used to format responses from the garbage collection
+// statistics endpoint.
+type gcResponse struct {
+	Goroutines   int           `json:"goroutines"`
+	HeapAlloc    uint64        `json:"heap_alloc"`
+	HeapInuse    uint64        `json:"heap_inuse"`
+	HeapObjects  uint64        `json:"heap_objects"`
+	HeapSys      uint64        `json:"heap_sys"`
+	TotalAlloc   uint64        `json:"total_alloc"`
+	Sys          uint64        `json:"sys"`
+	NumGC        uint32        `json:"num_gc"`
+	LastGC       *time.Time    `json:"last_gc"`
+	PauseTotal   time.Duration `json:"pause_total"`
+	NextGCTarget uint64        `json:"next_gc_target"`
+}
+
+// logLevelRequest is used to read a request to the log level endpoint.
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// logLevelResponse is used to format responses from the log level endpoint.
+type logLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// jobResponse is used to format a job in responses from the jobs endpoint.
+type jobResponse struct {
+	Name     string         `json:"name"`
+	Schedule string         `json:"schedule"`
+	NextRun  time.Time      `json:"next_run"`
+	Runs     []*jobs.JobRun `json:"runs"`
+}
+
+// corsOriginRequest is used to read a request to add an origin to the CORS
+// allowlist.
+type corsOriginRequest struct {
+	Origin string `json:"origin"`
+}
+
+// cachePurgeRequest is used to read a request to the cache purge endpoint.
+type cachePurgeRequest struct {
+	Key    string `json:"key"`
+	Prefix string `json:"prefix"`
+	Tag    string `json:"tag"`
+	All    bool   `json:"all"`
+}
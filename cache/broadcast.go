@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"web-app/data"
+	"web-app/env"
+
+	"github.com/sirupsen/logrus"
+	"github.com/twinj/uuid"
+)
+
+// init selects the broadcaster used to share invalidations between server
+// instances.
+func init() {
+
+	switch mode := env.GetStringSafe(broadcastVariable, broadcastLocal); mode {
+	case broadcastLocal:
+		SetBroadcaster(NewLocalBroadcaster())
+	case broadcastDatabase:
+		SetBroadcaster(NewDatabaseBroadcaster(time.Duration(
+			env.GetIntSafe(pollIntervalVariable, 1)) * time.Second))
+	default:
+		logrus.Fatalf("invalid cache broadcaster '%s'", mode)
+	}
+
+}
+
+const (
+	// broadcastVariable defines the environment variable for the method used
+	// to share invalidations between server instances.
+	broadcastVariable = "WEB_APP_CACHE_BROADCAST"
+	// pollIntervalVariable defines the environment variable for the number of
+	// seconds between checks for invalidations made by other server instances.
+	pollIntervalVariable = "WEB_APP_CACHE_POLL_INTERVAL"
+	// broadcastLocal applies invalidations to this server instance only.
+	broadcastLocal = "local"
+	// broadcastDatabase applies invalidations to every server instance by
+	// storing them in the database.
+	broadcastDatabase = "database"
+	// pollBatchSize is the maximum number of stored invalidations read each
+	// time the database broadcaster checks for new invalidations.
+	pollBatchSize = 100
+	// pollLookback is how far back the database broadcaster reads stored
+	// invalidations. Ids are assigned when an invalidation is inserted but
+	// concurrent inserts may commit out of order, so an invalidation may only
+	// become visible after invalidations with higher ids have been read.
+	pollLookback = 30 * time.Second
+	// invalidationRetention is how long stored invalidations are kept for
+	// server instances to read, this must exceed the lookback.
+	invalidationRetention = time.Hour
+)
+
+const (
+	// InvalidationKey removes the value stored under a key.
+	InvalidationKey = "key"
+	// InvalidationPrefix removes every value whose key starts with a prefix.
+	InvalidationPrefix = "prefix"
+	// InvalidationTag removes every value stored with a tag.
+	InvalidationTag = "tag"
+)
+
+// Invalidation describes values removed from the cache by a server instance.
+type Invalidation struct {
+	Kind  string // one of key, prefix or tag
+	Value string // the key, prefix or tag of the removed values
+}
+
+// Broadcaster shares invalidations with every server instance so that values
+// are also removed from the local cache of each instance. Implementations call
+// ApplyInvalidation on every server instance other than the one the
+// invalidation was made on. Start is called once when the server starts.
+type Broadcaster interface {
+	Start() error
+	Broadcast(inv *Invalidation) error
+}
+
+// broadcaster is used to share invalidations.
+var broadcaster Broadcaster
+
+// SetBroadcaster replaces the broadcaster used to share invalidations between
+// server instances.
+func SetBroadcaster(b Broadcaster) {
+	broadcaster = b
+}
+
+// Start begins applying invalidations made by other server instances.
+func Start() error {
+	return broadcaster.Start()
+}
+
+// ApplyInvalidation removes the values described by the supplied invalidation
+// from the local cache.
+func ApplyInvalidation(inv *Invalidation) {
+	switch inv.Kind {
+	case InvalidationKey:
+		DeleteLocal(inv.Value)
+	case InvalidationPrefix:
+		DeleteLocalByPrefix(inv.Value)
+	case InvalidationTag:
+		InvalidateLocalTag(inv.Value)
+	default:
+		logrus.Warnf("ignoring unknown cache invalidation '%s'", inv.Kind)
+	}
+}
+
+// Shared checks whether values removed from the application cache store on one
+// server instance are removed for every server instance, either because the
+// store is shared or because invalidations are stored in the database. Values
+// removed while neither is the case remain cached on other instances until
+// they expire.
+func Shared() bool {
+
+	if _, ok := store.(LocalStore); !ok {
+		return true
+	}
+
+	_, ok := broadcaster.(*DatabaseBroadcaster)
+	return ok
+
+}
+
+// broadcast applies an invalidation to the local cache, unless the local cache
+// is the application cache store and has already been invalidated, and shares
+// it with the other server instances.
+func broadcast(kind, value string) error {
+
+	inv := &Invalidation{Kind: kind, Value: value}
+
+	if _, ok := store.(LocalStore); !ok {
+		ApplyInvalidation(inv)
+	}
+
+	return broadcaster.Broadcast(inv)
+
+}
+
+// LocalBroadcaster does not share invalidations. This is suitable when the
+// application is run as a single instance, or when each instance only caches
+// values in a shared store such as Redis.
+type LocalBroadcaster struct{}
+
+// NewLocalBroadcaster creates a broadcaster that does not share invalidations.
+func NewLocalBroadcaster() *LocalBroadcaster {
+	return &LocalBroadcaster{}
+}
+
+// Start does nothing as invalidations are not shared.
+func (b *LocalBroadcaster) Start() error {
+	return nil
+}
+
+// Broadcast does nothing as invalidations are not shared.
+func (b *LocalBroadcaster) Broadcast(inv *Invalidation) error {
+	return nil
+}
+
+// DatabaseBroadcaster shares invalidations with every server instance.
+// Invalidations are stored in the database and every instance polls for new
+// invalidations, so other instances may serve removed values for up to the
+// poll interval.
+type DatabaseBroadcaster struct {
+	pollInterval time.Duration
+	instance     string
+}
+
+// NewDatabaseBroadcaster creates a broadcaster that shares invalidations
+// between server instances through the database, polling for new
+// invalidations at the supplied interval.
+func NewDatabaseBroadcaster(pollInterval time.Duration) *DatabaseBroadcaster {
+	return &DatabaseBroadcaster{
+		pollInterval: pollInterval,
+		instance:     uuid.NewV4().String(),
+	}
+}
+
+// Start begins polling for invalidations stored by other server instances.
+func (b *DatabaseBroadcaster) Start() error {
+
+	// only apply invalidations made after this instance started
+	go b.pollInvalidationRecords(time.Now())
+
+	return nil
+
+}
+
+// Broadcast stores the supplied invalidation so that it is read by every
+// server instance.
+func (b *DatabaseBroadcaster) Broadcast(inv *Invalidation) error {
+	return createInvalidationRecord(context.Background(), data.DB(),
+		&invalidationRecord{
+			Instance: b.instance,
+			Kind:     inv.Kind,
+			Value:    inv.Value,
+		})
+}
+
+// pollInvalidationRecords periodically applies invalidations stored by other
+// server instances within the lookback, ignoring invalidations made before the
+// supplied time. Invalidations are tracked by id until they leave the lookback
+// so that each is applied once even if it became visible after invalidations
+// with higher ids.
+func (b *DatabaseBroadcaster) pollInvalidationRecords(since time.Time) {
+
+	// applied stores the creation time of each invalidation read within the
+	// lookback
+	applied := map[uint64]time.Time{}
+
+	for range time.Tick(b.pollInterval) {
+
+		cutoff := time.Now().Add(-pollLookback)
+		if cutoff.Before(since) {
+			cutoff = since
+		}
+
+		// forget invalidations that are no longer read
+		for id, createdAt := range applied {
+			if createdAt.Before(cutoff) {
+				delete(applied, id)
+			}
+		}
+
+		var afterID uint64
+		for {
+
+			items, err := listInvalidationRecordSince(context.Background(),
+				data.DB(), cutoff, afterID, pollBatchSize)
+			if err != nil {
+				logrus.Error(err)
+				break
+			}
+
+			for _, item := range items {
+				afterID = item.ID
+				if _, ok := applied[item.ID]; ok {
+					continue
+				}
+				applied[item.ID] = item.CreatedAt
+
+				if item.Instance != b.instance {
+					ApplyInvalidation(&Invalidation{
+						Kind:  item.Kind,
+						Value: item.Value,
+					})
+				}
+			}
+
+			// keep reading until we have caught up
+			if len(items) < pollBatchSize {
+				break
+			}
+
+		}
+
+	}
+}
@@ -2,31 +2,117 @@ package cache
 
 import (
 	"container/heap"
+	"container/list"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
+	"web-app/env"
+
 	"github.com/sirupsen/logrus"
 )
 
-// localCache is used to cache data in application memory.
+// init configures the local cache limits and starts removing expired entries.
+func init() {
+
+	localCache.maxEntries = env.GetIntSafe(maxEntriesVariable, 0)
+	localCache.maxBytes = int64(env.GetIntSafe(maxBytesVariable, 0))
+
+	switch eviction := env.GetStringSafe(evictionVariable, lruEviction); eviction {
+	case lruEviction:
+		localCache.policy = newLRUPolicy()
+	case lfuEviction:
+		localCache.policy = newLFUPolicy()
+	default:
+		logrus.Fatalf("invalid cache eviction policy '%s'", eviction)
+	}
+
+	sweepInterval := time.Duration(
+		env.GetIntSafe(sweepIntervalVariable, 5)) * time.Second
+
+	go sweepLocal(sweepInterval)
+
+}
+
+const (
+	// sweepIntervalVariable defines the environment variable for the number
+	// of seconds between sweeps of expired entries from the local cache.
+	sweepIntervalVariable = "WEB_APP_CACHE_SWEEP_INTERVAL"
+	// maxEntriesVariable defines the environment variable for the maximum
+	// number of entries kept in the local cache.
+	maxEntriesVariable = "WEB_APP_CACHE_MAX_ENTRIES"
+	// maxBytesVariable defines the environment variable for the approximate
+	// maximum number of bytes used by entries in the local cache.
+	maxBytesVariable = "WEB_APP_CACHE_MAX_BYTES"
+	// evictionVariable defines the environment variable for the policy used
+	// to choose which entries are evicted when the local cache is full.
+	evictionVariable = "WEB_APP_CACHE_EVICTION"
+	// lruEviction evicts the least recently used entry.
+	lruEviction = "lru"
+	// lfuEviction evicts the least frequently used entry.
+	lfuEviction = "lfu"
+	// sweepBatchSize is the maximum number of expired entries removed while
+	// holding the local cache lock during a sweep.
+	sweepBatchSize = 1000
+	// entryOverhead is the approximate number of bytes used by a cache entry
+	// in addition to its key and item.
+	entryOverhead = 128
+	// defaultItemSize is the approximate number of bytes assumed for items
+	// whose size cannot be determined.
+	defaultItemSize = 64
+)
+
+// localCache is used to cache data in application memory. Once the cache
+// reaches its maximum number of entries or bytes entries are evicted according
+// to the eviction policy.
 var localCache = struct {
-	mutex   *sync.Mutex
-	entries map[string]*cacheEntry
-	heap    *priorityQueue
+	mutex      *sync.Mutex
+	entries    map[string]*cacheEntry
+	tags       map[string]map[*cacheEntry]struct{}
+	queue      *expiryQueue
+	policy     evictionPolicy
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	stats      Statistics
 }{
 	mutex:   &sync.Mutex{},
 	entries: map[string]*cacheEntry{},
-	heap:    &priorityQueue{},
+	tags:    map[string]map[*cacheEntry]struct{}{},
+	queue:   &expiryQueue{},
+	policy:  newLRUPolicy(),
 }
 
-// cacheEntry stores an item in the cache along with an expiration time. When an
-// operation is performed on the cache any expired records will be removed from
-// the cache.
+// Statistics reports the usage of the local cache.
+type Statistics struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	Entries     int    `json:"entries"`
+	Bytes       int64  `json:"bytes"`
+	MaxEntries  int    `json:"max_entries"`
+	MaxBytes    int64  `json:"max_bytes"`
+	Policy      string `json:"policy"`
+}
+
+// cacheEntry stores an item in the cache along with an expiration time.
+// Expired entries are never returned and are removed from the cache by a
+// periodic sweep.
 type cacheEntry struct {
 	key     string
 	expires time.Time
 	item    interface{}
+	tags    []string
+	size    int64
+	index   int // position in the expiry queue, -1 if the entry never expires
+
+	// eviction policy state
+	element   *list.Element
+	frequency uint64
+	lastUsed  uint64
+	lfuIndex  int
 }
 
 // String returns a string representation of this cache entry.
@@ -34,34 +120,72 @@ func (c cacheEntry) String() string {
 	return fmt.Sprintf("Key: %s Expires: %v Item: %v", c.key, c.expires, c.item)
 }
 
-// SetLocal adds an item to the local cache.
+// expired checks whether the entry has expired at the supplied time.
+func (c *cacheEntry) expired(now time.Time) bool {
+	return !c.expires.IsZero() && !now.Before(c.expires)
+}
+
+// SetLocal adds an item to the local cache. The item never expires if the time
+// to live is zero or less. Items larger than the maximum size of the cache are not
+// cached.
 func SetLocal(key string, item interface{}, ttl time.Duration) {
+	SetLocalWithTags(key, item, ttl)
+}
+
+// SetLocalWithTags adds an item to the local cache that is removed when any of
+// the supplied tags is invalidated. Setting an item replaces the tags of any
+// item previously stored under the same key. See SetLocal.
+func SetLocalWithTags(key string, item interface{}, ttl time.Duration,
+	tags ...string) {
+
+	// entries without a time to live never expire
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	size := entrySize(key, item, tags)
 
 	// lock access to the local cache to prevent concurrent access
 	localCache.mutex.Lock()
 	defer localCache.mutex.Unlock()
 
-	// remove stale items from the cache
-	removeStaleLocal()
+	if localCache.maxBytes > 0 && size > localCache.maxBytes {
+		logrus.Debugf("cache item '%s' is too large to cache", key)
+		if entry, ok := localCache.entries[key]; ok {
+			removeEntry(entry)
+		}
+		return
+	}
 
 	// if the item is present in the cache update it
 	if entry, ok := localCache.entries[key]; ok {
-		entry.expires = time.Now().Add(ttl)
+		localCache.bytes += size - entry.size
 		entry.item = item
-		heap.Init(localCache.heap)
+		entry.size = size
+		setTags(entry, tags)
+		setExpiry(entry, expires)
+		localCache.policy.access(entry)
+		evictLocal(entry)
 		return
 	}
 
 	entry := &cacheEntry{
-		key:     key,
-		expires: time.Now().Add(ttl),
-		item:    item,
+		key:      key,
+		item:     item,
+		size:     size,
+		index:    -1,
+		lfuIndex: -1,
 	}
 
 	// add the item to the cache
 	logrus.Debugf("new cache entry: %v", *entry)
 	localCache.entries[key] = entry
-	heap.Push(localCache.heap, entry)
+	localCache.bytes += size
+	setTags(entry, tags)
+	setExpiry(entry, expires)
+	localCache.policy.add(entry)
+	evictLocal(entry)
 
 }
 
@@ -74,83 +198,313 @@ func GetLocal(key string) (interface{}, bool) {
 	localCache.mutex.Lock()
 	defer localCache.mutex.Unlock()
 
-	// remove stale items from the cache
-	removeStaleLocal()
+	entry, ok := getEntry(key, time.Now())
+	if !ok {
+		localCache.stats.Misses++
+		return nil, false
+	}
+
+	localCache.stats.Hits++
+	localCache.policy.access(entry)
+
+	logrus.Debugf("get cache entry: %v", *entry)
+	return entry.item, true
+
+}
+
+// DeleteLocal removes an item from the local cache.
+func DeleteLocal(key string) {
+
+	// lock access to the local cache to prevent concurrent access
+	localCache.mutex.Lock()
+	defer localCache.mutex.Unlock()
 
-	// check if the item is present in the cache
 	if entry, ok := localCache.entries[key]; ok {
-		logrus.Debugf("get cache entry: %v", *entry)
-		return entry.item, true
+		removeEntry(entry)
 	}
 
-	return nil, false
 }
 
-// removeStaleLocal removes any stale entries from the local cache.
-func removeStaleLocal() {
-	for {
-		entry, ok := localCache.heap.Peek().(*cacheEntry)
-		if ok && entry.expires.Before(time.Now()) {
-			logrus.Debugf("remove cache entry: %v", *entry)
-			delete(localCache.entries, entry.key)
-			localCache.heap.Pop()
-			continue
+// DeleteLocalByPrefix removes every item whose key starts with the supplied
+// prefix from the local cache. Every item is removed if the prefix is empty.
+// This checks every key in the local cache.
+func DeleteLocalByPrefix(prefix string) {
+
+	// lock access to the local cache to prevent concurrent access
+	localCache.mutex.Lock()
+	defer localCache.mutex.Unlock()
+
+	for key, entry := range localCache.entries {
+		if strings.HasPrefix(key, prefix) {
+			removeEntry(entry)
 		}
-		break
 	}
+
 }
 
-// priorityQueue is used to store cache entries in a way that is optimized for
-// removing stale entries.
-type priorityQueue []*cacheEntry
+// InvalidateLocalTag removes every item with the supplied tag from the local
+// cache.
+func InvalidateLocalTag(tag string) {
+
+	// lock access to the local cache to prevent concurrent access
+	localCache.mutex.Lock()
+	defer localCache.mutex.Unlock()
+
+	for entry := range localCache.tags[tag] {
+		removeEntry(entry)
+	}
 
-// Len gets the current length of the priority queue.
-func (p priorityQueue) Len() int {
-	return len(p)
 }
 
-// Less returns whether the entry at index i expires before the entry at index j.
-func (p priorityQueue) Less(i, j int) bool {
-	if i < 0 || i >= len(p) || j < 0 || j >= len(p) {
-		return false
+// TTLLocal retrieves the remaining time to live of an item in the local cache
+// and a flag that indicates whether the item was found. The time to live is
+// zero if the item never expires.
+func TTLLocal(key string) (time.Duration, bool) {
+
+	// lock access to the local cache to prevent concurrent access
+	localCache.mutex.Lock()
+	defer localCache.mutex.Unlock()
+
+	now := time.Now()
+
+	entry, ok := getEntry(key, now)
+	if !ok {
+		return 0, false
+	} else if entry.expires.IsZero() {
+		return 0, true
 	}
 
-	return p[i].expires.Before(p[j].expires)
+	return entry.expires.Sub(now), true
+
 }
 
-// Peek returns the entry with the lowest expiration time without removing it
-// from the priority queue.
-func (p *priorityQueue) Peek() interface{} {
-	if len(*p) == 0 {
-		return nil
+// getEntry retrieves the unexpired entry stored under the supplied key. An
+// expired entry is removed as it is found. The local cache must be locked.
+func getEntry(key string, now time.Time) (*cacheEntry, bool) {
+
+	entry, ok := localCache.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if entry.expired(now) {
+		logrus.Debugf("remove cache entry: %v", *entry)
+		removeEntry(entry)
+		localCache.stats.Expirations++
+		return nil, false
 	}
 
-	return (*p)[len(*p)-1]
+	return entry, true
+
 }
 
-// Pop removes and returns the entry with the lowest expiration time.
-func (p *priorityQueue) Pop() interface{} {
-	if len(*p) == 0 {
-		return nil
+// setExpiry changes the expiration time of the supplied entry, keeping the
+// expiry queue ordered. The local cache must be locked.
+func setExpiry(entry *cacheEntry, expires time.Time) {
+
+	entry.expires = expires
+
+	switch {
+	case expires.IsZero() && entry.index >= 0:
+		heap.Remove(localCache.queue, entry.index)
+	case expires.IsZero():
+	case entry.index >= 0:
+		heap.Fix(localCache.queue, entry.index)
+	default:
+		heap.Push(localCache.queue, entry)
 	}
 
-	item := (*p)[len(*p)-1]
-	*p = (*p)[0 : len(*p)-1]
-	return item
 }
 
-// Push adds a new element to the priority queue.
-func (p *priorityQueue) Push(x interface{}) {
-	entry, ok := x.(*cacheEntry)
-	if !ok {
-		logrus.Errorf("expected type *cacheEntry, got type %T: %v", x, x)
-		return
+// setTags replaces the tags of the supplied entry, keeping the tag index up to
+// date. The local cache must be locked.
+func setTags(entry *cacheEntry, tags []string) {
+
+	for _, tag := range entry.tags {
+		delete(localCache.tags[tag], entry)
+		if len(localCache.tags[tag]) == 0 {
+			delete(localCache.tags, tag)
+		}
 	}
 
-	*p = append(*p, entry)
+	entry.tags = tags
+
+	for _, tag := range tags {
+		if localCache.tags[tag] == nil {
+			localCache.tags[tag] = map[*cacheEntry]struct{}{}
+		}
+		localCache.tags[tag][entry] = struct{}{}
+	}
+
+}
+
+// removeEntry removes the supplied entry from the local cache. The local cache
+// must be locked.
+func removeEntry(entry *cacheEntry) {
+
+	delete(localCache.entries, entry.key)
+	localCache.bytes -= entry.size
+	localCache.policy.remove(entry)
+	setTags(entry, nil)
+
+	if entry.index >= 0 {
+		heap.Remove(localCache.queue, entry.index)
+	}
+
+}
+
+// removeStaleLocal removes up to the supplied number of expired entries from
+// the local cache. Returns whether expired entries may remain. The local cache
+// must be locked.
+func removeStaleLocal(now time.Time, limit int) bool {
+
+	for i := 0; i < limit; i++ {
+
+		entry := localCache.queue.Peek()
+		if entry == nil || !entry.expired(now) {
+			return false
+		}
+
+		logrus.Debugf("remove cache entry: %v", *entry)
+		removeEntry(entry)
+		localCache.stats.Expirations++
+
+	}
+
+	return true
+
+}
+
+// Stats reports the usage of the local cache.
+func Stats() Statistics {
+
+	localCache.mutex.Lock()
+	defer localCache.mutex.Unlock()
+
+	stats := localCache.stats
+	stats.Entries = len(localCache.entries)
+	stats.Bytes = localCache.bytes
+	stats.MaxEntries = localCache.maxEntries
+	stats.MaxBytes = localCache.maxBytes
+	stats.Policy = localCache.policy.name()
+
+	return stats
+
+}
+
+// evictLocal evicts entries until the local cache is within its limits. The
+// supplied entry, which has just been set, is never evicted. The local cache
+// must be locked.
+func evictLocal(keep *cacheEntry) {
+
+	for (localCache.maxEntries > 0 &&
+		len(localCache.entries) > localCache.maxEntries) ||
+		(localCache.maxBytes > 0 && localCache.bytes > localCache.maxBytes) {
+
+		// expired entries are removed before any entry is evicted
+		if removeStaleLocal(time.Now(), 1) {
+			continue
+		}
+
+		victim := localCache.policy.victim(keep)
+		if victim == nil {
+			return
+		}
+
+		logrus.Debugf("evict cache entry: %v", *victim)
+		removeEntry(victim)
+		localCache.stats.Evictions++
+
+	}
+
+}
+
+// entrySize estimates the number of bytes used by an entry with the supplied
+// key, item and tags.
+func entrySize(key string, item interface{}, tags []string) int64 {
+
+	size := int64(entryOverhead + len(key))
+	for _, tag := range tags {
+		size += int64(len(tag))
+	}
+
+	switch item := item.(type) {
+	case []byte:
+		size += int64(len(item))
+	case string:
+		size += int64(len(item))
+	default:
+		size += defaultItemSize
+	}
+
+	return size
+
 }
 
-// Swap exchanges the values at indices i and j.
-func (p priorityQueue) Swap(i, j int) {
-	p[i], p[j] = p[j], p[i]
+// sweepLocal periodically removes expired entries from the local cache so that
+// entries which are never read again do not stay in memory. Entries are
+// removed in batches so that other callers are not blocked for long.
+func sweepLocal(interval time.Duration) {
+	for range time.Tick(interval) {
+
+		now := time.Now()
+
+		for more := true; more; {
+			localCache.mutex.Lock()
+			more = removeStaleLocal(now, sweepBatchSize)
+			localCache.mutex.Unlock()
+		}
+
+	}
+}
+
+// expiryQueue is a min-heap of cache entries ordered by expiration time. Each
+// entry records its position so that it can be updated or removed in
+// logarithmic time. Entries that never expire are not queued.
+type expiryQueue []*cacheEntry
+
+// Len gets the current length of the expiry queue.
+func (q expiryQueue) Len() int {
+	return len(q)
+}
+
+// Less returns whether the entry at index i expires before the entry at index j.
+func (q expiryQueue) Less(i, j int) bool {
+	return q[i].expires.Before(q[j].expires)
+}
+
+// Swap exchanges the entries at indices i and j.
+func (q expiryQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+// Push adds a new entry to the end of the expiry queue. Use heap.Push to keep
+// the queue ordered.
+func (q *expiryQueue) Push(x interface{}) {
+	entry := x.(*cacheEntry)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+// Pop removes and returns the entry at the end of the expiry queue. Use
+// heap.Pop to remove the entry with the lowest expiration time.
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*q = old[:n-1]
+	return entry
+}
+
+// Peek returns the entry with the lowest expiration time without removing it
+// from the expiry queue. Returns nil if the queue is empty.
+func (q expiryQueue) Peek() *cacheEntry {
+	if len(q) == 0 {
+		return nil
+	}
+	return q[0]
 }
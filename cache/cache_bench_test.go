@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// benchmarkEntries is the number of entries in the local cache while
+// benchmarking.
+const benchmarkEntries = 1000000
+
+// benchmarkKeys stores the keys of the benchmark entries so that formatting
+// keys is not measured.
+var benchmarkKeys []string
+
+// fillLocal empties the local cache and adds the benchmark entries, each
+// expiring after a different time to live.
+func fillLocal(b *testing.B) {
+
+	b.Helper()
+
+	if benchmarkKeys == nil {
+		benchmarkKeys = make([]string, benchmarkEntries)
+		for i := range benchmarkKeys {
+			benchmarkKeys[i] = "key" + strconv.Itoa(i)
+		}
+	}
+
+	resetLocal(0, 0, newLRUPolicy())
+
+	for i, key := range benchmarkKeys {
+		SetLocal(key, key, time.Hour+time.Duration(i)*time.Millisecond)
+	}
+
+}
+
+func BenchmarkSetLocal(b *testing.B) {
+
+	fillLocal(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	// each new entry is pushed onto a full expiry queue
+	for i := 0; i < b.N; i++ {
+		SetLocal("new"+strconv.Itoa(i), i, time.Hour)
+	}
+
+}
+
+func BenchmarkGetLocal(b *testing.B) {
+
+	fillLocal(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		GetLocal(benchmarkKeys[i%benchmarkEntries])
+	}
+
+}
+
+func BenchmarkUpdateLocal(b *testing.B) {
+
+	fillLocal(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	// updating the time to live moves the entry within the expiry queue
+	for i := 0; i < b.N; i++ {
+		SetLocal(benchmarkKeys[i%benchmarkEntries], i,
+			2*time.Hour+time.Duration(i)*time.Millisecond)
+	}
+
+}
+
+func BenchmarkSweepLocal(b *testing.B) {
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+
+		b.StopTimer()
+		fillLocal(b)
+		b.StartTimer()
+
+		// sweep every entry in batches as the periodic sweep does
+		now := time.Now().Add(24 * time.Hour)
+		for more := true; more; {
+			localCache.mutex.Lock()
+			more = removeStaleLocal(now, sweepBatchSize)
+			localCache.mutex.Unlock()
+		}
+
+	}
+
+	b.StopTimer()
+	if n := len(localCache.entries); n != 0 {
+		b.Fatalf("sweep kept %d entries", n)
+	}
+
+}
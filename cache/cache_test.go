@@ -0,0 +1,291 @@
+package cache
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resetLocal empties the local cache and applies the supplied limits and
+// eviction policy.
+func resetLocal(maxEntries int, maxBytes int64, policy evictionPolicy) {
+
+	localCache.mutex.Lock()
+	defer localCache.mutex.Unlock()
+
+	localCache.entries = map[string]*cacheEntry{}
+	localCache.tags = map[string]map[*cacheEntry]struct{}{}
+	localCache.queue = &expiryQueue{}
+	localCache.policy = policy
+	localCache.maxEntries = maxEntries
+	localCache.maxBytes = maxBytes
+	localCache.bytes = 0
+	localCache.stats = Statistics{}
+
+}
+
+// checkLocal verifies that the expiry queue, the eviction policy, the tag index
+// and the byte count of the local cache agree with its entries.
+func checkLocal(t *testing.T) {
+
+	t.Helper()
+
+	localCache.mutex.Lock()
+	defer localCache.mutex.Unlock()
+
+	q := *localCache.queue
+	queued := 0
+	bytes := int64(0)
+
+	for key, entry := range localCache.entries {
+
+		bytes += entry.size
+
+		if entry.key != key {
+			t.Fatalf("entry %s is stored under %s", entry.key, key)
+		}
+
+		for _, tag := range entry.tags {
+			if _, ok := localCache.tags[tag][entry]; !ok {
+				t.Fatalf("entry %s is missing from tag %s", key, tag)
+			}
+		}
+
+		if entry.expires.IsZero() {
+			if entry.index != -1 {
+				t.Fatalf("entry %s never expires but has index %d", key,
+					entry.index)
+			}
+			continue
+		}
+
+		queued++
+		if entry.index < 0 || entry.index >= len(q) || q[entry.index] != entry {
+			t.Fatalf("entry %s has index %d which is not its position", key,
+				entry.index)
+		}
+
+	}
+
+	if queued != len(q) {
+		t.Fatalf("expiry queue has %d entries, want %d", len(q), queued)
+	}
+
+	for i := range q {
+		if q[i].index != i {
+			t.Fatalf("entry %s at position %d has index %d", q[i].key, i,
+				q[i].index)
+		}
+		if parent := (i - 1) / 2; i > 0 && q.Less(i, parent) {
+			t.Fatalf("entry %s at position %d expires before its parent", q[i].key,
+				i)
+		}
+	}
+
+	if bytes != localCache.bytes {
+		t.Fatalf("local cache counts %d bytes, want %d", localCache.bytes, bytes)
+	}
+
+	for tag, entries := range localCache.tags {
+		for entry := range entries {
+			if localCache.entries[entry.key] != entry {
+				t.Fatalf("tag %s refers to removed entry %s", tag, entry.key)
+			}
+		}
+	}
+
+	checkPolicy(t)
+
+}
+
+// checkPolicy verifies that the eviction policy tracks exactly the entries of
+// the local cache. The local cache must be locked.
+func checkPolicy(t *testing.T) {
+
+	t.Helper()
+
+	switch policy := localCache.policy.(type) {
+	case *lruPolicy:
+
+		if policy.order.Len() != len(localCache.entries) {
+			t.Fatalf("LRU list has %d entries, want %d", policy.order.Len(),
+				len(localCache.entries))
+		}
+
+		for element := policy.order.Front(); element != nil; element = element.Next() {
+			entry := element.Value.(*cacheEntry)
+			if entry.element != element || localCache.entries[entry.key] != entry {
+				t.Fatalf("LRU list element of %s is stale", entry.key)
+			}
+		}
+
+	case *lfuPolicy:
+
+		q := *policy.queue
+		if len(q) != len(localCache.entries) {
+			t.Fatalf("frequency queue has %d entries, want %d", len(q),
+				len(localCache.entries))
+		}
+
+		for i := range q {
+			if q[i].lfuIndex != i || localCache.entries[q[i].key] != q[i] {
+				t.Fatalf("entry %s at position %d has index %d", q[i].key, i,
+					q[i].lfuIndex)
+			}
+			if parent := (i - 1) / 2; i > 0 && q.Less(i, parent) {
+				t.Fatalf("entry %s at position %d is used less than its parent",
+					q[i].key, i)
+			}
+		}
+
+	}
+
+}
+
+func TestExpiryQueueInvariants(t *testing.T) {
+
+	for _, policy := range []evictionPolicy{newLRUPolicy(), newLFUPolicy()} {
+		t.Run(policy.name(), func(t *testing.T) {
+
+			resetLocal(0, 0, policy)
+
+			random := rand.New(rand.NewSource(1))
+			ttls := []time.Duration{0, time.Minute, time.Hour, 2 * time.Hour}
+
+			for i := 0; i < 5000; i++ {
+
+				key := strconv.Itoa(random.Intn(200))
+
+				// setting existing keys moves them within the queue with
+				// heap.Fix, or adds or removes them when they gain or lose a
+				// time to live
+				switch op := random.Intn(10); {
+				case op < 6:
+					SetLocalWithTags(key, key, ttls[random.Intn(len(ttls))],
+						"tag"+strconv.Itoa(random.Intn(5)))
+				case op < 8:
+					GetLocal(key)
+				case op < 9:
+					DeleteLocal(key)
+				default:
+					InvalidateLocalTag("tag" + strconv.Itoa(random.Intn(5)))
+				}
+
+				if i%50 == 0 {
+					checkLocal(t)
+				}
+
+			}
+
+			checkLocal(t)
+
+		})
+	}
+
+}
+
+func TestRemoveStaleLocal(t *testing.T) {
+
+	resetLocal(0, 0, newLRUPolicy())
+
+	for i := 0; i < 10; i++ {
+		SetLocal("expiring"+strconv.Itoa(i), i, time.Duration(10-i)*time.Minute)
+	}
+	SetLocal("persistent", 0, 0)
+
+	// entries are removed in order of expiration in batches
+	later := time.Now().Add(5*time.Minute + time.Second)
+
+	localCache.mutex.Lock()
+	more := removeStaleLocal(later, 3)
+	localCache.mutex.Unlock()
+
+	if !more {
+		t.Error("removeStaleLocal() = false with expired entries remaining")
+	}
+	for i := 7; i < 10; i++ {
+		if _, ok := localCache.entries["expiring"+strconv.Itoa(i)]; ok {
+			t.Errorf("expiring%d was not removed first", i)
+		}
+	}
+	checkLocal(t)
+
+	localCache.mutex.Lock()
+	more = removeStaleLocal(later, 10)
+	localCache.mutex.Unlock()
+
+	if more {
+		t.Error("removeStaleLocal() = true with no expired entries remaining")
+	}
+
+	if stats := Stats(); stats.Entries != 6 || stats.Expirations != 5 {
+		t.Errorf("Stats() = %d entries and %d expirations, want 6 and 5",
+			stats.Entries, stats.Expirations)
+	}
+	checkLocal(t)
+
+	if _, ok := GetLocal("persistent"); !ok {
+		t.Error("GetLocal(persistent) removed an entry that never expires")
+	}
+
+}
+
+func TestLocalExpiry(t *testing.T) {
+
+	resetLocal(0, 0, newLRUPolicy())
+
+	SetLocal("key", "value", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if _, ok := GetLocal("key"); ok {
+		t.Error("GetLocal() returned an expired entry")
+	}
+	if _, ok := TTLLocal("key"); ok {
+		t.Error("TTLLocal() found an expired entry")
+	}
+
+	// removing the time to live of an entry takes it out of the queue
+	SetLocal("key", "value", time.Hour)
+	SetLocal("key", "value", 0)
+
+	if ttl, ok := TTLLocal("key"); !ok || ttl != 0 {
+		t.Errorf("TTLLocal() = %v, %v, want no expiry", ttl, ok)
+	}
+	checkLocal(t)
+
+	// a negative time to live never expires either
+	SetLocal("negative", "value", -time.Second)
+	if _, ok := GetLocal("negative"); !ok {
+		t.Error("GetLocal() expired an entry with a negative time to live")
+	}
+	checkLocal(t)
+
+}
+
+func TestLocalConcurrentAccess(t *testing.T) {
+
+	resetLocal(100, 0, newLFUPolicy())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(seed))
+			for j := 0; j < 2000; j++ {
+				key := strconv.Itoa(random.Intn(300))
+				if random.Intn(2) == 0 {
+					SetLocal(key, key, time.Duration(random.Intn(3))*time.Minute)
+				} else {
+					GetLocal(key)
+				}
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+
+	checkLocal(t)
+
+}
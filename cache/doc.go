@@ -1,2 +1,72 @@
 // Package cache provides middlewares and functions for caching data.
+//
+// Cached data is kept in a store. By default the store keeps data in
+// application memory, in which case each server instance has its own cache.
+// When the application is run with more than one server instance a Redis store
+// may be used so that cached data is shared between instances.
+//
+// The cache middleware stores responses to GET requests. By default only 200 -
+// OK responses to requests without credentials are cached; a Policy may cache
+// other statuses, store extra response headers, and keep separate responses
+// for each user or for the values of selected request headers. Cache-Control
+// no-store and private directives from clients and handlers are honored.
+//
+// Concurrent cache misses for the same key are coalesced so that a handler,
+// or a loader passed to GetOrLoad, runs once and its result is shared. Expired
+// values may continue to be served for a stale window while they are
+// refreshed.
+//
+// Every store, and the middleware, keeps values with a time to live of zero or
+// less until they are removed rather than letting them expire immediately.
+//
+// Values may be removed by key, by key prefix, or by tag using Delete,
+// DeleteByPrefix and InvalidateTag. Removals are applied to the local cache of
+// every server instance when using the database broadcaster, in which case
+// Start must be called when the server starts.
+//
+// The local cache may be limited to a maximum number of entries or an
+// approximate number of bytes. Once a limit is reached the least recently used
+// or least frequently used entries are evicted. Stats reports the usage of the
+// local cache.
+//
+// Environment:
+//     WEB_APP_CACHE_SWEEP_INTERVAL
+//         int - the number of seconds between sweeps of expired entries from
+//               the local cache.
+//               Default: 5
+//     WEB_APP_CACHE_MAX_ENTRIES
+//         int - the maximum number of entries kept in the local cache, or 0
+//               for no limit.
+//               Default: 0
+//     WEB_APP_CACHE_MAX_BYTES
+//         int - the approximate maximum number of bytes used by entries in the
+//               local cache, or 0 for no limit.
+//               Default: 0
+//     WEB_APP_CACHE_EVICTION
+//         string - the policy used to evict entries from the local cache once
+//                  it is full; one of lru or lfu.
+//                  Default: lru
+//     WEB_APP_CACHE_STALE_WINDOW
+//         int - the number of seconds an expired value may be served while it
+//               is refreshed, or 0 to never serve expired values.
+//               Default: 0
+//     WEB_APP_CACHE_BROADCAST
+//         string - the method used to share removals of cached values between
+//                  server instances; one of local or database.
+//                  Default: local
+//     WEB_APP_CACHE_POLL_INTERVAL
+//         int - the number of seconds between checks for removals made by
+//               other server instances when using the database broadcaster.
+//               Default: 1
+//     WEB_APP_CACHE_STORE
+//         string - the store used to cache data; one of local or redis.
+//                  Default: local
+//     WEB_APP_REDIS_ADDRESS
+//         string - the address of the Redis server used to cache data.
+//                  Default: localhost:6379
+//     WEB_APP_REDIS_PASSWORD
+//         string - the password used to authenticate with the Redis server.
+//     WEB_APP_REDIS_DB
+//         int - the Redis database number used to cache data.
+//               Default: 0
 package cache
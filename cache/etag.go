@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ETagMiddleware gets middleware that adds validators to responses to GET
+// requests and honors conditional requests. A strong ETag is computed from the
+// response body unless the handler has already set one. Handlers may also set
+// a Last-Modified header, typically from a model's UpdatedAt field, using
+// SetLastModified. If the request If-None-Match or If-Modified-Since header
+// matches the response the client receives a 304 - Not Modified response
+// without a body.
+//
+// When used with the cache middleware this middleware should be bound first so
+// that both cached and uncached responses carry the same validators.
+func ETagMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		// only handle GET and HEAD requests
+		if c.Request.Method != http.MethodGet &&
+			c.Request.Method != http.MethodHead {
+			c.Next()
+			return
+		}
+
+		// buffer the response so the ETag can be computed before the response
+		// is written
+		writer := &bufferedWriter{
+			ResponseWriter: c.Writer,
+		}
+		c.Writer = writer
+
+		c.Next()
+
+		c.Writer = writer.ResponseWriter
+
+		// streaming responses have already been written
+		if writer.streaming {
+			return
+		}
+
+		body := writer.responseData.Bytes()
+
+		if c.Writer.Status() == http.StatusOK &&
+			c.Writer.Header().Get("ETag") == "" {
+			c.Writer.Header().Set("ETag", computeETag(body))
+		}
+
+		writeConditional(c, c.Writer.Status(), body)
+
+	}
+}
+
+// SetLastModified sets the Last-Modified header of the response. This allows
+// ETagMiddleware and the cache middleware to honor If-Modified-Since headers.
+func SetLastModified(c *gin.Context, lastModified time.Time) {
+	c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+}
+
+// writeConditional writes the supplied response, replacing it with a 304 - Not
+// Modified response if the request is conditional and the response validators
+// match.
+func writeConditional(c *gin.Context, status int, body []byte) {
+
+	if status == http.StatusOK && notModified(c.Request, c.Writer.Header()) {
+		header := c.Writer.Header()
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		c.Writer.WriteHeader(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+
+	c.Writer.WriteHeader(status)
+
+	if c.Request.Method != http.MethodHead && len(body) > 0 {
+		c.Writer.Write(body)
+	}
+
+}
+
+// notModified checks whether the request validators match the validators of
+// the response. If-None-Match takes precedence over If-Modified-Since.
+func notModified(r *http.Request, header http.Header) bool {
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+
+		// GET requests use weak comparison so the W/ prefix is ignored
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || weakETag(candidate) == weakETag(etag) {
+				return true
+			}
+		}
+
+		return false
+
+	}
+
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.Truncate(time.Second).After(ifModifiedSince)
+
+}
+
+// computeETag computes a strong ETag from the supplied response body.
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// weakETag strips the weak validator prefix from the supplied ETag.
+func weakETag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
+
+// bufferedWriter is used to wrap the response writer used by the ETag
+// middleware to hold the response until validators have been computed.
+// Responses that are flushed by the handler are streamed to the client.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	responseData bytes.Buffer
+	streaming    bool
+}
+
+// Write records response data without writing the response.
+func (b *bufferedWriter) Write(body []byte) (int, error) {
+	if b.streaming {
+		return b.ResponseWriter.Write(body)
+	}
+	return b.responseData.Write(body)
+}
+
+// WriteString records response data without writing the response.
+func (b *bufferedWriter) WriteString(s string) (int, error) {
+	return b.Write([]byte(s))
+}
+
+// Written checks whether any part of the response has been written.
+func (b *bufferedWriter) Written() bool {
+	return b.responseData.Len() > 0 || b.ResponseWriter.Written()
+}
+
+// Flush switches the writer to streaming the response, writing any buffered
+// data to the client.
+func (b *bufferedWriter) Flush() {
+
+	if !b.streaming {
+		b.streaming = true
+		if b.responseData.Len() > 0 {
+			b.ResponseWriter.Write(b.responseData.Bytes())
+			b.responseData.Reset()
+		}
+	}
+
+	b.ResponseWriter.Flush()
+
+}
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// evictionPolicy chooses which entry is evicted when the local cache is full.
+// Policies are only called while the local cache is locked.
+type evictionPolicy interface {
+	name() string
+	add(entry *cacheEntry)
+	access(entry *cacheEntry)
+	remove(entry *cacheEntry)
+	victim(keep *cacheEntry) *cacheEntry
+}
+
+// lruPolicy evicts the least recently used entry. Entries are kept in a list
+// ordered from most to least recently used.
+type lruPolicy struct {
+	order *list.List
+}
+
+// newLRUPolicy creates a least recently used eviction policy.
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{order: list.New()}
+}
+
+// name gets the name of the policy.
+func (p *lruPolicy) name() string {
+	return lruEviction
+}
+
+// add tracks a new entry as the most recently used.
+func (p *lruPolicy) add(entry *cacheEntry) {
+	entry.element = p.order.PushFront(entry)
+}
+
+// access marks the entry as the most recently used.
+func (p *lruPolicy) access(entry *cacheEntry) {
+	if entry.element != nil {
+		p.order.MoveToFront(entry.element)
+	}
+}
+
+// remove stops tracking the entry.
+func (p *lruPolicy) remove(entry *cacheEntry) {
+	if entry.element != nil {
+		p.order.Remove(entry.element)
+		entry.element = nil
+	}
+}
+
+// victim gets the least recently used entry other than the supplied entry.
+// Returns nil if there is none.
+func (p *lruPolicy) victim(keep *cacheEntry) *cacheEntry {
+
+	for element := p.order.Back(); element != nil; element = element.Prev() {
+		if entry := element.Value.(*cacheEntry); entry != keep {
+			return entry
+		}
+	}
+
+	return nil
+
+}
+
+// lfuPolicy evicts the least frequently used entry, choosing the least recently
+// used of those used equally often.
+type lfuPolicy struct {
+	queue *frequencyQueue
+	clock uint64
+}
+
+// newLFUPolicy creates a least frequently used eviction policy.
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{queue: &frequencyQueue{}}
+}
+
+// name gets the name of the policy.
+func (p *lfuPolicy) name() string {
+	return lfuEviction
+}
+
+// add tracks a new entry as used once.
+func (p *lfuPolicy) add(entry *cacheEntry) {
+	p.clock++
+	entry.frequency = 1
+	entry.lastUsed = p.clock
+	heap.Push(p.queue, entry)
+}
+
+// access counts a use of the entry.
+func (p *lfuPolicy) access(entry *cacheEntry) {
+
+	if entry.lfuIndex < 0 {
+		return
+	}
+
+	p.clock++
+	entry.frequency++
+	entry.lastUsed = p.clock
+	heap.Fix(p.queue, entry.lfuIndex)
+
+}
+
+// remove stops tracking the entry.
+func (p *lfuPolicy) remove(entry *cacheEntry) {
+	if entry.lfuIndex >= 0 {
+		heap.Remove(p.queue, entry.lfuIndex)
+	}
+}
+
+// victim gets the least frequently used entry other than the supplied entry.
+// Returns nil if there is none.
+func (p *lfuPolicy) victim(keep *cacheEntry) *cacheEntry {
+
+	q := *p.queue
+
+	switch {
+	case len(q) == 0:
+		return nil
+	case q[0] != keep:
+		return q[0]
+	}
+
+	// the next least frequently used entry is one of the children of the root
+	var victim *cacheEntry
+	for _, i := range []int{1, 2} {
+		if i < len(q) && (victim == nil || q.Less(i, victim.lfuIndex)) {
+			victim = q[i]
+		}
+	}
+
+	return victim
+
+}
+
+// frequencyQueue is a min-heap of cache entries ordered by use count and then
+// by last use. Each entry records its position so that it can be updated or
+// removed in logarithmic time.
+type frequencyQueue []*cacheEntry
+
+// Len gets the current length of the frequency queue.
+func (q frequencyQueue) Len() int {
+	return len(q)
+}
+
+// Less returns whether the entry at index i should be evicted before the entry
+// at index j.
+func (q frequencyQueue) Less(i, j int) bool {
+	if q[i].frequency != q[j].frequency {
+		return q[i].frequency < q[j].frequency
+	}
+	return q[i].lastUsed < q[j].lastUsed
+}
+
+// Swap exchanges the entries at indices i and j.
+func (q frequencyQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].lfuIndex = i
+	q[j].lfuIndex = j
+}
+
+// Push adds a new entry to the end of the frequency queue. Use heap.Push to
+// keep the queue ordered.
+func (q *frequencyQueue) Push(x interface{}) {
+	entry := x.(*cacheEntry)
+	entry.lfuIndex = len(*q)
+	*q = append(*q, entry)
+}
+
+// Pop removes and returns the entry at the end of the frequency queue. Use
+// heap.Pop to remove the least frequently used entry.
+func (q *frequencyQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.lfuIndex = -1
+	*q = old[:n-1]
+	return entry
+}
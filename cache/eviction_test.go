@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// cachedKeys gets which of the supplied keys are in the local cache.
+func cachedKeys(keys ...string) map[string]bool {
+
+	localCache.mutex.Lock()
+	defer localCache.mutex.Unlock()
+
+	cached := map[string]bool{}
+	for _, key := range keys {
+		_, cached[key] = localCache.entries[key]
+	}
+
+	return cached
+
+}
+
+// checkCached verifies which of the supplied keys are in the local cache.
+func checkCached(t *testing.T, want map[string]bool) {
+
+	t.Helper()
+
+	keys := make([]string, 0, len(want))
+	for key := range want {
+		keys = append(keys, key)
+	}
+
+	for key, cached := range cachedKeys(keys...) {
+		if cached != want[key] {
+			t.Errorf("%s cached = %v, want %v", key, cached, want[key])
+		}
+	}
+
+}
+
+func TestLRUEviction(t *testing.T) {
+
+	resetLocal(3, 0, newLRUPolicy())
+
+	SetLocal("a", "a", 0)
+	SetLocal("b", "b", 0)
+	SetLocal("c", "c", 0)
+
+	// reading a and updating b makes c the least recently used
+	GetLocal("a")
+	SetLocal("b", "b2", 0)
+	SetLocal("d", "d", 0)
+
+	checkCached(t, map[string]bool{"a": true, "b": true, "c": false, "d": true})
+
+	SetLocal("e", "e", 0)
+	checkCached(t, map[string]bool{"a": false, "b": true, "d": true, "e": true})
+
+	if stats := Stats(); stats.Evictions != 2 || stats.Entries != 3 {
+		t.Errorf("Stats() = %d evictions and %d entries, want 2 and 3",
+			stats.Evictions, stats.Entries)
+	}
+	checkLocal(t)
+
+}
+
+func TestLFUEviction(t *testing.T) {
+
+	resetLocal(3, 0, newLFUPolicy())
+
+	SetLocal("a", "a", 0)
+	SetLocal("b", "b", 0)
+	SetLocal("c", "c", 0)
+
+	for i := 0; i < 3; i++ {
+		GetLocal("a")
+	}
+	GetLocal("c")
+
+	// b is used least often
+	SetLocal("d", "d", 0)
+	checkCached(t, map[string]bool{"a": true, "b": false, "c": true, "d": true})
+
+	// c and d have each been used twice, c was used less recently
+	GetLocal("d")
+	SetLocal("e", "e", 0)
+	checkCached(t, map[string]bool{"a": true, "c": false, "d": true, "e": true})
+
+	checkLocal(t)
+
+}
+
+func TestEvictionKeepsNewEntry(t *testing.T) {
+
+	for _, policy := range []evictionPolicy{newLRUPolicy(), newLFUPolicy()} {
+		t.Run(policy.name(), func(t *testing.T) {
+
+			resetLocal(2, 0, policy)
+
+			SetLocal("a", "a", 0)
+			SetLocal("b", "b", 0)
+			for i := 0; i < 5; i++ {
+				GetLocal("a")
+				GetLocal("b")
+			}
+
+			// the new entry is the least used but is never its own victim
+			SetLocal("c", "c", 0)
+			if cached := cachedKeys("c"); !cached["c"] {
+				t.Error("the entry being set was evicted")
+			}
+
+			if stats := Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+				t.Errorf("Stats() = %d entries and %d evictions, want 2 and 1",
+					stats.Entries, stats.Evictions)
+			}
+			checkLocal(t)
+
+		})
+	}
+
+}
+
+func TestEvictionByBytes(t *testing.T) {
+
+	for _, policy := range []evictionPolicy{newLRUPolicy(), newLFUPolicy()} {
+		t.Run(policy.name(), func(t *testing.T) {
+
+			value := make([]byte, 1000)
+			size := entrySize("k0", value, nil)
+
+			resetLocal(0, 3*size, policy)
+
+			for i := 0; i < 5; i++ {
+				SetLocal("k"+strconv.Itoa(i), value, 0)
+			}
+
+			stats := Stats()
+			if stats.Entries != 3 || stats.Bytes > 3*size {
+				t.Errorf("Stats() = %d entries and %d bytes, want 3 and at most %d",
+					stats.Entries, stats.Bytes, 3*size)
+			}
+
+			// items larger than the cache are not cached and replace the
+			// item stored under the same key
+			SetLocal("k4", make([]byte, 4*size), 0)
+			if _, ok := GetLocal("k4"); ok {
+				t.Error("an item larger than the cache was cached")
+			}
+
+			checkLocal(t)
+
+		})
+	}
+
+}
+
+func TestEvictionPrefersExpired(t *testing.T) {
+
+	for _, policy := range []evictionPolicy{newLRUPolicy(), newLFUPolicy()} {
+		t.Run(policy.name(), func(t *testing.T) {
+
+			resetLocal(2, 0, policy)
+
+			SetLocal("expired", "expired", time.Nanosecond)
+			SetLocal("old", "old", 0)
+			time.Sleep(time.Millisecond)
+
+			// the expired entry is removed even though old is used less
+			GetLocal("old")
+			SetLocal("expired", "expired", time.Nanosecond)
+			time.Sleep(time.Millisecond)
+			SetLocal("new", "new", 0)
+
+			checkCached(t, map[string]bool{"expired": false, "old": true,
+				"new": true})
+
+			if stats := Stats(); stats.Evictions != 0 || stats.Expirations != 1 {
+				t.Errorf("Stats() = %d evictions and %d expirations, want 0 and 1",
+					stats.Evictions, stats.Expirations)
+			}
+			checkLocal(t)
+
+		})
+	}
+
+}
+
+func TestLFUVictimSkipsRoot(t *testing.T) {
+
+	resetLocal(0, 0, newLFUPolicy())
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		SetLocal(key, key, 0)
+	}
+	GetLocal("b")
+	GetLocal("c")
+	GetLocal("c")
+
+	localCache.mutex.Lock()
+	defer localCache.mutex.Unlock()
+
+	policy := localCache.policy.(*lfuPolicy)
+	root := localCache.entries["a"]
+
+	// a is the least used, d is next as it was used once more recently
+	if victim := policy.victim(nil); victim != root {
+		t.Errorf("victim(nil) = %v, want a", victim)
+	}
+	if victim := policy.victim(root); victim != localCache.entries["d"] {
+		t.Errorf("victim(a) = %v, want d", victim)
+	}
+
+}
@@ -0,0 +1,26 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"web-app/data"
+	"web-app/jobs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// init registers jobs that remove stored invalidations once every server
+// instance has applied them.
+func init() {
+	if err := jobs.Register(&jobs.Job{
+		Name:     "cache_invalidation_retention",
+		Schedule: "50 * * * *",
+		Run: func(ctx context.Context) error {
+			return DeleteInvalidationRecordsBefore(ctx, data.DB(),
+				time.Now().Add(-invalidationRetention))
+		},
+	}); err != nil {
+		logrus.Fatal(err)
+	}
+}
@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"web-app/env"
+
+	"github.com/sirupsen/logrus"
+)
+
+// init configures how long stale cached values may be served.
+func init() {
+	staleWindow = time.Duration(
+		env.GetIntSafe(staleWindowVariable, 0)) * time.Second
+}
+
+const (
+	// staleWindowVariable defines the environment variable for the number of
+	// seconds an expired value may be served while it is refreshed.
+	staleWindowVariable = "WEB_APP_CACHE_STALE_WINDOW"
+	// loadHeaderSize is the size of the header stored with values cached by
+	// GetOrLoad.
+	loadHeaderSize = 8
+)
+
+// errLoadFailed is returned to callers waiting for a load that did not
+// complete.
+var errLoadFailed = errors.New("cache: load did not complete")
+
+// staleWindow determines how long an expired value may be served while it is
+// refreshed. Stale values are never served if this is zero.
+var staleWindow time.Duration
+
+const (
+	// loadFlightPrefix prefixes the keys of loads started by GetOrLoad.
+	loadFlightPrefix = "load:"
+	// responseFlightPrefix prefixes the keys of responses produced by the
+	// cache middleware.
+	responseFlightPrefix = "response:"
+)
+
+// loads coalesces concurrent loads of the same key. Callers prefix their keys
+// so that different kinds of load of the same key are never shared.
+var loads = &flightGroup{
+	mutex:   &sync.Mutex{},
+	flights: map[string]*flight{},
+}
+
+// GetOrLoad retrieves the value stored under the supplied key in the
+// application cache store, calling the loader to produce the value and caching
+// it with the specified time to live if it is not found. Concurrent calls for
+// the same key share a single call of the loader. Loader errors are returned
+// and never cached.
+//
+// Once the time to live has passed the value continues to be returned for the
+// configured stale window while a single call of the loader refreshes it in
+// the background.
+//
+// Values cached by GetOrLoad carry a small header and should only be read
+// using GetOrLoad.
+func GetOrLoad(key string, ttl time.Duration,
+	loader func() ([]byte, error)) ([]byte, error) {
+
+	s := store
+
+	value, expires, ok := getLoaded(s, key)
+	if ok && (expires.IsZero() || time.Now().Before(expires)) {
+		return value, nil
+	}
+
+	// refresh stale values in the background unless a refresh is running
+	if ok {
+		if f, leader := loads.start(loadFlightPrefix + key); leader {
+			go func() {
+				if _, err := loadShared(s, key, ttl, loader, f); err != nil {
+					logrus.Error(err)
+				}
+			}()
+		}
+		return value, nil
+	}
+
+	f, leader := loads.start(loadFlightPrefix + key)
+	if !leader {
+		<-f.done
+		return f.value, f.err
+	}
+
+	return loadShared(s, key, ttl, loader, f)
+
+}
+
+// loadShared loads a value as the caller that started the supplied flight,
+// sharing the result with the callers waiting for it.
+func loadShared(s Store, key string, ttl time.Duration,
+	loader func() ([]byte, error), f *flight) (value []byte, err error) {
+
+	// waiting callers are released even if the loader panics
+	err = errLoadFailed
+	defer func() {
+		loads.finish(loadFlightPrefix+key, f, value, err == nil, err)
+	}()
+
+	return load(s, key, ttl, loader)
+
+}
+
+// load calls the loader and caches the value it produces in the supplied store.
+func load(s Store, key string, ttl time.Duration,
+	loader func() ([]byte, error)) ([]byte, error) {
+
+	value, err := loader()
+	if err != nil {
+		return nil, err
+	}
+
+	// the expiration time is stored with the value so that stale values are
+	// recognized while they remain in the store
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).UnixNano()
+	}
+
+	item := make([]byte, loadHeaderSize+len(value))
+	binary.BigEndian.PutUint64(item, uint64(expires))
+	copy(item[loadHeaderSize:], value)
+
+	if err := s.Set(key, item, storedTTL(ttl)); err != nil {
+		logrus.Error(err)
+	}
+
+	return value, nil
+
+}
+
+// getLoaded retrieves a value cached by GetOrLoad and the time it expires. The
+// expiration time is zero if the value never expires. Store errors are logged
+// and treated as a cache miss.
+func getLoaded(s Store, key string) ([]byte, time.Time, bool) {
+
+	item, ok, err := s.Get(key)
+	if err != nil {
+		logrus.Error(err)
+		return nil, time.Time{}, false
+	} else if !ok || len(item) < loadHeaderSize {
+		return nil, time.Time{}, false
+	}
+
+	var expires time.Time
+	if ns := int64(binary.BigEndian.Uint64(item)); ns != 0 {
+		expires = time.Unix(0, ns)
+	}
+
+	return item[loadHeaderSize:], expires, true
+
+}
+
+// storedTTL gets the time to live of a value in the store, which includes the
+// stale window so the value may still be served once it has expired.
+func storedTTL(ttl time.Duration) time.Duration {
+
+	if ttl <= 0 {
+		return 0
+	}
+
+	return ttl + staleWindow
+
+}
+
+// flight is a load of a single key that is in progress. The result is
+// available once done is closed.
+type flight struct {
+	done  chan struct{}
+	value []byte
+	ok    bool
+	err   error
+}
+
+// flightGroup tracks the loads in progress so that concurrent loads of the
+// same key are coalesced.
+type flightGroup struct {
+	mutex   *sync.Mutex
+	flights map[string]*flight
+}
+
+// start joins the load of the supplied key. Returns the flight and whether the
+// caller started it, in which case the caller must call finish once the load
+// is complete. Other callers may wait for the flight to be done.
+func (g *flightGroup) start(key string) (*flight, bool) {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+
+	return f, true
+
+}
+
+// finish records the result of a load and releases the callers waiting for
+// it. Calling finish more than once has no effect.
+func (g *flightGroup) finish(key string, f *flight, value []byte, ok bool,
+	err error) {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.flights[key] != f {
+		return
+	}
+
+	delete(g.flights, key)
+
+	f.value = value
+	f.ok = ok
+	f.err = err
+	close(f.done)
+
+}
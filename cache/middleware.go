@@ -2,16 +2,95 @@ package cache
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"web-app/identity"
+
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
+// Policy determines which responses the cache middleware stores and which
+// requests share a cached response.
+type Policy struct {
+	// Statuses lists the response status codes that are cached. Defaults to
+	// 200 - OK.
+	Statuses []int
+	// Headers lists the response headers stored and replayed with cached
+	// responses in addition to Content-Type, ETag and Last-Modified.
+	Headers []string
+	// Vary lists the request headers whose values select a separate cached
+	// response, such as Accept-Encoding or Accept-Language.
+	Vary []string
+	// VaryUser caches responses separately for each user. Requests that carry
+	// credentials are never cached unless this is set, so that one user's
+	// response is never served to another.
+	VaryUser bool
+}
+
+// defaultPolicy is used by the cache middleware when no policy is supplied.
+var defaultPolicy = &Policy{}
+
+// Middleware responds with values from the application cache store if
+// possible. See StoreMiddleware.
+func Middleware(ttl time.Duration) gin.HandlerFunc {
+	return MiddlewareWithPolicy(ttl, nil)
+}
+
+// MiddlewareWithPolicy responds with values from the application cache store
+// if possible, caching responses according to the supplied policy. See
+// StoreMiddleware.
+func MiddlewareWithPolicy(ttl time.Duration, policy *Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		StoreMiddleware(store, ttl, policy)(c)
+	}
+}
+
 // LocalCacheMiddleware responds with values from the local cache if possible.
-// If the request is not present in the cache add it to the cache with the
-// specified time to live.
+// See StoreMiddleware.
 func LocalCacheMiddleware(ttl time.Duration) gin.HandlerFunc {
+	return StoreMiddleware(LocalStore{}, ttl, nil)
+}
+
+// StoreMiddleware responds with values from the supplied store if possible.
+// If the request is not present in the store add it to the store with the
+// specified time to live, a time to live of zero or less caches the response
+// until it is removed. Responses are cached before any response compression
+// is applied so cached entries always store the uncompressed response body.
+//
+// Concurrent requests for a response that is not cached wait for a single
+// request to run the handler and are then served its response. Once a response
+// has expired it continues to be served for the configured stale window while
+// a single request runs the handler to refresh it. Requests with a
+// Cache-Control header of no-cache are never served a cached response but
+// still wait for a request that is already running the handler.
+//
+// Only responses to GET requests with a status listed in the policy are cached,
+// and the default policy is used if the supplied policy is nil. Requests and
+// responses with a Cache-Control header of no-store bypass the cache, as do
+// responses that set cookies or vary on request headers not listed in the
+// policy. Requests with credentials and private responses are only cached if
+// the policy varies on the user.
+func StoreMiddleware(s Store, ttl time.Duration, policy *Policy) gin.HandlerFunc {
+
+	if policy == nil {
+		policy = defaultPolicy
+	}
+
+	statuses := map[int]bool{}
+	for _, status := range policy.Statuses {
+		statuses[status] = true
+	}
+	if len(statuses) == 0 {
+		statuses[http.StatusOK] = true
+	}
+
 	return func(c *gin.Context) {
 
 		// only cache responses to GET requests
@@ -20,18 +99,63 @@ func LocalCacheMiddleware(ttl time.Duration) gin.HandlerFunc {
 			return
 		}
 
-		// use the path and GET query as the cache key
-		key := c.Request.URL.EscapedPath() + "?" + c.Request.URL.Query().Encode()
+		requestDirectives := cacheControl(c.Request.Header)
+		if requestDirectives["no-store"] || requestDirectives["private"] ||
+			(!policy.VaryUser && hasCredentials(c)) {
+			c.Next()
+			return
+		}
+
+		key := policy.key(c)
 
 		// check if the request is cached, if so respond with the cached value
-		if item, ok := GetLocal(key); ok {
-			if resp, ok := item.(responseCacheItem); ok {
-				c.Data(http.StatusOK, resp.ContentType, resp.Data)
+		// and validators, honoring any conditional request headers. Clients
+		// may ask for a fresh response using no-cache
+		var resp *responseCacheItem
+		var found bool
+		if !requestDirectives["no-cache"] {
+			resp, found = getResponse(s, key)
+			if found && resp.fresh(time.Now()) {
+				resp.write(c)
 				c.Abort()
 				return
 			}
 		}
 
+		// concurrent requests for the same response wait for a single request
+		// to run the handler, or are served the stale response. Requests with
+		// no-cache wait as well so that they cannot bypass the coalescing and
+		// all run the handler at once, the response they wait for is produced
+		// after they were received
+		f, leader := loads.start(responseFlightPrefix + key)
+		if !leader {
+
+			if found {
+				resp.write(c)
+				c.Abort()
+				return
+			}
+
+			<-f.done
+			if resp, ok := decodeResponse(f.value); f.ok && ok {
+				resp.write(c)
+				c.Abort()
+				return
+			}
+
+			// the response could not be shared so run the handler
+			c.Next()
+			return
+
+		}
+
+		// share the cached response, if any, with waiting requests even if the
+		// handler panics
+		var shared []byte
+		defer func() {
+			loads.finish(responseFlightPrefix+key, f, shared, shared != nil, nil)
+		}()
+
 		// wrap the response writer so we can record the response to the
 		// incoming request
 		writer := &responseWriter{
@@ -43,20 +167,252 @@ func LocalCacheMiddleware(ttl time.Duration) gin.HandlerFunc {
 		// execute the next handler function
 		c.Next()
 
-		// cache the response
-		SetLocal(key, responseCacheItem{
-			ContentType: c.Writer.Header().Get("Content-Type"),
-			Data:        writer.responseData.Bytes(),
-		}, ttl)
+		status := c.Writer.Status()
+		if !statuses[status] || !policy.cacheable(c.Writer.Header()) {
+			return
+		}
+
+		// cache the response along with its validators
+		header := c.Writer.Header()
+		etag := header.Get("ETag")
+		if etag == "" && status == http.StatusOK {
+			etag = computeETag(writer.responseData.Bytes())
+		}
+
+		resp = &responseCacheItem{
+			Status:       status,
+			ContentType:  header.Get("Content-Type"),
+			ETag:         etag,
+			LastModified: header.Get("Last-Modified"),
+			Data:         writer.responseData.Bytes(),
+		}
+
+		if ttl > 0 {
+			resp.Expires = time.Now().Add(ttl)
+		}
+
+		for _, name := range policy.Headers {
+			if values := header[http.CanonicalHeaderKey(name)]; len(values) > 0 {
+				if resp.Header == nil {
+					resp.Header = http.Header{}
+				}
+				resp.Header[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+
+		shared = setResponse(s, key, resp, ttl)
+
+	}
+}
+
+// key gets the cache key of the supplied request. The key starts with the path
+// and query, followed by a digest of the request headers and user the policy
+// varies on, if any.
+func (p *Policy) key(c *gin.Context) string {
+
+	key := c.Request.URL.EscapedPath() + "?" + c.Request.URL.Query().Encode()
+
+	if len(p.Vary) == 0 && !p.VaryUser {
+		return key
+	}
 
+	// credentials are hashed so they are never stored in keys
+	digest := sha256.New()
+
+	for _, name := range p.Vary {
+		fmt.Fprintf(digest, "%s:%q\n", http.CanonicalHeaderKey(name),
+			c.Request.Header[http.CanonicalHeaderKey(name)])
+	}
+
+	if p.VaryUser {
+		if userID, ok := identity.RequestUserID(c); ok {
+			fmt.Fprintf(digest, "user:%d\n", userID)
+		} else {
+			fmt.Fprintf(digest, "authorization:%q\n",
+				c.Request.Header["Authorization"])
+		}
 	}
+
+	return key + "#" + hex.EncodeToString(digest.Sum(nil)[:16])
+
 }
 
-// responseCacheItem is used to store the raw response to an HTTP request in the
-// local cache.
+// cacheable checks whether a response with the supplied headers may be stored
+// under this policy.
+func (p *Policy) cacheable(header http.Header) bool {
+
+	directives := cacheControl(header)
+	if directives["no-store"] || (directives["private"] && !p.VaryUser) {
+		return false
+	}
+
+	// cookies are specific to a client
+	if len(header["Set-Cookie"]) > 0 {
+		return false
+	}
+
+	// the response may only vary on request headers that are part of the key,
+	// origin and encoding are handled by middleware outside the cache
+	for _, value := range header["Vary"] {
+		for _, name := range strings.Split(value, ",") {
+
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+
+			switch {
+			case name == "" || name == "Origin" || name == "Accept-Encoding":
+			case name == "Authorization" && p.VaryUser:
+			case !p.varies(name):
+				return false
+			}
+
+		}
+	}
+
+	return true
+
+}
+
+// varies checks whether the policy varies on the supplied request header.
+func (p *Policy) varies(name string) bool {
+
+	for _, vary := range p.Vary {
+		if http.CanonicalHeaderKey(vary) == name {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// hasCredentials checks whether the supplied request has been authenticated or
+// carries credentials.
+func hasCredentials(c *gin.Context) bool {
+
+	if _, ok := identity.RequestUserID(c); ok {
+		return true
+	}
+
+	return c.GetHeader("Authorization") != ""
+
+}
+
+// cacheControl parses the directives of the Cache-Control header in the
+// supplied header. Directive names are lowercased and arguments are ignored.
+func cacheControl(header http.Header) map[string]bool {
+
+	directives := map[string]bool{}
+
+	for _, value := range header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			name := strings.SplitN(strings.TrimSpace(directive), "=", 2)[0]
+			if name != "" {
+				directives[strings.ToLower(name)] = true
+			}
+		}
+	}
+
+	return directives
+
+}
+
+// responseCacheItem is used to store the raw response to an HTTP request in a
+// cache store.
 type responseCacheItem struct {
-	ContentType string
-	Data        []byte
+	Status       int         `json:"status"`
+	ContentType  string      `json:"content_type"`
+	ETag         string      `json:"etag"`
+	LastModified string      `json:"last_modified"`
+	Header       http.Header `json:"header,omitempty"`
+	Expires      time.Time   `json:"expires"`
+	Data         []byte      `json:"data"`
+}
+
+// fresh checks whether the response has not expired at the supplied time.
+// Expired responses may still be served while they are refreshed.
+func (r *responseCacheItem) fresh(now time.Time) bool {
+	return r.Expires.IsZero() || now.Before(r.Expires)
+}
+
+// write responds with the cached response and validators, honoring any
+// conditional request headers.
+func (r *responseCacheItem) write(c *gin.Context) {
+
+	for name, values := range r.Header {
+		c.Writer.Header()[name] = values
+	}
+
+	if r.ContentType != "" {
+		c.Header("Content-Type", r.ContentType)
+	}
+	if r.ETag != "" {
+		c.Header("ETag", r.ETag)
+	}
+	if r.LastModified != "" {
+		c.Header("Last-Modified", r.LastModified)
+	}
+
+	// responses cached before the status was stored were always 200 - OK
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	writeConditional(c, status, r.Data)
+
+}
+
+// getResponse retrieves a cached response from the supplied store. Store
+// errors are logged and treated as a cache miss.
+func getResponse(s Store, key string) (*responseCacheItem, bool) {
+
+	value, ok, err := s.Get(key)
+	if err != nil {
+		logrus.Error(err)
+		return nil, false
+	} else if !ok {
+		return nil, false
+	}
+
+	return decodeResponse(value)
+
+}
+
+// decodeResponse decodes a response added to a store by setResponse.
+func decodeResponse(value []byte) (*responseCacheItem, bool) {
+
+	if value == nil {
+		return nil, false
+	}
+
+	var resp responseCacheItem
+	if err := json.Unmarshal(value, &resp); err != nil {
+		logrus.Error(err)
+		return nil, false
+	}
+
+	return &resp, true
+
+}
+
+// setResponse adds a response to the supplied store, keeping it for the stale
+// window once it has expired. Returns the encoded response. Store errors are
+// logged as the response has already been written.
+func setResponse(s Store, key string, resp *responseCacheItem,
+	ttl time.Duration) []byte {
+
+	value, err := json.Marshal(resp)
+	if err != nil {
+		logrus.Error(err)
+		return nil
+	}
+
+	if err := s.Set(key, value, storedTTL(ttl)); err != nil {
+		logrus.Error(err)
+	}
+
+	return value
+
 }
 
 // responseWriter is used to wrap the response writer used by the response cache
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStoreMiddlewareCoalescesNoCache(t *testing.T) {
+
+	resetLocal(0, 0, newLRUPolicy())
+
+	var calls int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	router := gin.New()
+	router.GET("/slow", StoreMiddleware(LocalStore{}, time.Minute, nil),
+		func(c *gin.Context) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				close(started)
+			}
+			<-release
+			c.JSON(http.StatusOK, "response")
+		})
+
+	request := func(noCache bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/slow", nil)
+		if noCache {
+			r.Header.Set("Cache-Control", "no-cache")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 10)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		responses[0] = request(false)
+	}()
+	<-started
+
+	// requests with no-cache arriving while the handler runs must wait for it
+	// instead of each running the handler
+	for i := 1; i < len(responses); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = request(true)
+		}(i)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+
+	for i, w := range responses {
+		if w.Code != http.StatusOK || w.Body.String() != `"response"` {
+			t.Errorf("response %d = %d %q, want 200 response", i, w.Code,
+				w.Body.String())
+		}
+	}
+
+	// once no request is running the handler no-cache skips the cache
+	request(true)
+	if calls != 2 {
+		t.Errorf("handler ran %d times after a no-cache request, want 2", calls)
+	}
+
+	request(false)
+	if calls != 2 {
+		t.Errorf("handler ran %d times after a cached request, want 2", calls)
+	}
+
+}
+
+func TestStoreMiddlewareLoadsNotShared(t *testing.T) {
+
+	resetLocal(0, 0, newLRUPolicy())
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	router := gin.New()
+	router.GET("/shared", StoreMiddleware(LocalStore{}, time.Minute, nil),
+		func(c *gin.Context) {
+			close(started)
+			<-release
+			c.JSON(http.StatusOK, "response")
+		})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		router.ServeHTTP(httptest.NewRecorder(),
+			httptest.NewRequest(http.MethodGet, "/shared", nil))
+	}()
+	<-started
+
+	// a load of the same key as the response must not wait for the response
+	// or be given it
+	value, err := GetOrLoad("/shared?", time.Minute, func() ([]byte, error) {
+		return []byte("loaded"), nil
+	})
+	if err != nil || string(value) != "loaded" {
+		t.Errorf("GetOrLoad() = %q, %v, want loaded", value, err)
+	}
+
+	close(release)
+	<-done
+
+}
@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"time"
+
+	"web-app/data"
+)
+
+// init migrates the database model.
+func init() {
+	data.DB().AutoMigrate(
+		invalidationRecord{},
+	)
+}
+
+/* Data Types */
+
+// invalidationRecord stores an invalidation so it can be applied by every
+// server instance when using the database broadcaster.
+type invalidationRecord struct {
+	ID        uint64    `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	Instance string `gorm:"size:36" json:"instance"` // id of the server instance that made the invalidation
+	Kind     string `gorm:"size:16" json:"kind"`
+	Value    string `gorm:"type:text" json:"value"`
+}
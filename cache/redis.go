@@ -0,0 +1,470 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// redisTimeout is the maximum duration of a connection attempt or a single
+	// command sent to a Redis server.
+	redisTimeout = 5 * time.Second
+	// redisMaxIdle is the maximum number of idle connections kept open to a
+	// Redis server.
+	redisMaxIdle = 8
+	// redisScanCount is the number of keys checked by each SCAN command when
+	// deleting values by prefix.
+	redisScanCount = 1000
+	// redisKeyPrefix is prepended to the key of every value so that the store
+	// only reads and removes its own keys when the Redis database is shared.
+	redisKeyPrefix = "web-app:cache:"
+	// redisTagPrefix is prepended to a tag to form the key of the set that
+	// stores the keys of values with that tag.
+	redisTagPrefix = "web-app:tag:"
+)
+
+// redisTagScript adds a key to a tag set and extends the expiration of the set
+// so that it outlives the value, or removes the expiration if the value never
+// expires. The expiration is never shortened since the set may hold the keys of
+// values that live longer. The arguments are the key and the time to live of
+// the value in milliseconds.
+const redisTagScript = `
+local existed = redis.call('EXISTS', KEYS[1])
+redis.call('SADD', KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl == 0 then
+	redis.call('PERSIST', KEYS[1])
+elseif existed == 0 then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+else
+	local current = redis.call('PTTL', KEYS[1])
+	if current >= 0 and current < ttl then
+		redis.call('PEXPIRE', KEYS[1], ttl)
+	end
+end
+return 1
+`
+
+// redisInvalidateScript removes a tag set and the values whose keys it holds
+// in a single step so that no key added to the set while it is read is lost.
+// Keys are deleted in batches to stay within the number of arguments a Lua
+// function may be called with.
+const redisInvalidateScript = `
+local keys = redis.call('SMEMBERS', KEYS[1])
+for i = 1, #keys, 1000 do
+	redis.call('DEL', unpack(keys, i, math.min(i + 999, #keys)))
+end
+redis.call('DEL', KEYS[1])
+return #keys
+`
+
+// RedisStore is a store that keeps values in a Redis server, or any server that
+// speaks the Redis protocol, so that cached data is shared between server
+// instances. Keys are stored within the web-app: namespace so that other data
+// in the same database is never read or removed.
+type RedisStore struct {
+	address  string
+	password string
+	db       int
+
+	mutex *sync.Mutex
+	idle  []*redisConn
+}
+
+// redisConn is a connection to a Redis server.
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisGlobEscaper escapes the characters that have a special meaning in
+// Redis key patterns.
+var redisGlobEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// redisError is an error reply from a Redis server.
+type redisError string
+
+// Error returns the message of the error reply.
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedisStore creates a store that connects to the Redis server at the
+// supplied address. The password is only sent if it is not empty and the
+// database is only selected if it is not zero. Connections are opened as they
+// are needed.
+func NewRedisStore(address, password string, db int) *RedisStore {
+	return &RedisStore{
+		address:  address,
+		password: password,
+		db:       db,
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// Get retrieves a value from the Redis server.
+func (s *RedisStore) Get(key string) ([]byte, bool, error) {
+
+	reply, err := s.do("GET", redisKeyPrefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, nil
+	}
+
+	return value, true, nil
+
+}
+
+// Set adds a value to the Redis server.
+func (s *RedisStore) Set(key string, value []byte, ttl time.Duration) error {
+
+	if ttl <= 0 {
+		_, err := s.do("SET", redisKeyPrefix+key, value)
+		return err
+	}
+
+	_, err := s.do("SET", redisKeyPrefix+key, value, "PX", redisMilliseconds(ttl))
+	return err
+
+}
+
+// Delete removes a value from the Redis server.
+func (s *RedisStore) Delete(key string) error {
+	_, err := s.do("DEL", redisKeyPrefix+key)
+	return err
+}
+
+// DeleteByPrefix removes values whose key starts with the supplied prefix from
+// the Redis server. Keys are found using SCAN so the server is not blocked
+// while they are deleted. Only keys within the namespace of the store are
+// removed, along with every tag set if the prefix is empty.
+func (s *RedisStore) DeleteByPrefix(prefix string) error {
+
+	if err := s.deleteMatching(redisGlobEscaper.Replace(redisKeyPrefix+prefix) +
+		"*"); err != nil {
+		return err
+	}
+
+	if prefix != "" {
+		return nil
+	}
+
+	return s.deleteMatching(redisGlobEscaper.Replace(redisTagPrefix) + "*")
+
+}
+
+// deleteMatching removes every key matching the supplied pattern from the Redis
+// server.
+func (s *RedisStore) deleteMatching(pattern string) error {
+
+	cursor := "0"
+
+	for {
+
+		reply, err := s.do("SCAN", cursor, "MATCH", pattern, "COUNT",
+			strconv.Itoa(redisScanCount))
+		if err != nil {
+			return err
+		}
+
+		// the reply is the next cursor and the keys found
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 2 {
+			return fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+
+		next, ok := items[0].([]byte)
+		keys, ok2 := items[1].([]interface{})
+		if !ok || !ok2 {
+			return fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+
+		if len(keys) > 0 {
+			if _, err := s.do(append([]interface{}{"DEL"}, keys...)...); err != nil {
+				return err
+			}
+		}
+
+		if cursor = string(next); cursor == "0" {
+			return nil
+		}
+
+	}
+
+}
+
+// SetWithTags adds a value to the Redis server and adds its key to a set for
+// each of the supplied tags. Each set expires once every value added to it has
+// expired, keys are otherwise only removed from the sets when the tag is
+// invalidated.
+func (s *RedisStore) SetWithTags(key string, value []byte, ttl time.Duration,
+	tags []string) error {
+
+	ms := "0"
+	if ttl > 0 {
+		ms = redisMilliseconds(ttl)
+	}
+
+	for _, tag := range tags {
+		if _, err := s.do("EVAL", redisTagScript, "1", redisTagPrefix+tag,
+			redisKeyPrefix+key, ms); err != nil {
+			return err
+		}
+	}
+
+	return s.Set(key, value, ttl)
+
+}
+
+// InvalidateTag removes the values with the supplied tag from the Redis server.
+func (s *RedisStore) InvalidateTag(tag string) error {
+
+	_, err := s.do("EVAL", redisInvalidateScript, "1", redisTagPrefix+tag)
+	return err
+
+}
+
+// TTL retrieves the remaining time to live of a value in the Redis server.
+func (s *RedisStore) TTL(key string) (time.Duration, bool, error) {
+
+	reply, err := s.do("PTTL", redisKeyPrefix+key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	ms, ok := reply.(int64)
+	if !ok {
+		return 0, false, fmt.Errorf("redis: unexpected PTTL reply %v", reply)
+	}
+
+	// -2 indicates a missing key and -1 a key that never expires
+	switch {
+	case ms == -2:
+		return 0, false, nil
+	case ms < 0:
+		return 0, true, nil
+	}
+
+	return time.Duration(ms) * time.Millisecond, true, nil
+
+}
+
+// redisMilliseconds formats a time to live as a number of milliseconds. The
+// expiration must be at least one millisecond.
+func redisMilliseconds(ttl time.Duration) string {
+
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+
+	return strconv.FormatInt(ms, 10)
+
+}
+
+// do sends a command to the Redis server and reads the reply. Arguments must be
+// strings or byte slices. Error replies are returned as errors.
+func (s *RedisStore) do(args ...interface{}) (interface{}, error) {
+
+	conn, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(args...)
+
+	// connections are only reused after a complete reply has been read
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.conn.Close()
+		return nil, err
+	}
+
+	s.put(conn)
+
+	return reply, err
+
+}
+
+// get takes an idle connection or opens a new connection to the Redis server.
+func (s *RedisStore) get() (*redisConn, error) {
+
+	s.mutex.Lock()
+	if n := len(s.idle); n > 0 {
+		conn := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mutex.Unlock()
+		return conn, nil
+	}
+	s.mutex.Unlock()
+
+	netConn, err := net.DialTimeout("tcp", s.address, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{
+		conn:   netConn,
+		reader: bufio.NewReader(netConn),
+	}
+
+	if s.password != "" {
+		if _, err := conn.do("AUTH", s.password); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+
+	if s.db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(s.db)); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+
+}
+
+// put returns a connection to the idle pool, closing it if the pool is full.
+func (s *RedisStore) put(conn *redisConn) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.idle) >= redisMaxIdle {
+		conn.conn.Close()
+		return
+	}
+
+	s.idle = append(s.idle, conn)
+
+}
+
+// do writes a command to the connection and reads the reply.
+func (c *redisConn) do(args ...interface{}) (interface{}, error) {
+
+	if err := c.conn.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+
+	// commands are sent as an array of bulk strings
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+
+		var value []byte
+		switch arg := arg.(type) {
+		case string:
+			value = []byte(arg)
+		case []byte:
+			value = arg
+		default:
+			return nil, fmt.Errorf("redis: unsupported argument type %T", arg)
+		}
+
+		buf = append(buf, "$"+strconv.Itoa(len(value))+"\r\n"...)
+		buf = append(buf, value...)
+		buf = append(buf, "\r\n"...)
+
+	}
+
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return c.readReply()
+
+}
+
+// readReply reads a single reply from the connection. Simple strings are
+// returned as strings, integers as int64, bulk strings as byte slices or nil,
+// and arrays as slices of replies.
+func (c *redisConn) readReply() (interface{}, error) {
+
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	} else if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		} else if n < 0 {
+			return nil, nil
+		}
+
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, value); err != nil {
+			return nil, err
+		}
+
+		return value[:n], nil
+
+	case '*':
+
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		} else if n < 0 {
+			return nil, nil
+		}
+
+		items := make([]interface{}, n)
+		// error replies within an array are returned as items so that the
+		// rest of the array is still read
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				if replyErr, ok := err.(redisError); ok {
+					items[i] = replyErr
+					continue
+				}
+				return nil, err
+			}
+		}
+
+		return items, nil
+
+	}
+
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+
+}
+
+// readLine reads a line terminated by CRLF without the terminator.
+func (c *redisConn) readLine() (string, error) {
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed reply %q", line)
+	}
+
+	return line[:len(line)-2], nil
+
+}
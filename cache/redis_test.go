@@ -0,0 +1,354 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestRedisStore starts an in-memory Redis server and creates a store that
+// connects to it.
+func newTestRedisStore(t *testing.T) (*miniredis.Miniredis, *RedisStore) {
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run() error = %v", err)
+	}
+
+	return server, NewRedisStore(server.Addr(), "", 0)
+
+}
+
+func TestRedisStoreGetSet(t *testing.T) {
+
+	server, store := newTestRedisStore(t)
+	defer server.Close()
+
+	if _, ok, err := store.Get("missing"); err != nil || ok {
+		t.Errorf("Get(missing) = %v, %v, want not found", ok, err)
+	}
+
+	// values are binary safe
+	value := []byte("line\r\nbreak\x00")
+	if err := store.Set("key", value, 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	got, ok, err := store.Get("key")
+	if err != nil || !ok || string(got) != string(value) {
+		t.Errorf("Get(key) = %q, %v, %v, want %q", got, ok, err, value)
+	}
+
+	if err := store.Set("key", []byte{}, 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if got, ok, err := store.Get("key"); err != nil || !ok || len(got) != 0 {
+		t.Errorf("Get(key) = %q, %v, %v, want empty value", got, ok, err)
+	}
+
+}
+
+func TestRedisStoreTTL(t *testing.T) {
+
+	server, store := newTestRedisStore(t)
+	defer server.Close()
+
+	if err := store.Set("expiring", []byte("value"), time.Minute); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := store.Set("persistent", []byte("value"), 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if ttl, ok, err := store.TTL("expiring"); err != nil || !ok ||
+		ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL(expiring) = %v, %v, %v, want at most a minute", ttl, ok,
+			err)
+	}
+
+	if ttl, ok, err := store.TTL("persistent"); err != nil || !ok || ttl != 0 {
+		t.Errorf("TTL(persistent) = %v, %v, %v, want no expiry", ttl, ok, err)
+	}
+
+	if _, ok, err := store.TTL("missing"); err != nil || ok {
+		t.Errorf("TTL(missing) = %v, %v, want not found", ok, err)
+	}
+
+	server.FastForward(time.Minute)
+
+	if _, ok, err := store.Get("expiring"); err != nil || ok {
+		t.Errorf("Get(expiring) = %v, %v, want expired", ok, err)
+	}
+	if _, ok, err := store.Get("persistent"); err != nil || !ok {
+		t.Errorf("Get(persistent) = %v, %v, want found", ok, err)
+	}
+
+}
+
+func TestRedisStoreDelete(t *testing.T) {
+
+	server, store := newTestRedisStore(t)
+	defer server.Close()
+
+	for _, key := range []string{"user:1", "user:2", "users", "user*", "role:1"} {
+		if err := store.Set(key, []byte(key), 0); err != nil {
+			t.Fatalf("Set(%s) error = %v", key, err)
+		}
+	}
+
+	if err := store.Delete("role:1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok, _ := store.Get("role:1"); ok {
+		t.Error("Get(role:1) found a deleted value")
+	}
+
+	// pattern characters in the prefix must match literally
+	if err := store.DeleteByPrefix("user*"); err != nil {
+		t.Fatalf("DeleteByPrefix(user*) error = %v", err)
+	}
+	if _, ok, _ := store.Get("user*"); ok {
+		t.Error("DeleteByPrefix(user*) kept user*")
+	}
+	if _, ok, _ := store.Get("user:1"); !ok {
+		t.Error("DeleteByPrefix(user*) removed user:1")
+	}
+
+	if err := store.DeleteByPrefix("user:"); err != nil {
+		t.Fatalf("DeleteByPrefix(user:) error = %v", err)
+	}
+
+	for key, want := range map[string]bool{
+		"user:1": false,
+		"user:2": false,
+		"users":  true,
+	} {
+		if _, ok, _ := store.Get(key); ok != want {
+			t.Errorf("Get(%s) found = %v, want %v", key, ok, want)
+		}
+	}
+
+}
+
+func TestRedisStoreDeleteByPrefixScan(t *testing.T) {
+
+	server, store := newTestRedisStore(t)
+	defer server.Close()
+
+	// more keys than a single SCAN returns
+	for i := 0; i < redisScanCount*2+1; i++ {
+		server.Set(redisKeyPrefix+"page:"+strconv.Itoa(i), "value")
+	}
+
+	if err := store.DeleteByPrefix("page:"); err != nil {
+		t.Fatalf("DeleteByPrefix() error = %v", err)
+	}
+
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Errorf("DeleteByPrefix() kept %d keys", len(keys))
+	}
+
+}
+
+func TestRedisStoreTags(t *testing.T) {
+
+	server, store := newTestRedisStore(t)
+	defer server.Close()
+
+	if err := store.SetWithTags("user:1", []byte("1"), time.Minute,
+		[]string{"user:1", "role:admin"}); err != nil {
+		t.Fatalf("SetWithTags() error = %v", err)
+	}
+	if err := store.SetWithTags("user:2", []byte("2"), time.Minute,
+		[]string{"user:2", "role:admin"}); err != nil {
+		t.Fatalf("SetWithTags() error = %v", err)
+	}
+	if err := store.SetWithTags("user:3", []byte("3"), time.Minute,
+		[]string{"user:3"}); err != nil {
+		t.Fatalf("SetWithTags() error = %v", err)
+	}
+
+	if err := store.InvalidateTag("role:admin"); err != nil {
+		t.Fatalf("InvalidateTag() error = %v", err)
+	}
+
+	for key, want := range map[string]bool{
+		"user:1": false,
+		"user:2": false,
+		"user:3": true,
+	} {
+		if _, ok, _ := store.Get(key); ok != want {
+			t.Errorf("Get(%s) found = %v, want %v", key, ok, want)
+		}
+	}
+
+	if server.Exists(redisTagPrefix + "role:admin") {
+		t.Error("InvalidateTag() kept the tag set")
+	}
+
+	// invalidating an unknown tag is not an error
+	if err := store.InvalidateTag("missing"); err != nil {
+		t.Errorf("InvalidateTag(missing) error = %v", err)
+	}
+
+}
+
+func TestRedisStoreInvalidateLargeTag(t *testing.T) {
+
+	server, store := newTestRedisStore(t)
+	defer server.Close()
+
+	// more keys than are deleted in a single batch
+	for i := 0; i < 2500; i++ {
+		key := redisKeyPrefix + "page:" + strconv.Itoa(i)
+		server.Set(key, "value")
+		server.SAdd(redisTagPrefix+"pages", key)
+	}
+
+	if err := store.InvalidateTag("pages"); err != nil {
+		t.Fatalf("InvalidateTag() error = %v", err)
+	}
+
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Errorf("InvalidateTag() kept %d keys", len(keys))
+	}
+
+}
+
+func TestRedisStoreNamespace(t *testing.T) {
+
+	server, store := newTestRedisStore(t)
+	defer server.Close()
+
+	// keys of other applications sharing the database
+	server.Set("session:1", "other")
+	server.Set("web-app:other", "other")
+
+	if err := store.SetWithTags("key", []byte("value"), time.Minute,
+		[]string{"tag"}); err != nil {
+		t.Fatalf("SetWithTags() error = %v", err)
+	}
+
+	if _, ok, err := store.Get("session:1"); err != nil || ok {
+		t.Errorf("Get(session:1) = %v, %v, want keys outside the namespace "+
+			"ignored", ok, err)
+	}
+
+	// purging removes values and tag sets only
+	if err := store.DeleteByPrefix(""); err != nil {
+		t.Fatalf("DeleteByPrefix() error = %v", err)
+	}
+
+	if keys := server.Keys(); len(keys) != 2 || keys[0] != "session:1" ||
+		keys[1] != "web-app:other" {
+		t.Errorf("keys after purge = %v, want the other keys", keys)
+	}
+
+}
+
+func TestRedisStoreTagExpiry(t *testing.T) {
+
+	server, store := newTestRedisStore(t)
+	defer server.Close()
+
+	set := func(key string, ttl time.Duration) {
+		t.Helper()
+		if err := store.SetWithTags(key, []byte(key), ttl,
+			[]string{"tag"}); err != nil {
+			t.Fatalf("SetWithTags(%s) error = %v", key, err)
+		}
+	}
+
+	// the tag set lives at least as long as the longest lived value
+	set("a", time.Minute)
+	if ttl := server.TTL(redisTagPrefix + "tag"); ttl != time.Minute {
+		t.Errorf("tag TTL = %v, want 1m", ttl)
+	}
+
+	set("b", time.Hour)
+	set("c", time.Second)
+	if ttl := server.TTL(redisTagPrefix + "tag"); ttl != time.Hour {
+		t.Errorf("tag TTL = %v, want 1h", ttl)
+	}
+
+	// values that never expire keep the set forever
+	set("d", 0)
+	set("e", time.Minute)
+	if ttl := server.TTL(redisTagPrefix + "tag"); ttl != 0 {
+		t.Errorf("tag TTL = %v, want no expiry", ttl)
+	}
+
+	if err := store.InvalidateTag("tag"); err != nil {
+		t.Fatalf("InvalidateTag() error = %v", err)
+	}
+
+	set("f", time.Minute)
+	server.FastForward(time.Minute)
+
+	if server.Exists(redisTagPrefix + "tag") {
+		t.Error("the tag set outlived its values")
+	}
+
+}
+
+func TestRedisStoreAuth(t *testing.T) {
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run() error = %v", err)
+	}
+	defer server.Close()
+
+	server.RequireAuth("secret")
+
+	if err := NewRedisStore(server.Addr(), "wrong", 0).Set("key",
+		[]byte("value"), 0); err == nil {
+		t.Error("Set() with a wrong password error = nil")
+	}
+
+	store := NewRedisStore(server.Addr(), "secret", 2)
+	if err := store.Set("key", []byte("value"), 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	// the value is stored in the selected database
+	if got, err := server.DB(2).Get(redisKeyPrefix + "key"); err != nil ||
+		got != "value" {
+		t.Errorf("DB(2).Get(key) = %q, %v, want value", got, err)
+	}
+	if server.Exists(redisKeyPrefix + "key") {
+		t.Error("value was stored in database 0")
+	}
+
+}
+
+func TestRedisStoreConnections(t *testing.T) {
+
+	server, store := newTestRedisStore(t)
+	defer server.Close()
+
+	if err := store.Set("key", []byte("value"), 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	server.SAdd(redisKeyPrefix+"set", "member")
+
+	// error replies are returned without breaking the connection
+	if _, _, err := store.Get("set"); err == nil {
+		t.Error("Get() of a set error = nil, want WRONGTYPE")
+	}
+	if _, ok, err := store.Get("key"); err != nil || !ok {
+		t.Errorf("Get(key) = %v, %v after an error reply", ok, err)
+	}
+
+	// idle connections closed by the server are discarded
+	server.Restart()
+	store.Get("key")
+
+	if _, ok, err := store.Get("key"); err != nil || !ok {
+		t.Errorf("Get(key) = %v, %v after a restart", ok, err)
+	}
+
+}
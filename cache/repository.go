@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// listInvalidationRecordSince retrieves up to limit invalidations created at or
+// after the supplied time with an id greater than afterID, ordered by id.
+func listInvalidationRecordSince(ctx context.Context, db *gorm.DB,
+	since time.Time, afterID uint64, limit int) ([]*invalidationRecord, error) {
+
+	var items []*invalidationRecord
+
+	if err := db.WithContext(ctx).Model(&invalidationRecord{}).
+		Where("created_at >= ?", since).
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	return items, nil
+
+}
+
+// createInvalidationRecord inserts the supplied invalidation record.
+func createInvalidationRecord(ctx context.Context, db *gorm.DB,
+	item *invalidationRecord) error {
+	return db.WithContext(ctx).Create(item).Error
+}
+
+// DeleteInvalidationRecordsBefore deletes all stored invalidations created
+// before the supplied time.
+func DeleteInvalidationRecordsBefore(ctx context.Context, db *gorm.DB,
+	before time.Time) error {
+	return db.WithContext(ctx).
+		Where("created_at < ?", before).
+		Delete(&invalidationRecord{}).Error
+}
@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"time"
+
+	"web-app/env"
+
+	"github.com/sirupsen/logrus"
+)
+
+// init selects the cache store used by the application.
+func init() {
+
+	switch storeType := env.GetStringSafe(storeVariable, "local"); storeType {
+	case "local":
+		SetStore(LocalStore{})
+	case "redis":
+		SetStore(NewRedisStore(
+			env.GetStringSafe(redisAddressVariable, "localhost:6379"),
+			env.GetStringSafe(redisPasswordVariable, ""),
+			env.GetIntSafe(redisDBVariable, 0),
+		))
+	default:
+		logrus.Fatalf("invalid cache store '%s'", storeType)
+	}
+
+}
+
+const (
+	// storeVariable defines the environment variable for the type of store
+	// used to cache data.
+	storeVariable = "WEB_APP_CACHE_STORE"
+	// redisAddressVariable defines the environment variable for the address
+	// of the Redis server used to cache data.
+	redisAddressVariable = "WEB_APP_REDIS_ADDRESS"
+	// redisPasswordVariable defines the environment variable for the password
+	// used to authenticate with the Redis server.
+	redisPasswordVariable = "WEB_APP_REDIS_PASSWORD"
+	// redisDBVariable defines the environment variable for the Redis database
+	// number used to cache data.
+	redisDBVariable = "WEB_APP_REDIS_DB"
+)
+
+// Store is a key value store that cached data is kept in. Entries expire once
+// their time to live has passed.
+type Store interface {
+	// Get retrieves the value stored under the supplied key and a flag that
+	// indicates whether the value was found.
+	Get(key string) ([]byte, bool, error)
+	// Set stores the supplied value under the supplied key. The value never
+	// expires if the time to live is zero or less.
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete removes the value stored under the supplied key.
+	Delete(key string) error
+	// DeleteByPrefix removes every value whose key starts with the supplied
+	// prefix. Every value is removed if the prefix is empty.
+	DeleteByPrefix(prefix string) error
+	// SetWithTags stores the supplied value under the supplied key so that it
+	// is removed when any of the supplied tags is invalidated.
+	SetWithTags(key string, value []byte, ttl time.Duration, tags []string) error
+	// InvalidateTag removes every value stored with the supplied tag.
+	InvalidateTag(tag string) error
+	// TTL retrieves the remaining time to live of the value stored under the
+	// supplied key and a flag that indicates whether the value was found. The
+	// time to live is zero if the value never expires.
+	TTL(key string) (time.Duration, bool, error)
+}
+
+// store is the store used by the application to cache data.
+var store Store
+
+// SetStore replaces the store used by the application to cache data.
+func SetStore(s Store) {
+	store = s
+}
+
+// DefaultStore gets the store used by the application to cache data.
+func DefaultStore() Store {
+	return store
+}
+
+// Get retrieves a value from the application cache store.
+func Get(key string) ([]byte, bool, error) {
+	return store.Get(key)
+}
+
+// Set adds a value to the application cache store.
+func Set(key string, value []byte, ttl time.Duration) error {
+	return store.Set(key, value, ttl)
+}
+
+// SetWithTags adds a value to the application cache store that is removed
+// when any of the supplied tags, such as user:42, is invalidated.
+func SetWithTags(key string, value []byte, ttl time.Duration,
+	tags ...string) error {
+	return store.SetWithTags(key, value, ttl, tags)
+}
+
+// Delete removes a value from the application cache store and from the local
+// cache of every server instance.
+func Delete(key string) error {
+
+	if err := store.Delete(key); err != nil {
+		return err
+	}
+
+	return broadcast(InvalidationKey, key)
+
+}
+
+// DeleteByPrefix removes every value whose key starts with the supplied prefix
+// from the application cache store and from the local cache of every server
+// instance. Responses cached by the cache middleware are stored under their
+// path, so a path prefix removes every cached response below that path.
+func DeleteByPrefix(prefix string) error {
+
+	if err := store.DeleteByPrefix(prefix); err != nil {
+		return err
+	}
+
+	return broadcast(InvalidationPrefix, prefix)
+
+}
+
+// InvalidateTag removes every value stored with the supplied tag from the
+// application cache store and from the local cache of every server instance.
+func InvalidateTag(tag string) error {
+
+	if err := store.InvalidateTag(tag); err != nil {
+		return err
+	}
+
+	return broadcast(InvalidationTag, tag)
+
+}
+
+// Purge removes every value from the application cache store and from the
+// local cache of every server instance.
+func Purge() error {
+	return DeleteByPrefix("")
+}
+
+// TTL retrieves the remaining time to live of a value in the application cache
+// store.
+func TTL(key string) (time.Duration, bool, error) {
+	return store.TTL(key)
+}
+
+// LocalStore is a store that keeps values in application memory. Values are
+// not shared between server instances.
+type LocalStore struct{}
+
+// Get retrieves a value from the local cache.
+func (LocalStore) Get(key string) ([]byte, bool, error) {
+
+	item, ok := GetLocal(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	value, ok := item.([]byte)
+	return value, ok, nil
+
+}
+
+// Set adds a value to the local cache.
+func (LocalStore) Set(key string, value []byte, ttl time.Duration) error {
+	SetLocal(key, value, ttl)
+	return nil
+}
+
+// Delete removes a value from the local cache.
+func (LocalStore) Delete(key string) error {
+	DeleteLocal(key)
+	return nil
+}
+
+// DeleteByPrefix removes values whose key starts with the supplied prefix from
+// the local cache.
+func (LocalStore) DeleteByPrefix(prefix string) error {
+	DeleteLocalByPrefix(prefix)
+	return nil
+}
+
+// SetWithTags adds a tagged value to the local cache.
+func (LocalStore) SetWithTags(key string, value []byte, ttl time.Duration,
+	tags []string) error {
+	SetLocalWithTags(key, value, ttl, tags...)
+	return nil
+}
+
+// InvalidateTag removes values with the supplied tag from the local cache.
+func (LocalStore) InvalidateTag(tag string) error {
+	InvalidateLocalTag(tag)
+	return nil
+}
+
+// TTL retrieves the remaining time to live of a value in the local cache.
+func (LocalStore) TTL(key string) (time.Duration, bool, error) {
+	ttl, ok := TTLLocal(key)
+	return ttl, ok, nil
+}
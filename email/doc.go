@@ -22,6 +22,9 @@
 //         bool - a flag that indicates whether a log should be kept of all
 //                emails sent
 //                Default: false
+//     WEB_APP_EMAIL_LOG_RETENTION_DAYS
+//         int - the number of days email logs are kept
+//               Default: 90
 //     WEB_APP_DEFAULT_FROM_ADDRESS:
 //         string - the default email address used as the sender
 //     WEB_APP_DEFAULT_REPLY_TO_ADDRESS:
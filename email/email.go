@@ -1,10 +1,12 @@
 package email
 
 import (
+	"context"
 	"errors"
 
 	"web-app/data"
 	"web-app/env"
+	"web-app/tracing"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -44,6 +46,7 @@ func init() {
 	}
 
 	logEmails = env.GetBoolSafe(logEmailsVariable, false)
+	emailLogRetentionDays = env.GetIntSafe(emailLogRetentionDaysVariable, 90)
 
 	// retrieve default from and reply-to addresses
 	defaultFromAddress = env.MustGetString(defaultFromAddressVariable)
@@ -80,6 +83,9 @@ const (
 	// logEmailsVariable defines an evironment variable that determines whether
 	// we should log the results of sending emails.
 	logEmailsVariable = "WEB_APP_LOG_EMAILS"
+	// emailLogRetentionDaysVariable defines an environment variable for the
+	// number of days email logs are kept.
+	emailLogRetentionDaysVariable = "WEB_APP_EMAIL_LOG_RETENTION_DAYS"
 	// sendingMethodSMTP indicates emails should be sent through SMTP.
 	sendingMethodSMTP = "SMTP"
 	// sendingMethodSES indicates emails should be sent through Amazon SES.
@@ -119,6 +125,9 @@ var defaultReplyToAddress string
 // logEmails stores whether we should create a log of all emails sent.
 var logEmails bool
 
+// emailLogRetentionDays stores the number of days email logs are kept.
+var emailLogRetentionDays int
+
 // DefaultFromAddress is the application default from email address.
 func DefaultFromAddress() string {
 	return defaultFromAddress
@@ -132,6 +141,7 @@ func DefaultReplyToAddress() string {
 // SendEmailTemplate formats the specified email template and sends the email
 // through SMTP.
 func SendEmailTemplate(
+	ctx context.Context,
 	from, replyTo string,
 	to, cc, bcc []string,
 	templateTitle TemplateTitle,
@@ -139,13 +149,14 @@ func SendEmailTemplate(
 ) error {
 
 	// execute the email template
-	subject, bodyText, bodyHTML, err := ExecuteTemplate(templateTitle, data)
+	subject, bodyText, bodyHTML, err := ExecuteTemplate(ctx, templateTitle,
+		data)
 	if err != nil {
 		return err
 	}
 
 	// wrap HTML email body with header and footer
-	_, _, newBodyHTML, err := ExecuteTemplate(templateTitleHeaderFooter,
+	_, _, newBodyHTML, err := ExecuteTemplate(ctx, templateTitleHeaderFooter,
 		struct{ Body string }{bodyHTML})
 	if err != nil && err == gorm.ErrRecordNotFound {
 		return err
@@ -156,11 +167,11 @@ func SendEmailTemplate(
 	// send the email
 	switch sendingMethod {
 	case sendingMethodSMTP:
-		return SendEmailSMTP(from, replyTo, to, cc, bcc, subject, bodyText,
-			bodyHTML)
+		return SendEmailSMTP(ctx, from, replyTo, to, cc, bcc, subject,
+			bodyText, bodyHTML)
 	case sendingMehtodSES:
-		return SendEmailSES(from, replyTo, to, cc, bcc, subject, bodyText,
-			bodyHTML)
+		return SendEmailSES(ctx, from, replyTo, to, cc, bcc, subject,
+			bodyText, bodyHTML)
 	}
 
 	return errors.New("no email sending method specified")
@@ -168,11 +179,19 @@ func SendEmailTemplate(
 
 // SendEmailSMTP sends an email through SMTP.
 func SendEmailSMTP(
+	ctx context.Context,
 	from, replyTo string,
 	to, cc, bcc []string,
 	subject, bodyText, bodyHTML string,
 ) error {
 
+	ctx, span := tracing.StartKind(ctx, "email.SendEmailSMTP",
+		tracing.SpanKindClient)
+	defer span.End()
+
+	span.SetAttribute("smtp.host", smtpHost)
+	span.SetAttribute("email.recipients", len(to)+len(cc)+len(bcc))
+
 	// initialize SMTP client
 	dialer := gomail.NewDialer(smtpHost, smtpPort, smtpUsername, smtpPassword)
 
@@ -211,16 +230,17 @@ func SendEmailSMTP(
 	}
 
 	// send email
-	err := dialer.DialAndSend(message)
+	err := sendSMTP(ctx, dialer, message)
+	span.RecordError(err)
 
 	if !logEmails {
 		return err
 	}
 
 	// log the result of sending the email
-	if err := createEmailLog(data.DB(), sendingMethod, 0, to, cc, bcc, subject,
-		bodyText, bodyHTML, err); err != nil {
-		logrus.Error(err)
+	if err := createEmailLog(data.DB().WithContext(ctx), sendingMethod, 0,
+		to, cc, bcc, subject, bodyText, bodyHTML, err); err != nil {
+		logrus.WithContext(ctx).Error(err)
 	}
 
 	return err
@@ -229,11 +249,19 @@ func SendEmailSMTP(
 
 // SendEmailSES sends an email through Amazon SES.
 func SendEmailSES(
+	ctx context.Context,
 	from, replyTo string,
 	to, cc, bcc []string,
 	subject, bodyText, bodyHTML string,
 ) error {
 
+	ctx, span := tracing.StartKind(ctx, "email.SendEmailSES",
+		tracing.SpanKindClient)
+	defer span.End()
+
+	span.SetAttribute("ses.region", sesRegion)
+	span.SetAttribute("email.recipients", len(to)+len(cc)+len(bcc))
+
 	// create AWS session
 	awsSession := session.New(&aws.Config{
 		Region: aws.String(sesRegion),
@@ -303,18 +331,38 @@ func SendEmailSES(
 	}
 
 	// send email
-	_, err := sesSession.SendEmail(sesEmailInput)
+	_, err := sesSession.SendEmailWithContext(ctx, sesEmailInput)
+	span.RecordError(err)
 
 	if !logEmails {
 		return err
 	}
 
 	// log the result of sending the email
-	if err := createEmailLog(data.DB(), sendingMethod, 0, to, cc, bcc, subject,
-		bodyText, bodyHTML, err); err != nil {
-		logrus.Error(err)
+	if err := createEmailLog(data.DB().WithContext(ctx), sendingMethod, 0,
+		to, cc, bcc, subject, bodyText, bodyHTML, err); err != nil {
+		logrus.WithContext(ctx).Error(err)
 	}
 
 	return err
 
 }
+
+// sendSMTP connects to the SMTP server and sends the supplied message. The
+// connection is recorded as a separate span as it includes the TLS handshake
+// and authentication.
+func sendSMTP(ctx context.Context, dialer *gomail.Dialer,
+	message *gomail.Message) error {
+
+	_, span := tracing.StartKind(ctx, "smtp.Dial", tracing.SpanKindClient)
+	sender, err := dialer.Dial()
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		return err
+	}
+	defer sender.Close()
+
+	return gomail.Send(sender, message)
+
+}
@@ -0,0 +1,25 @@
+package email
+
+import (
+	"context"
+	"time"
+
+	"web-app/data"
+	"web-app/jobs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// init registers jobs that remove email logs once they are past retention.
+func init() {
+	if err := jobs.Register(&jobs.Job{
+		Name:     "email_log_retention",
+		Schedule: "0 4 * * *",
+		Run: func(ctx context.Context) error {
+			return deleteEmailLogBefore(data.DB(),
+				time.Now().AddDate(0, 0, -emailLogRetentionDays))
+		},
+	}); err != nil {
+		logrus.Fatal(err)
+	}
+}
@@ -2,6 +2,7 @@ package email
 
 import (
 	"encoding/json"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -60,3 +61,11 @@ func createEmailLog(db *gorm.DB, sendingMethod string, originalEmailID uint,
 	}).Error
 
 }
+
+// deleteEmailLogBefore permanently deletes all email log records created before
+// the supplied time.
+func deleteEmailLogBefore(db *gorm.DB, before time.Time) error {
+	return db.Unscoped().
+		Where("created_at < ?", before).
+		Delete(&emailLog{}).Error
+}
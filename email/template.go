@@ -2,9 +2,11 @@ package email
 
 import (
 	"bytes"
+	"context"
 	"text/template"
 
 	"web-app/data"
+	"web-app/tracing"
 )
 
 // TemplateTitle defines a unique title for retrieving an email template.
@@ -30,11 +32,20 @@ type SignupData struct {
 
 // ExecuteTemplate loads and executes the specified template with the supplied
 // data.
-func ExecuteTemplate(templateTitle TemplateTitle,
+func ExecuteTemplate(ctx context.Context, templateTitle TemplateTitle,
 	templateData interface{}) (subject, bodyText, bodyHTML string, err error) {
 
+	ctx, span := tracing.Start(ctx, "email.ExecuteTemplate")
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	span.SetAttribute("email.template", string(templateTitle))
+
 	// load the template by title
-	tpl, err := getEmailTemplateByTitle(data.DB(), templateTitle)
+	tpl, err := getEmailTemplateByTitle(data.DB().WithContext(ctx),
+		templateTitle)
 	if err != nil {
 		return "", "", "", err
 	}
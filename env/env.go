@@ -122,12 +122,13 @@ func GetBool(key string) (bool, error) {
 // the supplied default value if the environment variable is not set or is not
 // valid.
 func GetBoolSafe(key string, defaultVal bool) bool {
+	if os.Getenv(key) == "" {
+		return defaultVal
+	}
 	val, err := GetBool(key)
 	if err != nil {
 		logrus.Error(err)
 		return defaultVal
-	} else if !val {
-		return defaultVal
 	}
 	return val
 }
@@ -0,0 +1,42 @@
+package env
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGetBoolSafe(t *testing.T) {
+
+	const key = "WEB_APP_TEST_BOOL"
+	defer os.Unsetenv(key)
+
+	tests := []struct {
+		value      string
+		defaultVal bool
+		want       bool
+	}{
+		// unset and invalid values fall back to the default
+		{"", true, true},
+		{"", false, false},
+		{"not a bool", true, true},
+		{"not a bool", false, false},
+		// an explicit false is honored even if the default is true
+		{"false", true, false},
+		{"0", true, false},
+		{"false", false, false},
+		{"true", false, true},
+		{"1", true, true},
+	}
+
+	for _, test := range tests {
+
+		os.Setenv(key, test.value)
+
+		if got := GetBoolSafe(key, test.defaultVal); got != test.want {
+			t.Errorf("GetBoolSafe(%q, %v) = %v, want %v", test.value,
+				test.defaultVal, got, test.want)
+		}
+
+	}
+
+}
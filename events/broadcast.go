@@ -0,0 +1,173 @@
+package events
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"web-app/data"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// pollBatchSize is the maximum number of stored events read each time the
+	// database broadcaster checks for new events.
+	pollBatchSize = 100
+	// pollLookback is how far back the database broadcaster reads stored
+	// events. Ids are assigned when an event is inserted but concurrent
+	// inserts may commit out of order, so an event may only become visible
+	// after events with higher ids have been read.
+	pollLookback = 30 * time.Second
+)
+
+// Broadcaster delivers published events to the server instances that users
+// are connected to. Implementations assign each event an id that is greater
+// than the id of any event published before it and call Deliver on every
+// server instance. Start is called once before any event is published.
+type Broadcaster interface {
+	Start() error
+	Broadcast(userID uint, event *Event) error
+}
+
+// LocalBroadcaster delivers events to users connected to this server instance
+// only. This is suitable when the application is run as a single instance.
+type LocalBroadcaster struct {
+	lastID uint64
+}
+
+// NewLocalBroadcaster creates a broadcaster that delivers events to users
+// connected to this server instance.
+func NewLocalBroadcaster() *LocalBroadcaster {
+
+	// ids start from the current time so that clients reconnecting after a
+	// restart are not sent events with ids lower than those already received
+	return &LocalBroadcaster{
+		lastID: uint64(time.Now().UnixNano()),
+	}
+
+}
+
+// Start does nothing as events are delivered as they are published.
+func (b *LocalBroadcaster) Start() error {
+	return nil
+}
+
+// Broadcast assigns the supplied event an id and delivers it.
+func (b *LocalBroadcaster) Broadcast(userID uint, event *Event) error {
+
+	event.ID = atomic.AddUint64(&b.lastID, 1)
+	Deliver(userID, event)
+
+	return nil
+
+}
+
+// DatabaseBroadcaster delivers events to users connected to any server
+// instance. Events are stored in the database and every instance polls for
+// new events, so delivery is delayed by up to the poll interval.
+type DatabaseBroadcaster struct {
+	pollInterval time.Duration
+}
+
+// NewDatabaseBroadcaster creates a broadcaster that shares events between
+// server instances through the database, polling for new events at the
+// supplied interval.
+func NewDatabaseBroadcaster(pollInterval time.Duration) *DatabaseBroadcaster {
+	return &DatabaseBroadcaster{
+		pollInterval: pollInterval,
+	}
+}
+
+// Start begins polling for events stored by any server instance.
+func (b *DatabaseBroadcaster) Start() error {
+
+	// only deliver events published after this instance started
+	go pollEventRecords(time.Now(), b.pollInterval)
+
+	return nil
+
+}
+
+// Broadcast stores the supplied event, the event is assigned the id of the
+// stored record and delivered once it is read back by each server instance.
+func (b *DatabaseBroadcaster) Broadcast(userID uint, event *Event) error {
+
+	item := &eventRecord{
+		UserID: userID,
+		Type:   event.Type,
+		Data:   string(event.Data),
+	}
+
+	if err := createEventRecord(context.Background(), data.DB(),
+		item); err != nil {
+		return err
+	}
+
+	event.ID = item.ID
+
+	return nil
+
+}
+
+// pollEventRecords periodically delivers events stored within the lookback,
+// ignoring events created before the supplied time. Events are tracked by id
+// until they leave the lookback so that each event is delivered once even if
+// it became visible after events with higher ids.
+func pollEventRecords(since time.Time, interval time.Duration) {
+
+	// delivered stores the creation time of each event delivered within the
+	// lookback
+	delivered := map[uint64]time.Time{}
+
+	for range time.Tick(interval) {
+
+		cutoff := time.Now().Add(-pollLookback)
+		if cutoff.Before(since) {
+			cutoff = since
+		}
+
+		// forget events that are no longer read
+		for id, createdAt := range delivered {
+			if createdAt.Before(cutoff) {
+				delete(delivered, id)
+			}
+		}
+
+		var afterID uint64
+		for {
+
+			items, err := listEventRecordSince(context.Background(), data.DB(),
+				cutoff, afterID, pollBatchSize)
+			if err != nil {
+				logrus.Error(err)
+				break
+			}
+
+			for _, item := range items {
+				afterID = item.ID
+				if _, ok := delivered[item.ID]; ok {
+					continue
+				}
+				delivered[item.ID] = item.CreatedAt
+
+				event := &Event{
+					ID:   item.ID,
+					Type: item.Type,
+					Time: item.CreatedAt,
+				}
+				if item.Data != "" {
+					event.Data = []byte(item.Data)
+				}
+				Deliver(item.UserID, event)
+			}
+
+			// keep reading until we have caught up
+			if len(items) < pollBatchSize {
+				break
+			}
+
+		}
+
+	}
+}
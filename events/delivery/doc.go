@@ -0,0 +1,3 @@
+// Package delivery exposes an API endpoint that streams events to the logged
+// in user as server-sent events.
+package delivery
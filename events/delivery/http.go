@@ -0,0 +1,117 @@
+package delivery
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"web-app/events"
+	"web-app/identity"
+	"web-app/server"
+	"web-app/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// init binds the events API with the application router.
+func init() {
+	server.Router().GET(eventsEndpoint, user.JWTAuthMiddleware(), streamEvents)
+}
+
+const (
+	// eventsEndpoint the API endpoint that streams events to the logged in
+	// user.
+	eventsEndpoint = "/events"
+	// lastEventIDHeader is the header clients use to supply the id of the last
+	// event received when reconnecting.
+	lastEventIDHeader = "Last-Event-ID"
+	// retryMilliseconds is the reconnection delay suggested to clients.
+	retryMilliseconds = 3000
+)
+
+// streamEvents streams events published to the logged in user as server-sent
+// events. Clients reconnecting with a Last-Event-ID header receive any events
+// they missed that are still held by the server. The stream is closed after a
+// logout event is sent.
+func streamEvents(c *gin.Context) {
+
+	userID, ok := identity.RequestUserID(c)
+	if !ok {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// an invalid last event id is ignored and no events are replayed
+	lastEventID, _ := strconv.ParseUint(c.GetHeader(lastEventIDHeader), 10, 64)
+
+	subscription := events.Subscribe(userID, lastEventID)
+	defer subscription.Close()
+
+	heartbeat := time.NewTicker(events.HeartbeatInterval())
+	defer heartbeat.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !writeEvent(c, fmt.Sprintf("retry: %d\n\n", retryMilliseconds)) {
+		return
+	}
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-subscription.Events():
+			// the subscription was closed because the client fell behind, the
+			// client will reconnect and resume from the last event received
+			if !ok {
+				return
+			}
+
+			data := string(event.Data)
+			if data == "" {
+				data = "{}"
+			}
+
+			if !writeEvent(c, fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n",
+				event.ID, event.Type, data)) {
+				return
+			}
+
+			if event.Type == user.LogoutEvent {
+				return
+			}
+		case <-heartbeat.C:
+			if !writeEvent(c, ": heartbeat\n\n") {
+				return
+			}
+		}
+	}
+
+}
+
+// writeEvent writes the supplied server-sent event message and flushes it to
+// the client. Each write extends the write deadline of the connection so the
+// stream outlives the server write timeout. Returns false if the stream should
+// be closed.
+func writeEvent(c *gin.Context, message string) bool {
+
+	if err := server.SetWriteDeadline(c,
+		time.Now().Add(2*events.HeartbeatInterval())); err != nil {
+		logrus.WithContext(c).Debug(err)
+	}
+
+	if _, err := c.Writer.WriteString(message); err != nil {
+		logrus.WithContext(c).Debug(err)
+		return false
+	}
+
+	c.Writer.Flush()
+
+	return true
+
+}
@@ -0,0 +1,33 @@
+// Package events provides a hub for pushing events to connected users. Events
+// are published to a user with Publish and delivered to every subscription the
+// user holds, such as a server-sent events stream. Recent events are kept so
+// that clients reconnecting with the id of the last event they received do not
+// miss any events.
+//
+// When the application is run with more than one server instance events must
+// be shared between instances using the database broadcaster so that users
+// receive events regardless of which instance they are connected to.
+//
+// Environment:
+//     WEB_APP_EVENTS_BROADCAST
+//         string - the method used to deliver published events; one of local
+//                  or database. The database broadcaster delivers events to
+//                  users connected to any server instance.
+//                  Default: local
+//     WEB_APP_EVENTS_POLL_INTERVAL
+//         int - the number of seconds between checks for events published by
+//               other server instances when using the database broadcaster.
+//               Default: 1
+//     WEB_APP_EVENTS_HEARTBEAT_INTERVAL
+//         int - the number of seconds between heartbeats sent to idle event
+//               streams.
+//               Default: 15
+//     WEB_APP_EVENTS_HISTORY_SIZE
+//         int - the number of recent events kept for each user so they can be
+//               replayed to reconnecting clients.
+//               Default: 50
+//     WEB_APP_EVENTS_HISTORY_TTL
+//         int - the number of seconds recent events are kept for users that are
+//               not connected.
+//               Default: 300
+package events
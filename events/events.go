@@ -0,0 +1,259 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"web-app/env"
+
+	"github.com/sirupsen/logrus"
+)
+
+// init configures the event hub and the broadcaster used to deliver events.
+func init() {
+
+	heartbeatInterval = time.Duration(
+		env.GetIntSafe(heartbeatIntervalVariable, 15)) * time.Second
+	historySize = env.GetIntSafe(historySizeVariable, 50)
+	historyTTL = time.Duration(
+		env.GetIntSafe(historyTTLVariable, 300)) * time.Second
+
+	switch mode := env.GetStringSafe(broadcastVariable, broadcastLocal); mode {
+	case broadcastLocal:
+		SetBroadcaster(NewLocalBroadcaster())
+	case broadcastDatabase:
+		SetBroadcaster(NewDatabaseBroadcaster(time.Duration(
+			env.GetIntSafe(pollIntervalVariable, 1)) * time.Second))
+	default:
+		logrus.Fatalf("invalid event broadcaster '%s'", mode)
+	}
+
+}
+
+const (
+	// broadcastVariable defines the environment variable for the method used
+	// to deliver published events.
+	broadcastVariable = "WEB_APP_EVENTS_BROADCAST"
+	// pollIntervalVariable defines the environment variable for the number of
+	// seconds between checks for events published by other server instances.
+	pollIntervalVariable = "WEB_APP_EVENTS_POLL_INTERVAL"
+	// heartbeatIntervalVariable defines the environment variable for the
+	// number of seconds between heartbeats sent to idle event streams.
+	heartbeatIntervalVariable = "WEB_APP_EVENTS_HEARTBEAT_INTERVAL"
+	// historySizeVariable defines the environment variable for the number of
+	// recent events kept for each user.
+	historySizeVariable = "WEB_APP_EVENTS_HISTORY_SIZE"
+	// historyTTLVariable defines the environment variable for the number of
+	// seconds recent events are kept for users that are not connected.
+	historyTTLVariable = "WEB_APP_EVENTS_HISTORY_TTL"
+	// broadcastLocal delivers events to users connected to this server
+	// instance only.
+	broadcastLocal = "local"
+	// broadcastDatabase delivers events to users connected to any server
+	// instance by storing events in the database.
+	broadcastDatabase = "database"
+	// subscriptionBuffer is the number of events, in addition to any replayed
+	// events, that may be waiting to be sent to a subscriber before the
+	// subscriber is considered too slow and is dropped.
+	subscriptionBuffer = 16
+)
+
+// heartbeatInterval determines how often a heartbeat is sent to idle event
+// streams.
+var heartbeatInterval time.Duration
+
+// historySize determines the number of recent events kept for each user.
+var historySize int
+
+// historyTTL determines how long recent events are kept for users that are not
+// connected.
+var historyTTL time.Duration
+
+// broadcaster is used to deliver published events.
+var broadcaster Broadcaster
+
+// hub stores event subscriptions and recent events for each user.
+var hub = struct {
+	mutex         *sync.Mutex
+	subscriptions map[uint]map[*Subscription]struct{}
+	history       map[uint][]*Event
+}{
+	mutex:         &sync.Mutex{},
+	subscriptions: map[uint]map[*Subscription]struct{}{},
+	history:       map[uint][]*Event{},
+}
+
+// Subscription receives the events published to a user.
+type Subscription struct {
+	userID uint
+	events chan *Event
+}
+
+// Events gets the channel on which events are received. The channel is closed
+// when the subscription is closed or if the subscriber falls too far behind,
+// in which case the client should reconnect and resume from the last event it
+// received.
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+// Close stops the subscription from receiving events.
+func (s *Subscription) Close() {
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	removeSubscription(s)
+
+}
+
+// SetBroadcaster replaces the broadcaster used to deliver published events.
+func SetBroadcaster(b Broadcaster) {
+	broadcaster = b
+}
+
+// Start starts the broadcaster and removal of recent events held for users that
+// are no longer connected. This should be called once before the server starts
+// handling requests.
+func Start() error {
+
+	if err := broadcaster.Start(); err != nil {
+		return err
+	}
+
+	go pruneHistory(historyTTL)
+
+	return nil
+
+}
+
+// HeartbeatInterval gets the interval at which a heartbeat should be sent to
+// idle event streams.
+func HeartbeatInterval() time.Duration {
+	return heartbeatInterval
+}
+
+// Publish sends an event of the specified type to the specified user. The
+// supplied data is encoded as JSON and may be nil.
+func Publish(userID uint, eventType string, data interface{}) error {
+
+	event := &Event{
+		Type: eventType,
+		Time: time.Now(),
+	}
+
+	if data != nil {
+		dataBytes, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		event.Data = dataBytes
+	}
+
+	return broadcaster.Broadcast(userID, event)
+
+}
+
+// Subscribe starts receiving the events published to the specified user. If
+// the id of the last event received by the client is supplied any more recent
+// events that are still held are replayed to the subscription.
+func Subscribe(userID uint, lastEventID uint64) *Subscription {
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	s := &Subscription{
+		userID: userID,
+		events: make(chan *Event, historySize+subscriptionBuffer),
+	}
+
+	// replay any events the client missed while disconnected
+	if lastEventID > 0 {
+		for _, event := range hub.history[userID] {
+			if event.ID > lastEventID {
+				s.events <- event
+			}
+		}
+	}
+
+	if hub.subscriptions[userID] == nil {
+		hub.subscriptions[userID] = map[*Subscription]struct{}{}
+	}
+	hub.subscriptions[userID][s] = struct{}{}
+
+	return s
+
+}
+
+// Deliver sends an event to every subscription held by the specified user on
+// this server instance. Broadcasters call this function once an event has been
+// assigned an id.
+func Deliver(userID uint, event *Event) {
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	// keep the event so it can be replayed to reconnecting clients
+	history := append(hub.history[userID], event)
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+	hub.history[userID] = history
+
+	for s := range hub.subscriptions[userID] {
+		select {
+		case s.events <- event:
+		default:
+			// drop subscribers that are not keeping up so one slow client
+			// cannot hold up delivery
+			logrus.Debugf("dropping slow event subscriber for user %d", userID)
+			removeSubscription(s)
+		}
+	}
+
+}
+
+// removeSubscription removes the supplied subscription from the hub and closes
+// the subscription channel. The hub mutex must be held by the caller.
+func removeSubscription(s *Subscription) {
+
+	subscriptions, ok := hub.subscriptions[s.userID]
+	if !ok {
+		return
+	}
+
+	if _, ok := subscriptions[s]; !ok {
+		return
+	}
+
+	delete(subscriptions, s)
+	close(s.events)
+
+	if len(subscriptions) == 0 {
+		delete(hub.subscriptions, s.userID)
+	}
+
+}
+
+// pruneHistory periodically removes the recent events of users that are not
+// connected once the events are older than the supplied time to live.
+func pruneHistory(ttl time.Duration) {
+	for range time.Tick(ttl) {
+
+		hub.mutex.Lock()
+
+		for userID, history := range hub.history {
+			if _, ok := hub.subscriptions[userID]; ok {
+				continue
+			}
+			if len(history) == 0 ||
+				time.Since(history[len(history)-1].Time) > ttl {
+				delete(hub.history, userID)
+			}
+		}
+
+		hub.mutex.Unlock()
+
+	}
+}
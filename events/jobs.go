@@ -0,0 +1,34 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"web-app/data"
+	"web-app/jobs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// init registers jobs that remove stored events once they are no longer held
+// for reconnecting clients.
+func init() {
+	if err := jobs.Register(&jobs.Job{
+		Name:     "event_retention",
+		Schedule: "45 * * * *",
+		Run: func(ctx context.Context) error {
+
+			// keep events the database broadcaster may still be reading
+			retention := historyTTL
+			if retention < pollLookback {
+				retention = pollLookback
+			}
+
+			return DeleteEventRecordsBefore(ctx, data.DB(),
+				time.Now().Add(-retention))
+
+		},
+	}); err != nil {
+		logrus.Fatal(err)
+	}
+}
@@ -0,0 +1,36 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"web-app/data"
+)
+
+// init migrates the database model.
+func init() {
+	data.DB().AutoMigrate(
+		eventRecord{},
+	)
+}
+
+/* Data Types */
+
+// Event is a message pushed to a user.
+type Event struct {
+	ID   uint64          `json:"id"`   // increases with each event so clients can resume after reconnecting
+	Type string          `json:"type"` // identifies the kind of event, e.g. logout
+	Data json.RawMessage `json:"data,omitempty"`
+	Time time.Time       `json:"time"`
+}
+
+// eventRecord stores a published event so it can be delivered by every server
+// instance when using the database broadcaster.
+type eventRecord struct {
+	ID        uint64    `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	UserID uint   `json:"user_id"`
+	Type   string `json:"type"`
+	Data   string `gorm:"type:text" json:"data"` // JSON encoded event data
+}
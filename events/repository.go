@@ -0,0 +1,43 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// listEventRecordSince retrieves up to limit events created at or after the
+// supplied time with an id greater than afterID, ordered by id.
+func listEventRecordSince(ctx context.Context, db *gorm.DB, since time.Time,
+	afterID uint64, limit int) ([]*eventRecord, error) {
+
+	var items []*eventRecord
+
+	if err := db.WithContext(ctx).Model(&eventRecord{}).
+		Where("created_at >= ?", since).
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	return items, nil
+
+}
+
+// createEventRecord inserts the supplied event record.
+func createEventRecord(ctx context.Context, db *gorm.DB,
+	item *eventRecord) error {
+	return db.WithContext(ctx).Create(item).Error
+}
+
+// DeleteEventRecordsBefore deletes all stored events created before the
+// supplied time.
+func DeleteEventRecordsBefore(ctx context.Context, db *gorm.DB,
+	before time.Time) error {
+	return db.WithContext(ctx).
+		Where("created_at < ?", before).
+		Delete(&eventRecord{}).Error
+}
@@ -0,0 +1,2 @@
+// Package delivery exposes admin API endpoints for managing feature flags.
+package delivery
@@ -0,0 +1,275 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"web-app/data"
+	"web-app/flags"
+	"web-app/httperror"
+	"web-app/server"
+	"web-app/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// init creates the flag permission and binds the flag admin API.
+func init() {
+
+	// create the permission required to manage feature flags
+	if err := user.CreatePrivatePermissions(context.Background(), []string{
+		flagsPermission,
+	}, nil); err != nil {
+		logrus.Fatal(err)
+	}
+
+	flagsGroup := server.AdminRouter().Group(flagsEndpoint,
+		user.AuthMiddleware(),
+		user.RequireAllPermissionsMiddleware(flagsPermission))
+
+	flagsGroup.GET("", listFlags)
+	flagsGroup.POST("", createFlag)
+	flagsGroup.GET(flagEndpoint, getFlag)
+	flagsGroup.PUT(flagEndpoint, updateFlag)
+	flagsGroup.DELETE(flagEndpoint, deleteFlag)
+
+}
+
+const (
+	// flagsEndpoint the API endpoint used to list and create feature flags.
+	flagsEndpoint = "/admin/flags"
+	// flagEndpoint the API endpoint used to view, update, and delete a feature
+	// flag, relative to the flags endpoint.
+	flagEndpoint = "/:key"
+	// flagsPermission allows a user to manage feature flags.
+	flagsPermission = "flags"
+	// flagNotFound is an error message returned when the requested flag does
+	// not exist.
+	flagNotFound = "flag not found"
+	// flagExists is an error message returned when creating a flag with a key
+	// that is already in use.
+	flagExists = "flag already exists"
+)
+
+// listFlags responds with all feature flags.
+func listFlags(c *gin.Context) {
+
+	items, err := flags.ListFlag(c, data.DB())
+	if err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	if items == nil {
+		items = []*flags.Flag{}
+	}
+
+	c.JSON(http.StatusOK, items)
+
+}
+
+// getFlag responds with the requested feature flag.
+func getFlag(c *gin.Context) {
+
+	item, err := flags.GetFlagByKey(c, data.DB(), c.Param("key"))
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, httperror.ErrorResponse{
+			ErrorMessage: flagNotFound,
+		})
+		return
+	} else if err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, item)
+
+}
+
+// createFlag creates a new feature flag.
+func createFlag(c *gin.Context) {
+
+	var req flagRequest
+
+	// read request parameters
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: "invalid request body",
+		})
+		return
+	}
+
+	if err := validateFlagRequest(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	// check if the flag already exists
+	_, err := flags.GetFlagByKey(c, data.DB(), req.Key)
+	if err == nil {
+		c.JSON(http.StatusConflict, httperror.ErrorResponse{
+			ErrorMessage: flagExists,
+		})
+		return
+	} else if err != gorm.ErrRecordNotFound {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	item := &flags.Flag{}
+	applyFlagRequest(item, &req)
+
+	if err := flags.SaveFlag(c, data.DB(), item); err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	flags.ClearCache()
+
+	c.JSON(http.StatusCreated, item)
+
+}
+
+// updateFlag updates the requested feature flag.
+func updateFlag(c *gin.Context) {
+
+	var req flagRequest
+
+	// read request parameters
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: "invalid request body",
+		})
+		return
+	}
+
+	// the flag key cannot be changed
+	req.Key = c.Param("key")
+
+	if err := validateFlagRequest(&req); err != nil {
+		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	item, err := flags.GetFlagByKey(c, data.DB(), req.Key)
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, httperror.ErrorResponse{
+			ErrorMessage: flagNotFound,
+		})
+		return
+	} else if err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	applyFlagRequest(item, &req)
+
+	if err := flags.SaveFlag(c, data.DB(), item); err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	flags.ClearCache()
+
+	c.JSON(http.StatusOK, item)
+
+}
+
+// deleteFlag deletes the requested feature flag.
+func deleteFlag(c *gin.Context) {
+
+	item, err := flags.GetFlagByKey(c, data.DB(), c.Param("key"))
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, httperror.ErrorResponse{
+			ErrorMessage: flagNotFound,
+		})
+		return
+	} else if err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	if err := flags.DeleteFlag(c, data.DB(), item); err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
+	flags.ClearCache()
+
+	c.Status(http.StatusNoContent)
+
+}
+
+// validateFlagRequest checks that the supplied flag request is valid.
+func validateFlagRequest(req *flagRequest) error {
+
+	if req.Key == "" {
+		return fmt.Errorf("key is required")
+	}
+
+	if req.Percentage < 0 || req.Percentage > 100 {
+		return fmt.Errorf("percentage must be between 0 and 100")
+	}
+
+	for _, target := range req.Targets {
+		switch target.Type {
+		case flags.TargetUser:
+			if _, err := strconv.ParseUint(target.Value, 10, 64); err != nil {
+				return fmt.Errorf("user target '%s' must be a user id",
+					target.Value)
+			}
+		case flags.TargetRole, flags.TargetPermission:
+			if target.Value == "" {
+				return fmt.Errorf("%s target requires a value", target.Type)
+			}
+		default:
+			return fmt.Errorf(
+				"target type must be one of user, role, or permission")
+		}
+	}
+
+	return nil
+
+}
+
+// applyFlagRequest copies the supplied flag request to the supplied flag.
+func applyFlagRequest(item *flags.Flag, req *flagRequest) {
+	item.Key = req.Key
+	item.Description = req.Description
+	item.Public = req.Public
+	item.Enabled = req.Enabled
+	item.Percentage = req.Percentage
+	item.Targets = req.Targets
+}
@@ -0,0 +1,15 @@
+package delivery
+
+import (
+	"web-app/flags"
+)
+
+// flagRequest is used to read a request to create or update a flag.
+type flagRequest struct {
+	Key         string             `json:"key"`
+	Description string             `json:"description"`
+	Public      bool               `json:"public"`
+	Enabled     bool               `json:"enabled"`
+	Percentage  int                `json:"percentage"`
+	Targets     []flags.FlagTarget `json:"targets"`
+}
@@ -0,0 +1,12 @@
+// Package flags provides feature flags that allow features to be turned on for
+// every user, a percentage of users, or targeted users, roles, and permissions
+// without redeploying the application. Flags are stored in the database and
+// held in a short lived local cache so they can be evaluated on every request.
+//
+// Environment:
+//     WEB_APP_FLAGS_CACHE_TTL
+//         int - the number of seconds flags are cached before they are reloaded
+//               from the database. Changes made by other server instances take
+//               up to this long to apply.
+//               Default: 10
+package flags
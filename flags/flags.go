@@ -0,0 +1,267 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"web-app/data"
+	"web-app/env"
+	"web-app/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// init configures the feature flag cache.
+func init() {
+	cacheTTL = time.Duration(env.GetIntSafe(cacheTTLVariable, 10)) * time.Second
+}
+
+const (
+	// cacheTTLVariable defines the environment variable for the number of
+	// seconds flags are cached before they are reloaded from the database.
+	cacheTTLVariable = "WEB_APP_FLAGS_CACHE_TTL"
+)
+
+// userContextKey is the context key used to store the user flags are evaluated
+// for outside of a request.
+type userContextKey struct{}
+
+// cacheTTL determines how long flags are cached before they are reloaded.
+var cacheTTL time.Duration
+
+// flagCache stores all flags by key so they can be evaluated without querying
+// the database.
+var flagCache = struct {
+	mutex    *sync.Mutex
+	flags    map[string]*Flag
+	loadedAt time.Time
+}{
+	mutex: &sync.Mutex{},
+}
+
+// WithUser returns a copy of the supplied context that evaluates flags for the
+// supplied user. This is only needed outside of request handlers, flags are
+// evaluated for the authenticated user when a request context is supplied.
+func WithUser(ctx context.Context, u *user.User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, u)
+}
+
+// Enabled checks whether the specified flag is on for the user associated with
+// the supplied context. Unknown flags are always off.
+func Enabled(ctx context.Context, key string) bool {
+	return EnabledForUser(ctx, key, contextUser(ctx))
+}
+
+// EnabledForUser checks whether the specified flag is on for the supplied user.
+// The user may be nil in which case only flags that are on for every user are
+// on. Unknown flags are always off.
+func EnabledForUser(ctx context.Context, key string, u *user.User) bool {
+
+	flags, err := getFlags(ctx)
+	if err != nil {
+		logrus.WithContext(ctx).Error(err)
+		return false
+	}
+
+	flag, ok := flags[key]
+	if !ok {
+		logrus.WithContext(ctx).Debugf("unknown feature flag '%s'", key)
+		return false
+	}
+
+	on, err := evaluate(ctx, flag, u, &userTargets{})
+	if err != nil {
+		logrus.WithContext(ctx).Error(err)
+		return false
+	}
+
+	return on
+
+}
+
+// PublicFlags evaluates every public flag for the supplied user. The result is
+// intended to be shared with the front-end.
+func PublicFlags(ctx context.Context, u *user.User) (map[string]bool, error) {
+
+	flags, err := getFlags(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// user roles and permissions are looked up at most once for all flags
+	targets := &userTargets{}
+
+	results := map[string]bool{}
+
+	for key, flag := range flags {
+		if !flag.Public {
+			continue
+		}
+
+		on, err := evaluate(ctx, flag, u, targets)
+		if err != nil {
+			return nil, err
+		}
+
+		results[key] = on
+	}
+
+	return results, nil
+
+}
+
+// ClearCache removes all cached flags so that changes are applied immediately
+// on this server instance.
+func ClearCache() {
+
+	flagCache.mutex.Lock()
+	defer flagCache.mutex.Unlock()
+
+	flagCache.flags = nil
+
+}
+
+// getFlags retrieves all flags by key from the local cache, reloading them from
+// the database if the cache has expired.
+func getFlags(ctx context.Context) (map[string]*Flag, error) {
+
+	flagCache.mutex.Lock()
+	defer flagCache.mutex.Unlock()
+
+	if flagCache.flags != nil && time.Since(flagCache.loadedAt) < cacheTTL {
+		return flagCache.flags, nil
+	}
+
+	items, err := ListFlag(ctx, data.DB())
+	if err != nil {
+		return nil, err
+	}
+
+	flags := make(map[string]*Flag, len(items))
+	for _, item := range items {
+		flags[item.Key] = item
+	}
+
+	flagCache.flags = flags
+	flagCache.loadedAt = time.Now()
+
+	return flags, nil
+
+}
+
+// userTargets stores the role and permission keys of the user a flag is being
+// evaluated for so they are only looked up when required.
+type userTargets struct {
+	roles       map[string]struct{}
+	permissions map[string]struct{}
+}
+
+// evaluate determines whether the supplied flag is on for the supplied user.
+func evaluate(ctx context.Context, flag *Flag, u *user.User,
+	targets *userTargets) (bool, error) {
+
+	if flag.Enabled {
+		return true, nil
+	}
+
+	// anonymous users are never targeted or part of a rollout
+	if u == nil {
+		return false, nil
+	}
+
+	for _, target := range flag.Targets {
+		switch target.Type {
+		case TargetUser:
+			if target.Value == strconv.FormatUint(uint64(u.ID), 10) {
+				return true, nil
+			}
+		case TargetRole:
+			if targets.roles == nil {
+				if err := targets.loadRoles(ctx, u); err != nil {
+					return false, err
+				}
+			}
+			if _, ok := targets.roles[target.Value]; ok {
+				return true, nil
+			}
+		case TargetPermission:
+			if targets.permissions == nil {
+				if err := targets.loadPermissions(ctx, u); err != nil {
+					return false, err
+				}
+			}
+			if _, ok := targets.permissions[target.Value]; ok {
+				return true, nil
+			}
+		}
+	}
+
+	return rolloutBucket(flag.Key, u.ID) < flag.Percentage, nil
+
+}
+
+// loadRoles looks up the role keys of the supplied user.
+func (t *userTargets) loadRoles(ctx context.Context, u *user.User) error {
+
+	roles, err := user.ListRoleByUser(ctx, data.DB(), u.ID)
+	if err != nil {
+		return err
+	}
+
+	t.roles = map[string]struct{}{}
+	for _, role := range roles {
+		t.roles[role.Key] = struct{}{}
+	}
+
+	return nil
+
+}
+
+// loadPermissions looks up the permission keys of the supplied user.
+func (t *userTargets) loadPermissions(ctx context.Context, u *user.User) error {
+
+	permissions, err := user.GetUserPermissions(ctx, u, nil)
+	if err != nil {
+		return err
+	}
+
+	t.permissions = map[string]struct{}{}
+	for _, permission := range permissions {
+		t.permissions[permission.Key] = struct{}{}
+	}
+
+	return nil
+
+}
+
+// rolloutBucket assigns the specified user to one of 100 buckets for the
+// specified flag. Users stay in the same bucket as the rollout percentage
+// increases and buckets are independent between flags.
+func rolloutBucket(key string, userID uint) int {
+	h := fnv.New32a()
+	h.Write([]byte(fmt.Sprintf("%s:%d", key, userID)))
+	return int(h.Sum32() % 100)
+}
+
+// contextUser retrieves the user flags should be evaluated for from the
+// supplied context. Returns nil if there is no user.
+func contextUser(ctx context.Context) *user.User {
+
+	if u, ok := ctx.Value(userContextKey{}).(*user.User); ok {
+		return u
+	}
+
+	if c, ok := ctx.(*gin.Context); ok {
+		if u, err := user.RequestUser(c); err == nil {
+			return u
+		}
+	}
+
+	return nil
+
+}
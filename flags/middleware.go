@@ -0,0 +1,36 @@
+package flags
+
+import (
+	"net/http"
+
+	"web-app/httperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// featureNotFound is the error message returned when a request is made to
+	// an endpoint gated by a flag that is off.
+	featureNotFound = "not found"
+)
+
+// RequireMiddleware gets middleware that only allows requests when the
+// specified flag is on for the user making the request. Otherwise the client
+// receives a 404 - Not Found response so that unreleased features are not
+// revealed. To target users this must be bound after authentication
+// middleware.
+func RequireMiddleware(key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		if !Enabled(c, key) {
+			c.JSON(http.StatusNotFound, httperror.ErrorResponse{
+				ErrorMessage: featureNotFound,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+
+	}
+}
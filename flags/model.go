@@ -0,0 +1,55 @@
+package flags
+
+import (
+	"time"
+
+	"web-app/data"
+)
+
+// init migrates the database model.
+func init() {
+	data.DB().AutoMigrate(
+		Flag{},
+		FlagTarget{},
+	)
+}
+
+/* Data Types */
+
+// Flag determines whether a feature is turned on. A flag is on for every user
+// if it is enabled, otherwise it is on for targeted users and for the rollout
+// percentage of all other users.
+type Flag struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Key         string       `gorm:"size:100;uniqueIndex" json:"key"` // text that uniquely identifies this flag
+	Description string       `json:"description"`                     // a brief description of the feature
+	Public      bool         `gorm:"index" json:"public"`             // public flags are also used on the front-end
+	Enabled     bool         `json:"enabled"`                         // enabled flags are on for every user
+	Percentage  int          `json:"percentage"`                      // percentage of users the flag is on for, from 0 to 100
+	Targets     []FlagTarget `gorm:"constraint:OnDelete:CASCADE" json:"targets"`
+}
+
+// TargetType identifies what a flag target matches.
+type TargetType string
+
+const (
+	// TargetUser matches the user with the target value as their id.
+	TargetUser TargetType = "user"
+	// TargetRole matches users with the target value as one of their roles.
+	TargetRole TargetType = "role"
+	// TargetPermission matches users with the target value as one of their
+	// permissions.
+	TargetPermission TargetType = "permission"
+)
+
+// FlagTarget turns a flag on for the matching users.
+type FlagTarget struct {
+	ID uint `gorm:"primarykey" json:"-"`
+
+	FlagID uint       `gorm:"index" json:"-"`
+	Type   TargetType `json:"type"`
+	Value  string     `json:"value"` // user id, role key, or permission key
+}
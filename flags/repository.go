@@ -0,0 +1,81 @@
+package flags
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// GetFlagByKey retrieves a flag and its targets by the flag key.
+func GetFlagByKey(ctx context.Context, db *gorm.DB, key string) (*Flag, error) {
+
+	var item Flag
+
+	if err := db.WithContext(ctx).Model(&Flag{}).
+		Where("key = ?", key).
+		Preload("Targets").
+		First(&item).Error; err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+
+}
+
+// ListFlag retrieves all defined flags and their targets.
+func ListFlag(ctx context.Context, db *gorm.DB) ([]*Flag, error) {
+
+	var items []*Flag
+
+	if err := db.WithContext(ctx).Model(&Flag{}).
+		Order("key").
+		Preload("Targets").
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	return items, nil
+
+}
+
+// SaveFlag inserts or updates the supplied flag record. The targets of the
+// flag are replaced with the targets of the supplied record.
+func SaveFlag(ctx context.Context, db *gorm.DB, item *Flag) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Omit("Targets").Save(item).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("flag_id = ?", item.ID).
+			Delete(&FlagTarget{}).Error; err != nil {
+			return err
+		}
+
+		for i := range item.Targets {
+			item.Targets[i].ID = 0
+			item.Targets[i].FlagID = item.ID
+		}
+
+		if len(item.Targets) == 0 {
+			return nil
+		}
+
+		return tx.Create(&item.Targets).Error
+
+	})
+}
+
+// DeleteFlag deletes the supplied flag record and its targets.
+func DeleteFlag(ctx context.Context, db *gorm.DB, item *Flag) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Where("flag_id = ?", item.ID).
+			Delete(&FlagTarget{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(item).Error
+
+	})
+}
@@ -3,15 +3,18 @@ module web-app
 go 1.13
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/andybalholm/brotli v1.0.4
 	github.com/aws/aws-sdk-go v1.36.11
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
-	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-gonic/gin v1.6.3
+	github.com/kr/pretty v0.1.0 // indirect
 	github.com/myesui/uuid v1.0.0 // indirect
 	github.com/sirupsen/logrus v1.7.0
 	github.com/twinj/uuid v1.0.0
 	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
 	gopkg.in/stretchr/testify.v1 v1.2.2 // indirect
 	gorm.io/driver/mysql v1.0.3
@@ -14,8 +14,18 @@ import (
 
 // init binds API endpoints for checking application health.
 func init() {
-	server.Router().GET(healthEndpoint,
+
+	// allow monitoring from any origin, health responses are not personalized
+	if err := server.SetCORSPolicy(healthEndpoint, &server.CORSPolicy{
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{http.MethodGet},
+	}); err != nil {
+		logrus.Fatal(err)
+	}
+
+	server.Router().GET(healthEndpoint, cache.ETagMiddleware(),
 		cache.LocalCacheMiddleware(30*time.Second), healthHandler)
+
 }
 
 const (
@@ -34,7 +44,7 @@ func healthHandler(c *gin.Context) {
 	// check if the database is available
 	dbError := data.Ping()
 	if dbError != nil {
-		logrus.Error(dbError)
+		logrus.WithContext(c).Error(dbError)
 	}
 
 	// write health check response
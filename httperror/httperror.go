@@ -2,10 +2,12 @@ package httperror
 
 // define generic HTTP error messages
 const (
-	InternalServerError = "internal server error"
+	InternalServerError   = "internal server error"
+	RequestEntityTooLarge = "request entity too large"
 )
 
 // ErrorResponse is used to respond to an HTTP request with an error message.
 type ErrorResponse struct {
 	ErrorMessage string `json:"error"`
+	IncidentID   string `json:"incident_id,omitempty"` // identifies an unexpected error when reporting it to support
 }
@@ -0,0 +1,11 @@
+// Package idempotency provides middleware that allows clients to safely retry
+// requests that are not naturally idempotent. When a client supplies an
+// Idempotency-Key header with a POST request the first response is stored and
+// replayed for any retry of the same request.
+//
+// Environment:
+//     WEB_APP_IDEMPOTENCY_TTL_HOURS
+//         int - the number of hours a stored response is replayed for retries
+//               of the same request.
+//               Default: 24
+package idempotency
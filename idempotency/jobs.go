@@ -0,0 +1,24 @@
+package idempotency
+
+import (
+	"context"
+
+	"web-app/data"
+	"web-app/jobs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// init registers jobs that remove idempotency records that may no longer be
+// replayed.
+func init() {
+	if err := jobs.Register(&jobs.Job{
+		Name:     "idempotency_retention",
+		Schedule: "15 * * * *",
+		Run: func(ctx context.Context) error {
+			return DeleteExpiredRecords(ctx, data.DB())
+		},
+	}); err != nil {
+		logrus.Fatal(err)
+	}
+}
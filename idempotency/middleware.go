@@ -0,0 +1,361 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"web-app/data"
+	"web-app/env"
+	"web-app/httperror"
+	"web-app/identity"
+	"web-app/server"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// init configures the idempotency package.
+func init() {
+	ttl = time.Duration(env.GetIntSafe(ttlHoursVariable, 24)) * time.Hour
+}
+
+const (
+	// ttlHoursVariable defines an environment variable for the number of hours
+	// a stored response is replayed for retries of the same request.
+	ttlHoursVariable = "WEB_APP_IDEMPOTENCY_TTL_HOURS"
+	// keyHeader is the request header used to supply an idempotency key.
+	keyHeader = "Idempotency-Key"
+	// replayedHeader is the response header that indicates a response was
+	// replayed from a previous request.
+	replayedHeader = "Idempotent-Replayed"
+	// maxKeyLength is the maximum length of an idempotency key.
+	maxKeyLength = 255
+	// keyInProgress is returned when a request with the same idempotency key
+	// is still being handled.
+	keyInProgress = "a request with this idempotency key is in progress"
+	// keyMismatch is returned when an idempotency key is reused with a
+	// different request body.
+	keyMismatch = "idempotency key was used with a different request"
+	// defaultClaimLease is how long a claim on an idempotency key lasts while
+	// the original request is handled if the server has no write timeout.
+	defaultClaimLease = time.Minute
+)
+
+// ttl determines how long a stored response is replayed for retries of the
+// same request.
+var ttl time.Duration
+
+// excludedHeaders stores response headers that are not replayed because they
+// are specific to each response.
+var excludedHeaders = map[string]struct{}{
+	"Content-Encoding": {},
+	"Content-Length":   {},
+	"Date":             {},
+	"Vary":             {},
+	"X-Request-Id":     {},
+}
+
+// KeyMiddleware gets middleware that honors the Idempotency-Key header on POST
+// requests. The first response to a request with an idempotency key is stored
+// and replayed for any retry of the request within the configured time to
+// live. Keys are scoped to the requesting user, or the client IP address for
+// unauthenticated requests, and to the route. While the first request is being
+// handled retries receive a 409 - Conflict response; reusing a key with a
+// different request body results in a 422 - Unprocessable Entity response.
+// Server errors are not stored so that the request may be retried. The key is
+// only claimed for a short lease while the first request is handled so that a
+// request that never completes does not block retries for the full time to
+// live. Keys of unauthenticated requests without a client address, such as
+// requests received on a Unix domain socket without a trusted proxy header, are
+// ignored since there is no requester to scope them to.
+func KeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		// only POST requests with an idempotency key are handled
+		key := c.GetHeader(keyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxKeyLength {
+			c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+				ErrorMessage: fmt.Sprintf(
+					"idempotency key must not exceed %d characters",
+					maxKeyLength),
+			})
+			c.Abort()
+			return
+		}
+
+		requester, ok := requesterOf(c)
+		if !ok {
+			logrus.WithContext(c).Debug(
+				"ignoring idempotency key of a request without a client address")
+			c.Next()
+			return
+		}
+
+		// hash the request body so retries can be compared with the original
+		// request
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			logrus.WithContext(c).Debug(err)
+			c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+				ErrorMessage: "invalid request body",
+			})
+			c.Abort()
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		item := &record{
+			KeyHash:     hashKey(c, requester, key),
+			RequestHash: hashBytes(body),
+			ExpiresAt:   time.Now().Add(claimLease()),
+		}
+
+		// claim the idempotency key, if the key has already been claimed
+		// respond based on the state of the original request
+		if claimed, err := claimKey(c, item); err != nil {
+			logrus.WithContext(c).Error(err)
+			c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+				ErrorMessage: httperror.InternalServerError,
+			})
+			c.Abort()
+			return
+		} else if claimed != nil {
+			respondClaimed(c, item, claimed)
+			c.Abort()
+			return
+		}
+
+		// release the key if the response is not stored so the request may
+		// be retried
+		stored := false
+		defer func() {
+			if !stored {
+				if err := deleteRecord(c, data.DB(), item); err != nil {
+					logrus.WithContext(c).Error(err)
+				}
+			}
+		}()
+
+		// wrap the response writer so we can record the response
+		writer := &responseWriter{
+			ResponseWriter: c.Writer,
+			responseData:   bytes.Buffer{},
+		}
+		c.Writer = writer
+
+		// headers set by outer middleware, such as CORS and security headers,
+		// are set again when a response is replayed so only the headers the
+		// handler sets are stored
+		outer := c.Writer.Header().Clone()
+
+		// execute the next handler function
+		c.Next()
+
+		// do not store server errors
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+
+		// store the response
+		headers := http.Header{}
+		for name, values := range c.Writer.Header() {
+			if _, ok := excludedHeaders[name]; ok ||
+				equalValues(outer[name], values) {
+				continue
+			}
+			headers[name] = values
+		}
+
+		headerBytes, err := json.Marshal(headers)
+		if err != nil {
+			logrus.WithContext(c).Error(err)
+			return
+		}
+
+		// the stored response is replayed for the full time to live
+		item.Completed = true
+		item.ExpiresAt = time.Now().Add(ttl)
+		item.StatusCode = c.Writer.Status()
+		item.Headers = string(headerBytes)
+		item.Body = writer.responseData.Bytes()
+
+		if err := saveRecord(c, data.DB(), item); err != nil {
+			logrus.WithContext(c).Error(err)
+			return
+		}
+
+		stored = true
+
+	}
+}
+
+// claimKey attempts to claim the idempotency key of the supplied record.
+// Returns the existing record if the key has already been claimed. Expired
+// records, including claims whose lease ran out before the original request
+// completed, are replaced.
+func claimKey(c *gin.Context, item *record) (*record, error) {
+
+	existing, err := getRecordByKeyHash(c, data.DB(), item.KeyHash)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	// the key may be reused once the existing record has expired
+	if existing != nil {
+		if existing.ExpiresAt.After(time.Now()) {
+			return existing, nil
+		}
+		if err := deleteRecord(c, data.DB(), existing); err != nil {
+			return nil, err
+		}
+	}
+
+	// if another request claims the key first the insert will fail on the
+	// unique key hash
+	if err := createRecord(c, data.DB(), item); err != nil {
+		existing, getErr := getRecordByKeyHash(c, data.DB(), item.KeyHash)
+		if getErr != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	return nil, nil
+
+}
+
+// claimLease gets how long a claim on an idempotency key lasts while the
+// original request is handled. A request cannot take longer than the server
+// write timeout, so twice the timeout leaves room for storing the response.
+func claimLease() time.Duration {
+
+	if lease := 2 * server.WriteTimeout(); lease > 0 {
+		return lease
+	}
+
+	return defaultClaimLease
+
+}
+
+// respondClaimed responds to a request whose idempotency key was claimed by an
+// earlier request.
+func respondClaimed(c *gin.Context, item, claimed *record) {
+
+	if claimed.RequestHash != item.RequestHash {
+		c.JSON(http.StatusUnprocessableEntity, httperror.ErrorResponse{
+			ErrorMessage: keyMismatch,
+		})
+		return
+	}
+
+	if !claimed.Completed {
+		c.JSON(http.StatusConflict, httperror.ErrorResponse{
+			ErrorMessage: keyInProgress,
+		})
+		return
+	}
+
+	// replay the stored response
+	var headers http.Header
+	if err := json.Unmarshal([]byte(claimed.Headers), &headers); err != nil {
+		logrus.WithContext(c).Error(err)
+	}
+
+	// stored headers replace any set by outer middleware so that no header is
+	// sent twice
+	for name, values := range headers {
+		c.Writer.Header()[name] = values
+	}
+	c.Header(replayedHeader, "true")
+
+	c.Status(claimed.StatusCode)
+	if _, err := c.Writer.Write(claimed.Body); err != nil {
+		logrus.WithContext(c).Error(err)
+	}
+
+}
+
+// requesterOf identifies the client that sent a request, either the
+// authenticated user or the client IP address. Returns false if the request is
+// unauthenticated and the client address is unknown.
+func requesterOf(c *gin.Context) (string, bool) {
+
+	if userID, ok := identity.RequestUserID(c); ok {
+		return fmt.Sprintf("user:%d", userID), true
+	}
+
+	if ip := c.ClientIP(); ip != "" {
+		return "ip:" + ip, true
+	}
+
+	return "", false
+
+}
+
+// hashKey combines the idempotency key with the requester and route so that
+// keys from different clients or for different endpoints never collide.
+func hashKey(c *gin.Context, requester, key string) string {
+	return hashBytes([]byte(fmt.Sprintf("%s\n%s\n%s", key, requester,
+		c.FullPath())))
+}
+
+// equalValues checks whether two lists of header values are the same.
+func equalValues(a, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+
+}
+
+// hashBytes returns the hex encoded SHA-256 hash of the supplied data.
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// responseWriter is used to wrap the response writer used by the idempotency
+// middleware to simultaneously record and write the response so the response
+// can be replayed.
+type responseWriter struct {
+	gin.ResponseWriter
+	responseData bytes.Buffer
+}
+
+// Write records response data before writing the response.
+func (r *responseWriter) Write(body []byte) (int, error) {
+
+	// record the response data
+	if n, err := r.responseData.Write(body); err != nil {
+		return n, err
+	}
+
+	// write the response
+	return r.ResponseWriter.Write(body)
+
+}
+
+// WriteString records response data before writing the response.
+func (r *responseWriter) WriteString(s string) (int, error) {
+	return r.Write([]byte(s))
+}
@@ -0,0 +1,31 @@
+package idempotency
+
+import (
+	"time"
+
+	"web-app/data"
+)
+
+// init migrates the database model.
+func init() {
+	data.DB().AutoMigrate(
+		record{},
+	)
+}
+
+/* Data Types */
+
+// record stores the response to a request made with an idempotency key.
+type record struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	KeyHash     string    `gorm:"size:64;uniqueIndex" json:"key_hash"` // hash of the idempotency key, requester, and route
+	RequestHash string    `gorm:"size:64" json:"request_hash"`         // hash of the request body
+	Completed   bool      `json:"completed"`                           // whether the response has been stored
+	StatusCode  int       `json:"status_code"`
+	Headers     string    `gorm:"type:text" json:"headers"` // JSON encoded response headers
+	Body        []byte    `json:"body"`
+	ExpiresAt   time.Time `gorm:"index" json:"expires_at"` // records when the response may no longer be replayed
+}
@@ -0,0 +1,48 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// getRecordByKeyHash retrieves an idempotency record by key hash.
+func getRecordByKeyHash(ctx context.Context, db *gorm.DB,
+	keyHash string) (*record, error) {
+
+	var item record
+
+	if err := db.WithContext(ctx).Model(&record{}).
+		Where("key_hash = ?", keyHash).
+		First(&item).Error; err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+
+}
+
+// createRecord inserts the supplied idempotency record. Fails if a record with
+// the same key hash already exists.
+func createRecord(ctx context.Context, db *gorm.DB, item *record) error {
+	return db.WithContext(ctx).Create(item).Error
+}
+
+// saveRecord inserts or updates the supplied idempotency record.
+func saveRecord(ctx context.Context, db *gorm.DB, item *record) error {
+	return db.WithContext(ctx).Save(item).Error
+}
+
+// deleteRecord deletes the supplied idempotency record.
+func deleteRecord(ctx context.Context, db *gorm.DB, item *record) error {
+	return db.WithContext(ctx).Delete(item).Error
+}
+
+// DeleteExpiredRecords deletes all idempotency records that may no longer be
+// replayed.
+func DeleteExpiredRecords(ctx context.Context, db *gorm.DB) error {
+	return db.WithContext(ctx).
+		Where("expires_at < ?", time.Now()).
+		Delete(&record{}).Error
+}
@@ -0,0 +1,24 @@
+// Package identity shares the identity of the user making a request between
+// the authentication middleware and the packages that depend on it without
+// importing the server or user packages.
+package identity
+
+import "github.com/gin-gonic/gin"
+
+// UserIDKey is the gin context key under which authentication middleware stores
+// the id of the user making the request.
+const UserIDKey = "web-app/identity.userID"
+
+// RequestUserID retrieves the id of the user making the supplied request.
+// Returns false if the request has not been authenticated.
+func RequestUserID(c *gin.Context) (uint, bool) {
+
+	if item, ok := c.Get(UserIDKey); ok {
+		if userID, ok := item.(uint); ok {
+			return userID, true
+		}
+	}
+
+	return 0, false
+
+}
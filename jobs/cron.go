@@ -0,0 +1,208 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleDescriptors maps predefined schedules to the equivalent cron
+// expression.
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Schedule determines when a job runs. Schedules are parsed from standard five
+// field cron expressions.
+type Schedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+
+	// when both day fields are restricted a day matches if either field
+	// matches, otherwise both fields must match
+	dayOfMonthAny bool
+	dayOfWeekAny  bool
+}
+
+// scheduleField describes the range of values allowed in a cron field.
+type scheduleField struct {
+	name     string
+	min, max int
+}
+
+// scheduleFields describes each of the fields of a cron expression in order.
+var scheduleFields = []scheduleField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// ParseSchedule parses a cron expression with the fields minute, hour, day of
+// month, month, and day of week. Each field may be a wildcard, a value, a
+// range, or a comma separated list of these, optionally with a step, e.g.
+// "*/15 9-17 * * 1-5". Sunday may be written as 0 or 7. The descriptors
+// @yearly, @monthly, @weekly, @daily, and @hourly are also accepted.
+func ParseSchedule(spec string) (*Schedule, error) {
+
+	spec = strings.TrimSpace(spec)
+	if expression, ok := scheduleDescriptors[spec]; ok {
+		spec = expression
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(scheduleFields) {
+		return nil, fmt.Errorf("invalid schedule '%s': expected %d fields",
+			spec, len(scheduleFields))
+	}
+
+	values := make([]uint64, len(fields))
+
+	for i, field := range fields {
+		bits, err := parseScheduleField(field, scheduleFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %v", spec, err)
+		}
+		values[i] = bits
+	}
+
+	// Sunday may be written as 0 or 7
+	if values[4]&(1<<7) != 0 {
+		values[4] |= 1
+	}
+
+	return &Schedule{
+		minute:        values[0],
+		hour:          values[1],
+		dayOfMonth:    values[2],
+		month:         values[3],
+		dayOfWeek:     values[4],
+		dayOfMonthAny: fields[2] == "*",
+		dayOfWeekAny:  fields[4] == "*",
+	}, nil
+
+}
+
+// Next gets the first time after the supplied time that matches the schedule.
+// Returns the zero time if the schedule never matches.
+func (s *Schedule) Next(after time.Time) time.Time {
+
+	t := after.Truncate(time.Minute).Add(time.Minute)
+
+	// no schedule repeats less often than every four years so if we have not
+	// found a match by then the schedule can never match, e.g. February 30th
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0,
+				t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0,
+				t.Location())
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+
+	}
+
+	return time.Time{}
+
+}
+
+// matchDay checks whether the day of the supplied time matches the schedule.
+func (s *Schedule) matchDay(t time.Time) bool {
+
+	dayOfMonth := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if s.dayOfMonthAny || s.dayOfWeekAny {
+		return dayOfMonth && dayOfWeek
+	}
+
+	return dayOfMonth || dayOfWeek
+
+}
+
+// parseScheduleField parses a single field of a cron expression returning a
+// bit set of the values the field matches.
+func parseScheduleField(field string, spec scheduleField) (uint64, error) {
+
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+
+		// separate any step from the range
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %s field '%s'",
+					spec.name, part)
+			}
+			part = part[:i]
+		}
+
+		// determine the range of values
+		start, end := spec.min, spec.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s field '%s'", spec.name, part)
+			}
+
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid %s field '%s'", spec.name,
+						part)
+				}
+			} else if step > 1 {
+				// a single value with a step repeats until the maximum
+				end = spec.max
+			}
+		}
+
+		if start < spec.min || end > spec.max || start > end {
+			return 0, fmt.Errorf("%s field '%s' out of range %d-%d", spec.name,
+				part, spec.min, spec.max)
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+
+	}
+
+	return bits, nil
+
+}
@@ -0,0 +1,20 @@
+// Package jobs runs scheduled background tasks. Packages register jobs with a
+// cron expression and the scheduler runs each job when it is due. When the
+// application is run with more than one server instance each scheduled run is
+// performed by only one instance, coordinated through a lease stored in the
+// database. Failed runs are retried with exponential backoff and every attempt
+// is recorded in the job run history.
+//
+// Environment:
+//     WEB_APP_DISABLE_JOBS
+//         bool - a flag that indicates whether this server instance should not
+//                run scheduled jobs.
+//                Default: false
+//     WEB_APP_JOBS_RETRY_BACKOFF
+//         int - the number of seconds to wait before retrying a failed job, the
+//               wait doubles with each subsequent retry.
+//               Default: 30
+//     WEB_APP_JOBS_HISTORY_DAYS
+//         int - the number of days job run history is kept.
+//               Default: 30
+package jobs
@@ -0,0 +1,298 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"web-app/data"
+	"web-app/env"
+
+	"github.com/sirupsen/logrus"
+	"github.com/twinj/uuid"
+)
+
+// init configures the job scheduler and registers job housekeeping.
+func init() {
+
+	disabled = env.GetBoolSafe(disableJobsVariable, false)
+	retryBackoff = time.Duration(
+		env.GetIntSafe(retryBackoffVariable, 30)) * time.Second
+	historyDays = env.GetIntSafe(historyDaysVariable, 30)
+
+	// remove old job run history
+	if err := Register(&Job{
+		Name:     "job_history_retention",
+		Schedule: "20 3 * * *",
+		Run: func(ctx context.Context) error {
+			return deleteJobRunBefore(ctx, data.DB(),
+				time.Now().AddDate(0, 0, -historyDays))
+		},
+	}); err != nil {
+		logrus.Fatal(err)
+	}
+
+}
+
+const (
+	// disableJobsVariable defines the environment variable that when set to
+	// true prevents this server instance from running scheduled jobs.
+	disableJobsVariable = "WEB_APP_DISABLE_JOBS"
+	// retryBackoffVariable defines the environment variable for the number of
+	// seconds to wait before retrying a failed job.
+	retryBackoffVariable = "WEB_APP_JOBS_RETRY_BACKOFF"
+	// historyDaysVariable defines the environment variable for the number of
+	// days job run history is kept.
+	historyDaysVariable = "WEB_APP_JOBS_HISTORY_DAYS"
+	// leaseDuration is how long a job lease is held without being renewed.
+	// Leases are renewed while a job is running so a lease only expires if the
+	// server instance running the job stops.
+	leaseDuration = time.Minute
+	// defaultMaxAttempts is the number of times a job is attempted if the job
+	// does not specify a maximum.
+	defaultMaxAttempts = 3
+)
+
+// disabled determines whether this server instance runs scheduled jobs.
+var disabled bool
+
+// retryBackoff determines how long to wait before the first retry of a failed
+// job. The wait doubles with each subsequent retry.
+var retryBackoff time.Duration
+
+// historyDays determines the number of days job run history is kept.
+var historyDays int
+
+// instanceID uniquely identifies this server instance when holding job leases.
+var instanceID = uuid.NewV4().String()
+
+// registry stores the registered jobs by name.
+var registry = struct {
+	mutex *sync.RWMutex
+	jobs  map[string]*Job
+}{
+	mutex: &sync.RWMutex{},
+	jobs:  map[string]*Job{},
+}
+
+// Job is a task that runs on a schedule. Only one server instance runs each
+// scheduled run of a job.
+type Job struct {
+	Name        string                          // uniquely identifies the job
+	Schedule    string                          // cron expression that determines when the job runs
+	Run         func(ctx context.Context) error // performs the work of the job
+	MaxAttempts int                             // number of times the job is attempted if it fails, defaults to 3
+	Timeout     time.Duration                   // maximum duration of each attempt, no limit if zero
+
+	schedule *Schedule
+}
+
+// Register adds a job to the scheduler. Jobs must be registered before the
+// scheduler is started. Returns an error if the job schedule is invalid or a
+// job with the same name has already been registered.
+func Register(job *Job) error {
+
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return err
+	}
+	job.schedule = schedule
+
+	if job.MaxAttempts < 1 {
+		job.MaxAttempts = defaultMaxAttempts
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if _, ok := registry.jobs[job.Name]; ok {
+		return fmt.Errorf("job '%s' already registered", job.Name)
+	}
+
+	registry.jobs[job.Name] = job
+
+	return nil
+
+}
+
+// Jobs gets all registered jobs ordered by name.
+func Jobs() []*Job {
+
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	jobs := make([]*Job, 0, len(registry.jobs))
+	for _, job := range registry.jobs {
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Name < jobs[j].Name
+	})
+
+	return jobs
+
+}
+
+// Next gets the next time the job is scheduled to run after the supplied time.
+func (j *Job) Next(after time.Time) time.Time {
+	return j.schedule.Next(after)
+}
+
+// Start starts running registered jobs on their schedules. This should be
+// called once after all jobs have been registered.
+func Start() {
+
+	if disabled {
+		logrus.Info("scheduled jobs are disabled on this server instance")
+		return
+	}
+
+	for _, job := range Jobs() {
+		go schedule(job)
+	}
+
+}
+
+// schedule runs the supplied job each time it is scheduled.
+func schedule(job *Job) {
+	for {
+
+		next := job.Next(time.Now())
+		if next.IsZero() {
+			logrus.Errorf("job '%s' will never run", job.Name)
+			return
+		}
+
+		time.Sleep(time.Until(next))
+
+		run(job, next)
+
+	}
+}
+
+// run performs the scheduled run of the supplied job if no other server
+// instance has already done so. Failed attempts are retried with exponential
+// backoff until the job succeeds or reaches the maximum number of attempts.
+func run(job *Job, scheduledAt time.Time) {
+
+	ctx := context.Background()
+
+	acquired, err := acquireJobLease(ctx, data.DB(), job.Name, instanceID,
+		scheduledAt, time.Now().Add(leaseDuration))
+	if err != nil {
+		logrus.Error(err)
+		return
+	} else if !acquired {
+		logrus.Debugf("job '%s' is being run by another server instance",
+			job.Name)
+		return
+	}
+
+	// keep the lease while the job is running
+	done := make(chan struct{})
+	defer close(done)
+	go renewLease(job.Name, done)
+
+	defer func() {
+		if err := releaseJobLease(ctx, data.DB(), job.Name,
+			instanceID); err != nil {
+			logrus.Error(err)
+		}
+	}()
+
+	backoff := retryBackoff
+
+	for attempt := 1; attempt <= job.MaxAttempts; attempt++ {
+
+		if err := attemptJob(ctx, job, scheduledAt, attempt); err == nil {
+			return
+		} else if attempt < job.MaxAttempts {
+			logrus.Warnf("job '%s' attempt %d failed, retrying in %v: %v",
+				job.Name, attempt, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		} else {
+			logrus.Errorf("job '%s' failed after %d attempts: %v", job.Name,
+				attempt, err)
+		}
+
+	}
+
+}
+
+// attemptJob runs the supplied job once and records the outcome in the job run
+// history.
+func attemptJob(ctx context.Context, job *Job, scheduledAt time.Time,
+	attempt int) (err error) {
+
+	item := &JobRun{
+		Name:        job.Name,
+		Owner:       instanceID,
+		ScheduledAt: scheduledAt,
+		Attempt:     attempt,
+		StartedAt:   time.Now(),
+	}
+
+	if err := saveJobRun(ctx, data.DB(), item); err != nil {
+		logrus.WithContext(ctx).Error(err)
+	}
+
+	defer func() {
+
+		// treat a panic as a failed attempt so it can be retried
+		if r := recover(); r != nil {
+			logrus.WithContext(ctx).WithField("stack", string(debug.Stack())).
+				Errorf("job '%s' panic: %v", job.Name, r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+
+		finishedAt := time.Now()
+		item.FinishedAt = &finishedAt
+		item.Succeeded = err == nil
+		if err != nil {
+			item.Error = err.Error()
+		}
+
+		if err := saveJobRun(ctx, data.DB(), item); err != nil {
+			logrus.WithContext(ctx).Error(err)
+		}
+
+	}()
+
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+
+	logrus.WithContext(ctx).Debugf("running job '%s' attempt %d",
+		job.Name, attempt)
+
+	return job.Run(ctx)
+
+}
+
+// renewLease periodically extends the lease on the specified job until the
+// done channel is closed.
+func renewLease(name string, done <-chan struct{}) {
+
+	ticker := time.NewTicker(leaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := renewJobLease(context.Background(), data.DB(), name,
+				instanceID, time.Now().Add(leaseDuration)); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}
+
+}
@@ -0,0 +1,43 @@
+package jobs
+
+import (
+	"time"
+
+	"web-app/data"
+)
+
+// init migrates the database model.
+func init() {
+	data.DB().AutoMigrate(
+		jobLease{},
+		JobRun{},
+	)
+}
+
+/* Data Types */
+
+// jobLease records which server instance holds the right to run a job so that
+// each scheduled run is only performed once across all server instances.
+type jobLease struct {
+	Name      string    `gorm:"primarykey;size:100" json:"name"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Owner       string    `gorm:"size:36" json:"owner"` // id of the server instance holding the lease
+	ScheduledAt time.Time `json:"scheduled_at"`         // the scheduled time of the most recent run
+	ExpiresAt   time.Time `json:"expires_at"`           // the lease may be taken by another instance after this time
+}
+
+// JobRun records the outcome of an attempt to run a job.
+type JobRun struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	Name        string     `gorm:"index;size:100" json:"name"`
+	Owner       string     `gorm:"size:36" json:"owner"`
+	ScheduledAt time.Time  `json:"scheduled_at"`
+	Attempt     int        `json:"attempt"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	Succeeded   bool       `json:"succeeded"`
+	Error       string     `gorm:"type:text" json:"error"`
+}
@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// acquireJobLease attempts to take the lease for the specified job and
+// scheduled time. The lease is only taken if it has expired and the job has not
+// already been run for the scheduled time. Returns whether the lease was taken.
+func acquireJobLease(ctx context.Context, db *gorm.DB, name, owner string,
+	scheduledAt, expiresAt time.Time) (bool, error) {
+
+	// make sure the lease record exists so it can be taken with an update, the
+	// record starts at the unix epoch rather than the zero time which strict
+	// MySQL modes reject
+	if err := db.WithContext(ctx).Clauses(clause.OnConflict{
+		DoNothing: true,
+	}).Create(&jobLease{
+		Name:        name,
+		ScheduledAt: time.Unix(0, 0),
+		ExpiresAt:   time.Unix(0, 0),
+	}).Error; err != nil {
+		return false, err
+	}
+
+	result := db.WithContext(ctx).Model(&jobLease{}).
+		Where("name = ?", name).
+		Where("expires_at < ?", time.Now()).
+		Where("scheduled_at < ?", scheduledAt).
+		Updates(map[string]interface{}{
+			"owner":        owner,
+			"scheduled_at": scheduledAt,
+			"expires_at":   expiresAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+
+}
+
+// renewJobLease extends the lease held by the specified owner.
+func renewJobLease(ctx context.Context, db *gorm.DB, name, owner string,
+	expiresAt time.Time) error {
+	return db.WithContext(ctx).Model(&jobLease{}).
+		Where("name = ?", name).
+		Where("owner = ?", owner).
+		Update("expires_at", expiresAt).Error
+}
+
+// releaseJobLease releases the lease held by the specified owner. The
+// scheduled time of the run is kept so the run is not repeated.
+func releaseJobLease(ctx context.Context, db *gorm.DB, name,
+	owner string) error {
+	return db.WithContext(ctx).Model(&jobLease{}).
+		Where("name = ?", name).
+		Where("owner = ?", owner).
+		Update("expires_at", time.Now()).Error
+}
+
+// saveJobRun inserts or updates the supplied job run record.
+func saveJobRun(ctx context.Context, db *gorm.DB, item *JobRun) error {
+	return db.WithContext(ctx).Save(item).Error
+}
+
+// ListJobRunByName retrieves the most recent runs of the specified job, newest
+// first.
+func ListJobRunByName(ctx context.Context, db *gorm.DB, name string,
+	limit int) ([]*JobRun, error) {
+
+	var items []*JobRun
+
+	if err := db.WithContext(ctx).Model(&JobRun{}).
+		Where("name = ?", name).
+		Order("id DESC").
+		Limit(limit).
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	return items, nil
+
+}
+
+// deleteJobRunBefore deletes all job run records created before the supplied
+// time.
+func deleteJobRunBefore(ctx context.Context, db *gorm.DB,
+	before time.Time) error {
+	return db.WithContext(ctx).
+		Where("created_at < ?", before).
+		Delete(&JobRun{}).Error
+}
@@ -3,14 +3,29 @@
 //     WEB_APP_ENABLE_DEBUG_LOG
 //         bool - a flag that indicates whether the application should emit
 //                debug level logs.
+//
+// Flags:
+//     -maintenance
+//         string - changes the maintenance mode of all running servers to one
+//                  of off, on, or read-only and exits without starting the
+//                  server.
 package main
 
 import (
+	"flag"
+
+	"web-app/cache"
 	"web-app/env"
+	"web-app/events"
+	"web-app/jobs"
 	"web-app/server"
+	"web-app/tracing"
 
 	"github.com/sirupsen/logrus"
 
+	_ "web-app/admin"
+	_ "web-app/events/delivery"
+	_ "web-app/flags/delivery"
 	_ "web-app/health"
 	_ "web-app/user/delivery"
 )
@@ -21,14 +36,47 @@ const (
 	enableDebugLogVariable = "WEB_APP_ENABLE_DEBUG_LOG"
 )
 
+// maintenanceFlag is used to change the maintenance mode from the command line.
+var maintenanceFlag = flag.String("maintenance", "",
+	"change the maintenance mode (off, on, read-only) and exit")
+
 // main stands up the application server.
 func main() {
 
+	flag.Parse()
+
 	if env.GetBoolSafe(enableDebugLogVariable, false) {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
+	// check if we should change the maintenance mode instead of running the
+	// server
+	if *maintenanceFlag != "" {
+		if err := server.SetMaintenanceMode(
+			server.MaintenanceMode(*maintenanceFlag)); err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("maintenance mode set to %s", *maintenanceFlag)
+		return
+	}
+
+	// start delivering events to connected users
+	if err := events.Start(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// start applying cache invalidations made by other server instances
+	if err := cache.Start(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// start running scheduled background jobs
+	jobs.Start()
+
 	// run the API server
 	server.Run()
 
+	// export any spans recorded before the server stopped
+	tracing.Flush()
+
 }
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// compressionMinSizeVariable defines the environment variable for the
+	// minimum size of a response body before it is compressed.
+	compressionMinSizeVariable = "WEB_APP_COMPRESSION_MIN_SIZE"
+	// compressionTypesVariable defines the environment variable for the list
+	// of content types that may be compressed.
+	compressionTypesVariable = "WEB_APP_COMPRESSION_TYPES"
+	// encodingBrotli is the content encoding for brotli compressed responses.
+	encodingBrotli = "br"
+	// encodingGzip is the content encoding for gzip compressed responses.
+	encodingGzip = "gzip"
+	// brotliLevel is the brotli compression level, chosen to balance
+	// compression ratio with the cost of compressing dynamic responses.
+	brotliLevel = 5
+)
+
+// compressionMinSize determines the minimum size of a response body in bytes
+// before it is compressed.
+var compressionMinSize int
+
+// compressionTypes stores the set of content types that may be compressed.
+var compressionTypes = map[string]struct{}{}
+
+// gzipWriterPool is used to reuse gzip writers between responses.
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(ioutil.Discard)
+	},
+}
+
+// brotliWriterPool is used to reuse brotli writers between responses.
+var brotliWriterPool = sync.Pool{
+	New: func() interface{} {
+		return brotli.NewWriterLevel(ioutil.Discard, brotliLevel)
+	},
+}
+
+// CompressionMiddleware gets middleware that compresses response bodies using
+// brotli or gzip depending on the encodings accepted by the client. Responses
+// are only compressed if the content type is in the configured allowlist and
+// the body is at least the configured minimum size.
+//
+// Middleware bound to individual routes, such as cache.Middleware,
+// runs inside this middleware and therefore only ever handles uncompressed
+// response bodies. Cached responses are compressed according to the encodings
+// accepted by each client as they are replayed.
+func CompressionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		// responses to HEAD requests do not have a body
+		if c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		writer := &compressWriter{
+			ResponseWriter: c.Writer,
+			encoding:       negotiateEncoding(c.GetHeader("Accept-Encoding")),
+		}
+		c.Writer = writer
+
+		defer func() {
+
+			// restore the original response writer so any middleware that
+			// handles a panic can write a response without any partially
+			// buffered response body
+			c.Writer = writer.ResponseWriter
+
+			if r := recover(); r != nil {
+				writer.release()
+				panic(r)
+			}
+
+			writer.close()
+
+		}()
+
+		c.Next()
+
+	}
+}
+
+// compressWriter wraps the response writer to buffer the start of the response
+// body until we can determine whether the response should be compressed.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	buffer   bytes.Buffer
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+// Write buffers or compresses response data before writing the response.
+func (w *compressWriter) Write(data []byte) (int, error) {
+
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+
+	n, err := w.buffer.Write(data)
+	if err != nil {
+		return n, err
+	}
+
+	// once the buffered data reaches the minimum size we can decide whether
+	// to compress the response
+	if w.buffer.Len() >= compressionMinSize {
+		if err := w.decide(); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+
+}
+
+// WriteString buffers or compresses response data before writing the
+// response.
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Written checks whether any part of the response has been written.
+func (w *compressWriter) Written() bool {
+	return w.buffer.Len() > 0 || w.ResponseWriter.Written()
+}
+
+// Flush writes any buffered data to the client.
+func (w *compressWriter) Flush() {
+
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			return
+		}
+	}
+
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+
+	w.ResponseWriter.Flush()
+
+}
+
+// decide determines whether the response should be compressed, sets the
+// response headers accordingly, and writes any buffered data.
+func (w *compressWriter) decide() error {
+
+	w.decided = true
+
+	header := w.Header()
+
+	contentType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	_, compressible := compressionTypes[contentType]
+
+	// the response varies by accepted encoding whenever its type may be
+	// compressed, regardless of whether this particular response is
+	if compressible {
+		addVary(header, "Accept-Encoding")
+	}
+
+	status := w.Status()
+
+	if compressible && w.encoding != "" &&
+		header.Get("Content-Encoding") == "" &&
+		w.buffer.Len() >= compressionMinSize &&
+		status >= http.StatusOK &&
+		status != http.StatusNoContent &&
+		status != http.StatusNotModified {
+
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+
+		// a strong ETag identifies the uncompressed representation so it is
+		// weakened when the response is compressed
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		switch w.encoding {
+		case encodingBrotli:
+			encoder := brotliWriterPool.Get().(*brotli.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		case encodingGzip:
+			encoder := gzipWriterPool.Get().(*gzip.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		}
+
+	}
+
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buffer.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buffer.Bytes())
+	}
+
+	w.buffer.Reset()
+
+	return err
+
+}
+
+// close writes any buffered data and completes the compressed response.
+func (w *compressWriter) close() {
+
+	if !w.decided {
+		w.decide()
+	}
+
+	if w.encoder != nil {
+		w.encoder.Close()
+		w.release()
+	}
+
+}
+
+// release returns the encoder to the appropriate pool.
+func (w *compressWriter) release() {
+
+	switch encoder := w.encoder.(type) {
+	case *brotli.Writer:
+		encoder.Reset(ioutil.Discard)
+		brotliWriterPool.Put(encoder)
+	case *gzip.Writer:
+		encoder.Reset(ioutil.Discard)
+		gzipWriterPool.Put(encoder)
+	}
+
+	w.encoder = nil
+
+}
+
+// negotiateEncoding selects the preferred encoding accepted by the client.
+// Brotli is preferred over gzip when both are accepted with the same quality.
+// Returns an empty string if the response should not be compressed.
+func negotiateEncoding(acceptEncoding string) string {
+
+	qualities := map[string]float64{}
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		qualities[coding] = quality
+
+	}
+
+	best, bestQuality := "", 0.0
+
+	for _, coding := range []string{encodingBrotli, encodingGzip} {
+
+		quality, ok := qualities[coding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+
+		if ok && quality > bestQuality {
+			best, bestQuality = coding, quality
+		}
+
+	}
+
+	return best
+
+}
+
+// addVary adds the supplied header name to the Vary response header if it is
+// not already present.
+func addVary(header http.Header, name string) {
+
+	for _, value := range header["Vary"] {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), name) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", name)
+
+}
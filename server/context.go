@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/twinj/uuid"
+)
+
+const (
+	// requestIDHeader is the header used to supply and report the id of a
+	// request.
+	requestIDHeader = "X-Request-ID"
+	// requestIDKey is the gin context key used to store the id of a request.
+	requestIDKey = "web-app/server.requestID"
+)
+
+// connContextKey is the request context key used to store the connection a
+// request was received on.
+type connContextKey struct{}
+
+// requestIDPattern restricts the request ids we accept from clients so that
+// they are safe to write to logs.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9\-_.]{1,64}$`)
+
+// RequestIDMiddleware gets middleware that assigns an id to every request. If
+// the client supplies a valid X-Request-ID header the supplied id is used,
+// otherwise a new id is generated. The id is included in the response headers.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		requestID := c.GetHeader(requestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.NewV4().String()
+		}
+
+		c.Set(requestIDKey, requestID)
+		c.Header(requestIDHeader, requestID)
+
+		c.Next()
+
+	}
+}
+
+// RequestID retrieves the id assigned to the supplied request.
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// SetWriteDeadline changes the deadline for writing the response to the
+// supplied request. The server write timeout applies to every response so this
+// must be used by handlers that stream long lived responses, such as server
+// sent events, to keep the connection open.
+func SetWriteDeadline(c *gin.Context, deadline time.Time) error {
+
+	conn, ok := c.Request.Context().Value(connContextKey{}).(net.Conn)
+	if !ok {
+		return errors.New("request connection is not available")
+	}
+
+	return conn.SetWriteDeadline(deadline)
+
+}
+
+// withConn stores the supplied connection in the context of every request
+// received on the connection.
+func withConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
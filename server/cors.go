@@ -0,0 +1,483 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"web-app/data"
+	"web-app/httperror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// corsAllowlistVariable defines the environment variable that when set to
+	// true allows cross-domain requests from origins stored in the database.
+	corsAllowlistVariable = "WEB_APP_CORS_ALLOWLIST"
+	// corsPollIntervalVariable defines the environment variable for the number
+	// of seconds between reloads of the origin allowlist.
+	corsPollIntervalVariable = "WEB_APP_CORS_POLL_INTERVAL"
+	// corsOriginForbidden is the error message returned when a cross-domain
+	// request is made from an origin that is not allowed.
+	corsOriginForbidden = "origin not allowed"
+)
+
+// ErrInvalidOrigin is returned when an origin or origin pattern is not valid.
+var ErrInvalidOrigin = errors.New("invalid origin")
+
+// ErrOriginExists is returned when adding an origin that is already in the
+// allowlist.
+var ErrOriginExists = errors.New("origin already exists")
+
+// CORSPolicy determines which cross-domain requests are allowed. Origins may be
+// an exact origin such as https://example.com, a pattern matching any
+// subdomain such as https://*.example.com, or * to allow any origin.
+type CORSPolicy struct {
+	AllowOrigins     []string // origins a cross-domain request can be executed from
+	AllowMethods     []string // HTTP methods a client may use in a cross-domain request
+	AllowHeaders     []string // headers a client may use in a cross-domain request
+	ExposeHeaders    []string // headers the server may expose to the client
+	AllowCredentials bool     // whether a cross-domain request may include user credentials
+	MaxAge           int      // number of seconds a preflight response may be cached
+	UseAllowlist     bool     // whether origins stored in the database are also allowed
+
+	origins []*originPattern
+}
+
+// CORSOrigin is an origin stored in the database that is allowed to execute
+// cross-domain requests.
+type CORSOrigin struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Origin string `gorm:"size:255;uniqueIndex" json:"origin"`
+}
+
+// corsPolicies stores the CORS policy applied to each path prefix.
+var corsPolicies = struct {
+	mutex    *sync.RWMutex
+	policies map[string]*CORSPolicy
+}{
+	mutex:    &sync.RWMutex{},
+	policies: map[string]*CORSPolicy{},
+}
+
+// corsAllowlist stores the origins loaded from the database.
+var corsAllowlist = struct {
+	mutex   *sync.RWMutex
+	origins []*originPattern
+}{
+	mutex: &sync.RWMutex{},
+}
+
+// corsPollInterval determines how often the origin allowlist is reloaded so
+// that changes made by other server instances are applied.
+var corsPollInterval time.Duration
+
+// SetCORSPolicy applies the supplied CORS policy to requests with the specified
+// path prefix. The policy with the longest matching prefix is applied, a policy
+// set for the empty prefix applies to all requests. Returns an error if the
+// policy is not valid.
+func SetCORSPolicy(pathPrefix string, policy *CORSPolicy) error {
+
+	if err := policy.compile(); err != nil {
+		return err
+	}
+
+	corsPolicies.mutex.Lock()
+	defer corsPolicies.mutex.Unlock()
+
+	corsPolicies.policies[strings.TrimSuffix(pathPrefix, "/")] = policy
+
+	return nil
+
+}
+
+// ListCORSOrigins retrieves all origins in the allowlist.
+func ListCORSOrigins() ([]*CORSOrigin, error) {
+
+	var items []*CORSOrigin
+
+	if err := data.DB().Order("origin").Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	return items, nil
+
+}
+
+// AddCORSOrigin adds an origin or origin pattern to the allowlist. Returns
+// ErrInvalidOrigin if the origin is not valid or ErrOriginExists if it is
+// already in the allowlist. The allowlist may not be used to allow any origin.
+func AddCORSOrigin(origin string) (*CORSOrigin, error) {
+
+	pattern, err := parseOriginPattern(origin)
+	if err != nil {
+		return nil, err
+	} else if pattern.any {
+		return nil, fmt.Errorf("%w: wildcard origin is not allowed in the allowlist",
+			ErrInvalidOrigin)
+	}
+
+	item := &CORSOrigin{Origin: pattern.String()}
+
+	var count int64
+	if err := data.DB().Model(&CORSOrigin{}).
+		Where("origin = ?", item.Origin).
+		Count(&count).Error; err != nil {
+		return nil, err
+	} else if count > 0 {
+		return nil, ErrOriginExists
+	}
+
+	if err := data.DB().Create(item).Error; err != nil {
+		return nil, err
+	}
+
+	if err := loadCORSOrigins(); err != nil {
+		logrus.Error(err)
+	}
+
+	return item, nil
+
+}
+
+// DeleteCORSOrigin removes the specified origin from the allowlist.
+func DeleteCORSOrigin(id uint) error {
+
+	if err := data.DB().Delete(&CORSOrigin{}, id).Error; err != nil {
+		return err
+	}
+
+	if err := loadCORSOrigins(); err != nil {
+		logrus.Error(err)
+	}
+
+	return nil
+
+}
+
+// CORSMiddleware gets middleware that applies the CORS policy matching the
+// request path. Requests from origins that are not allowed receive a 403 -
+// Forbidden response and preflight requests are answered directly.
+func CORSMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+
+		// the response depends on the origin unless any origin is allowed
+		addVary(header, "Origin")
+
+		if isSameOrigin(c.Request, origin) {
+			c.Next()
+			return
+		}
+
+		policy := corsPolicyFor(c.Request.URL.Path)
+
+		if policy == nil || !policy.allows(origin) {
+			c.JSON(http.StatusForbidden, httperror.ErrorResponse{
+				ErrorMessage: corsOriginForbidden,
+			})
+			c.Abort()
+			return
+		}
+
+		if policy.allowsAnyOrigin() && !policy.AllowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if policy.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// answer preflight requests without invoking the handler
+		if c.Request.Method == http.MethodOptions &&
+			c.GetHeader("Access-Control-Request-Method") != "" {
+
+			addVary(header, "Access-Control-Request-Method")
+			addVary(header, "Access-Control-Request-Headers")
+
+			if len(policy.AllowMethods) > 0 {
+				header.Set("Access-Control-Allow-Methods",
+					strings.Join(policy.AllowMethods, ","))
+			}
+			if len(policy.AllowHeaders) > 0 {
+				header.Set("Access-Control-Allow-Headers",
+					strings.Join(policy.AllowHeaders, ","))
+			}
+			if policy.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age",
+					strconv.Itoa(policy.MaxAge))
+			}
+
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+
+		}
+
+		if len(policy.ExposeHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers",
+				strings.Join(policy.ExposeHeaders, ","))
+		}
+
+		c.Next()
+
+	}
+}
+
+// compile parses the origins of the policy and checks that the policy is
+// valid. A policy may not allow any origin when credentials are allowed.
+func (p *CORSPolicy) compile() error {
+
+	origins := make([]*originPattern, 0, len(p.AllowOrigins))
+
+	for _, origin := range p.AllowOrigins {
+
+		pattern, err := parseOriginPattern(origin)
+		if err != nil {
+			return err
+		}
+
+		if pattern.any && p.AllowCredentials {
+			return fmt.Errorf("%w: wildcard origin is not allowed when credentials are allowed",
+				ErrInvalidOrigin)
+		}
+
+		origins = append(origins, pattern)
+
+	}
+
+	p.origins = origins
+
+	return nil
+
+}
+
+// allows checks whether the policy allows cross-domain requests from the
+// supplied origin.
+func (p *CORSPolicy) allows(origin string) bool {
+
+	for _, pattern := range p.origins {
+		if pattern.matches(origin) {
+			return true
+		}
+	}
+
+	if !p.UseAllowlist {
+		return false
+	}
+
+	corsAllowlist.mutex.RLock()
+	defer corsAllowlist.mutex.RUnlock()
+
+	for _, pattern := range corsAllowlist.origins {
+		if pattern.matches(origin) {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// allowsAnyOrigin checks whether the policy allows cross-domain requests from
+// any origin.
+func (p *CORSPolicy) allowsAnyOrigin() bool {
+	for _, pattern := range p.origins {
+		if pattern.any {
+			return true
+		}
+	}
+	return false
+}
+
+// corsPolicyFor retrieves the CORS policy with the longest prefix matching the
+// supplied request path. Returns nil if no policy applies.
+func corsPolicyFor(path string) *CORSPolicy {
+
+	corsPolicies.mutex.RLock()
+	defer corsPolicies.mutex.RUnlock()
+
+	var policy *CORSPolicy
+	longest := -1
+
+	for prefix, p := range corsPolicies.policies {
+		if len(prefix) <= longest {
+			continue
+		}
+		if prefix == "" || path == prefix ||
+			strings.HasPrefix(path, prefix+"/") {
+			policy = p
+			longest = len(prefix)
+		}
+	}
+
+	return policy
+
+}
+
+// isSameOrigin checks whether the supplied origin is the origin of the request
+// itself, in which case CORS does not apply. Behind a TLS terminating proxy or a
+// Unix socket the scheme used by the client is taken from the
+// X-Forwarded-Proto header.
+func isSameOrigin(req *http.Request, origin string) bool {
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	} else if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+		// proxies may append their own scheme, the first is the client's
+		scheme = strings.ToLower(strings.TrimSpace(
+			strings.Split(proto, ",")[0]))
+	}
+
+	return strings.EqualFold(origin, scheme+"://"+req.Host)
+
+}
+
+// loadCORSOrigins reads the origin allowlist from the database.
+func loadCORSOrigins() error {
+
+	items, err := ListCORSOrigins()
+	if err != nil {
+		return err
+	}
+
+	origins := make([]*originPattern, 0, len(items))
+	for _, item := range items {
+		pattern, err := parseOriginPattern(item.Origin)
+		if err != nil || pattern.any {
+			logrus.Warnf("ignoring invalid allowlist origin '%s'", item.Origin)
+			continue
+		}
+		origins = append(origins, pattern)
+	}
+
+	corsAllowlist.mutex.Lock()
+	defer corsAllowlist.mutex.Unlock()
+
+	corsAllowlist.origins = origins
+
+	return nil
+
+}
+
+// watchCORSOrigins periodically reloads the origin allowlist so that changes
+// made by other server instances are applied.
+func watchCORSOrigins(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := loadCORSOrigins(); err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+// originOf gets the origin of the supplied URL.
+func originOf(rawURL string) (string, error) {
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	} else if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidOrigin, rawURL)
+	}
+
+	return u.Scheme + "://" + u.Host, nil
+
+}
+
+// originPattern matches the origin of a cross-domain request.
+type originPattern struct {
+	any       bool   // matches any origin
+	scheme    string // scheme the origin must use
+	host      string // host and optional port of the origin
+	subdomain bool   // matches any subdomain of the host rather than the host
+}
+
+// parseOriginPattern parses an exact origin, a subdomain wildcard pattern, or
+// a wildcard matching any origin.
+func parseOriginPattern(origin string) (*originPattern, error) {
+
+	origin = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+
+	if origin == "*" {
+		return &originPattern{any: true}, nil
+	}
+
+	i := strings.Index(origin, "://")
+	if i <= 0 {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidOrigin, origin)
+	}
+
+	pattern := &originPattern{scheme: origin[:i], host: origin[i+3:]}
+
+	if strings.HasPrefix(pattern.host, "*.") {
+		pattern.subdomain = true
+		pattern.host = pattern.host[2:]
+	}
+
+	// a wildcard is only allowed as the leftmost label and must be followed by
+	// at least two labels so that a pattern cannot match every domain
+	if pattern.host == "" || strings.ContainsAny(pattern.host, "*/?#@ ") ||
+		(pattern.subdomain && !strings.Contains(
+			strings.SplitN(pattern.host, ":", 2)[0], ".")) {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidOrigin, origin)
+	}
+
+	return pattern, nil
+
+}
+
+// matches checks whether the supplied origin matches the pattern.
+func (p *originPattern) matches(origin string) bool {
+
+	if p.any {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+
+	prefix := p.scheme + "://"
+	if !strings.HasPrefix(origin, prefix) {
+		return false
+	}
+
+	host := origin[len(prefix):]
+
+	if p.subdomain {
+		return strings.HasSuffix(host, "."+p.host) &&
+			!strings.ContainsAny(host, "/?#@ ")
+	}
+
+	return host == p.host
+
+}
+
+// String formats the pattern as it would be configured.
+func (p *originPattern) String() string {
+
+	if p.any {
+		return "*"
+	} else if p.subdomain {
+		return p.scheme + "://*." + p.host
+	}
+
+	return p.scheme + "://" + p.host
+
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"web-app/httperror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// BodyLimitMiddleware gets middleware that rejects any request with a body
+// larger than the specified number of bytes. Requests that exceed the limit
+// receive a 413 - Request Entity Too Large response.
+func BodyLimitMiddleware(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		// skip requests that do not have a body
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		// reject the request early if the declared length exceeds the limit
+		if c.Request.ContentLength > limit {
+			abortRequestEntityTooLarge(c)
+			return
+		}
+
+		// read at most one byte beyond the limit to determine whether the
+		// request body is too large
+		body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+		if err != nil {
+			logrus.WithContext(c).Debug(err)
+			c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
+				ErrorMessage: "invalid request body",
+			})
+			c.Abort()
+			return
+		}
+
+		if int64(len(body)) > limit {
+			abortRequestEntityTooLarge(c)
+			return
+		}
+
+		// replace the request body with the buffered contents
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		c.Next()
+
+	}
+}
+
+// abortRequestEntityTooLarge responds to the request with a 413 - Request
+// Entity Too Large error and stops any further handlers from executing.
+func abortRequestEntityTooLarge(c *gin.Context) {
+
+	// the client may still be sending the request body so close the connection
+	// rather than attempting to drain it
+	c.Header("Connection", "close")
+
+	c.JSON(http.StatusRequestEntityTooLarge, httperror.ErrorResponse{
+		ErrorMessage: httperror.RequestEntityTooLarge,
+	})
+	c.Abort()
+
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"web-app/env"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// listenAddressVariable defines the environment variable for the address
+	// the server listens on.
+	listenAddressVariable = "WEB_APP_LISTEN_ADDRESS"
+	// socketModeVariable defines the environment variable for the file mode of
+	// Unix domain sockets created by the server.
+	socketModeVariable = "WEB_APP_SOCKET_MODE"
+	// unixAddressPrefix marks a listen address as the path of a Unix domain
+	// socket.
+	unixAddressPrefix = "unix:"
+	// listenFDsStart is the first file descriptor passed by systemd socket
+	// activation.
+	listenFDsStart = 3
+	// adminListenerName is the systemd file descriptor name of a socket used
+	// by the internal admin listener.
+	adminListenerName = "admin"
+	// redirectListenerName is the systemd file descriptor name of a socket
+	// used by the HTTP redirect listener.
+	redirectListenerName = "redirect"
+)
+
+// socketMode determines the permissions of Unix domain sockets created by the
+// server.
+var socketMode os.FileMode
+
+// inheritedListeners stores the listeners passed by systemd socket activation
+// by file descriptor name.
+var inheritedListeners map[string][]net.Listener
+
+// inheritListeners takes ownership of the sockets passed by systemd socket
+// activation. Sockets are grouped by the name set with FileDescriptorName in
+// the socket unit. Returns nil if the process was not socket activated.
+func inheritListeners() (map[string][]net.Listener, error) {
+
+	// the sockets are only meant for us if the pid matches
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %v", err)
+	}
+
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	// child processes must not take the sockets
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := map[string][]net.Listener{}
+
+	for i := 0; i < count; i++ {
+
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		// the listener holds a duplicate of the descriptor so the file is
+		// closed once it has been converted
+		file := os.NewFile(uintptr(listenFDsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket activation fd %d: %v",
+				listenFDsStart+i, err)
+		}
+
+		listeners[name] = append(listeners[name], listener)
+
+	}
+
+	return listeners, nil
+
+}
+
+// mainListeners gets the listeners the application router is served on. Any
+// socket passed by systemd that is not reserved for the admin or redirect
+// listener is used, otherwise the server listens on the configured address or
+// on the supplied port of all interfaces.
+func mainListeners(defaultPort int) ([]net.Listener, error) {
+
+	var listeners []net.Listener
+	for name, inherited := range inheritedListeners {
+		if name != adminListenerName && name != redirectListenerName {
+			listeners = append(listeners, inherited...)
+		}
+	}
+
+	if len(listeners) > 0 {
+		return listeners, nil
+	}
+
+	address := env.GetStringSafe(listenAddressVariable,
+		fmt.Sprintf(":%d", env.GetIntSafe(portVariable, defaultPort)))
+
+	listener, err := listen(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return []net.Listener{listener}, nil
+
+}
+
+// namedListener gets the listener passed by systemd with the specified name,
+// or listens on the supplied address if there is none.
+func namedListener(name, address string) (net.Listener, error) {
+
+	if inherited := inheritedListeners[name]; len(inherited) > 0 {
+		return inherited[0], nil
+	}
+
+	return listen(address)
+
+}
+
+// listen listens on the supplied address. Addresses starting with unix: are
+// the path of a Unix domain socket, any other address is a TCP address.
+func listen(address string) (net.Listener, error) {
+
+	if !strings.HasPrefix(address, unixAddressPrefix) {
+		return net.Listen("tcp", address)
+	}
+
+	path := strings.TrimPrefix(address, unixAddressPrefix)
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	// create the socket in a private directory next to the path so that it is
+	// never accessible to other users before its mode is set, then move it
+	// into place
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".socket")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	temp := filepath.Join(dir, "s")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{
+		Name: temp,
+		Net:  "unix",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the socket file is removed from its final path when the listener is
+	// closed
+	listener.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(temp, socketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &unixListener{UnixListener: listener, path: path}, nil
+
+}
+
+// unixListener is a Unix domain socket listener whose socket was moved after
+// it was created. The listener reports and removes the socket at its final
+// path.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+// Addr returns the final path of the socket.
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close stops listening and removes the socket.
+func (l *unixListener) Close() error {
+
+	err := l.UnixListener.Close()
+
+	if removeErr := os.Remove(l.path); err == nil && removeErr != nil &&
+		!os.IsNotExist(removeErr) {
+		err = removeErr
+	}
+
+	return err
+
+}
+
+// removeStaleSocket removes a Unix domain socket left behind by a server that
+// did not shut down cleanly. Returns an error if the path is not a socket or
+// another server is still accepting connections on it.
+func removeStaleSocket(path string) error {
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+
+	return os.Remove(path)
+
+}
+
+// serve serves requests on each of the supplied listeners using the supplied
+// server. Returns when any of the listeners is terminated.
+func serve(srv *http.Server, listeners []net.Listener, useTLS bool) error {
+
+	errs := make(chan error, len(listeners))
+
+	for _, listener := range listeners {
+
+		if useTLS {
+			logrus.Infof("starting HTTPS server on %s", describeListener(listener))
+		} else {
+			logrus.Infof("starting HTTP server on %s", describeListener(listener))
+		}
+
+		go func(listener net.Listener) {
+			if useTLS {
+				errs <- srv.ServeTLS(listener, "", "")
+			} else {
+				errs <- srv.Serve(listener)
+			}
+		}(listener)
+
+	}
+
+	return <-errs
+
+}
+
+// describeListener formats the address of the supplied listener for logging.
+func describeListener(listener net.Listener) string {
+
+	if listener.Addr().Network() == "unix" {
+		return unixAddressPrefix + listener.Addr().String()
+	}
+
+	return listener.Addr().String()
+
+}
+
+// parseSocketMode parses an octal file mode such as 0660.
+func parseSocketMode(mode string) (os.FileMode, error) {
+
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, fmt.Errorf("invalid socket mode '%s'", mode)
+	}
+
+	return os.FileMode(value), nil
+
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "listener")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mode := socketMode
+	socketMode = 0600
+	defer func() { socketMode = mode }()
+
+	path := filepath.Join(dir, "web-app.sock")
+	listener, err := listen(unixAddressPrefix + path)
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 ||
+		info.Mode().Perm() != 0600 {
+		t.Errorf("socket = %v, %v, want a socket with mode 0600", info, err)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("directory holds %d files, want only the socket", len(files))
+	}
+
+	if got := describeListener(listener); got != unixAddressPrefix+path {
+		t.Errorf("describeListener() = %s, want %s", got,
+			unixAddressPrefix+path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.Close()
+
+	if err := listener.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("Close() kept the socket, Lstat() error = %v", err)
+	}
+
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"web-app/data"
+	"web-app/httperror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaintenanceMode determines which requests the server refuses while
+// maintenance is being performed.
+type MaintenanceMode string
+
+const (
+	// MaintenanceOff indicates the server handles all requests normally.
+	MaintenanceOff MaintenanceMode = "off"
+	// MaintenanceOn indicates the server refuses all requests.
+	MaintenanceOn MaintenanceMode = "on"
+	// MaintenanceReadOnly indicates the server refuses any request that may
+	// modify data.
+	MaintenanceReadOnly MaintenanceMode = "read-only"
+)
+
+const (
+	// maintenanceModeVariable defines the environment variable for the
+	// maintenance mode used when no mode has been set at runtime.
+	maintenanceModeVariable = "WEB_APP_MAINTENANCE_MODE"
+	// maintenanceRetryAfterVariable defines the environment variable for the
+	// number of seconds clients are asked to wait before retrying a request
+	// refused during maintenance.
+	maintenanceRetryAfterVariable = "WEB_APP_MAINTENANCE_RETRY_AFTER"
+	// maintenancePollIntervalVariable defines the environment variable for the
+	// number of seconds between checks for a maintenance mode change.
+	maintenancePollIntervalVariable = "WEB_APP_MAINTENANCE_POLL_INTERVAL"
+	// maintenanceSettingID is the id of the record that stores the maintenance
+	// mode set at runtime.
+	maintenanceSettingID = 1
+	// maintenanceUnavailable is the error message returned when a request is
+	// refused during maintenance.
+	maintenanceUnavailable = "service is undergoing maintenance, please try again later"
+)
+
+// maintenanceSetting stores the maintenance mode set at runtime so that it is
+// shared by all server instances.
+type maintenanceSetting struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Mode MaintenanceMode `json:"mode"`
+}
+
+// maintenance stores the current maintenance mode.
+var maintenance = struct {
+	mutex *sync.RWMutex
+	mode  MaintenanceMode
+}{
+	mutex: &sync.RWMutex{},
+	mode:  MaintenanceOff,
+}
+
+// defaultMaintenanceMode is the maintenance mode used when no mode has been set
+// at runtime.
+var defaultMaintenanceMode MaintenanceMode
+
+// maintenanceRetryAfter determines how long clients should wait before
+// retrying a request refused during maintenance.
+var maintenanceRetryAfter time.Duration
+
+// maintenancePollInterval determines how often we check for a maintenance mode
+// change made by another server instance.
+var maintenancePollInterval time.Duration
+
+// maintenanceExemptPaths stores the request paths that are handled normally
+// during maintenance along with the most restrictive mode they are handled in.
+var maintenanceExemptPaths = map[string]MaintenanceMode{
+	"/health": MaintenanceOn,
+}
+
+// maintenanceExemptMutex synchronizes access to the maintenance exempt paths.
+var maintenanceExemptMutex = &sync.RWMutex{}
+
+// maintenanceBypass determines whether a request may bypass maintenance mode.
+var maintenanceBypass func(c *gin.Context) bool
+
+// AllowDuringMaintenance allows requests to the specified paths to be handled
+// normally while the server is in the supplied maintenance mode or a less
+// restrictive one. Paths allowed in read-only mode are refused while all
+// requests are refused, paths allowed while all requests are refused are
+// always handled. This should be used for endpoints that must remain
+// available to end maintenance, or that users need to regain access to the
+// application, such as login.
+func AllowDuringMaintenance(mode MaintenanceMode, paths ...string) {
+
+	maintenanceExemptMutex.Lock()
+	defer maintenanceExemptMutex.Unlock()
+
+	for _, path := range paths {
+		maintenanceExemptPaths[path] = mode
+	}
+
+}
+
+// SetMaintenanceBypass replaces the function used to determine whether a
+// request may bypass maintenance mode.
+func SetMaintenanceBypass(bypass func(c *gin.Context) bool) {
+	maintenanceBypass = bypass
+}
+
+// GetMaintenanceMode retrieves the current maintenance mode.
+func GetMaintenanceMode() MaintenanceMode {
+
+	maintenance.mutex.RLock()
+	defer maintenance.mutex.RUnlock()
+
+	return maintenance.mode
+
+}
+
+// SetMaintenanceMode changes the maintenance mode of all server instances.
+func SetMaintenanceMode(mode MaintenanceMode) error {
+
+	if err := validateMaintenanceMode(mode); err != nil {
+		return err
+	}
+
+	// store the maintenance mode so other server instances will pick it up
+	if err := data.DB().Clauses(clause.OnConflict{
+		UpdateAll: true,
+	}).Create(&maintenanceSetting{
+		ID:   maintenanceSettingID,
+		Mode: mode,
+	}).Error; err != nil {
+		return err
+	}
+
+	setLocalMaintenanceMode(mode)
+
+	return nil
+
+}
+
+// MaintenanceMiddleware gets middleware that refuses requests while the server
+// is in maintenance mode. Refused requests receive a 503 - Service Unavailable
+// response with a Retry-After header.
+func MaintenanceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		mode := GetMaintenanceMode()
+
+		if mode == MaintenanceOff {
+			c.Next()
+			return
+		}
+
+		// in read-only mode allow any request that does not modify data
+		if mode == MaintenanceReadOnly && (c.Request.Method == http.MethodGet ||
+			c.Request.Method == http.MethodHead ||
+			c.Request.Method == http.MethodOptions) {
+			c.Next()
+			return
+		}
+
+		maintenanceExemptMutex.RLock()
+		allowed, exempt := maintenanceExemptPaths[c.Request.URL.Path]
+		maintenanceExemptMutex.RUnlock()
+
+		if exempt && (allowed == MaintenanceOn || allowed == mode) {
+			c.Next()
+			return
+		}
+
+		if maintenanceBypass != nil && maintenanceBypass(c) {
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After",
+			strconv.Itoa(int(maintenanceRetryAfter.Seconds())))
+		c.JSON(http.StatusServiceUnavailable, httperror.ErrorResponse{
+			ErrorMessage: maintenanceUnavailable,
+		})
+		c.Abort()
+
+	}
+}
+
+// loadMaintenanceMode reads the maintenance mode set at runtime, falling back
+// on the configured default if no mode has been set.
+func loadMaintenanceMode() error {
+
+	var setting maintenanceSetting
+
+	err := data.DB().Model(&maintenanceSetting{}).
+		Where("id = ?", maintenanceSettingID).
+		First(&setting).Error
+	if err == gorm.ErrRecordNotFound {
+		setLocalMaintenanceMode(defaultMaintenanceMode)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	setLocalMaintenanceMode(setting.Mode)
+
+	return nil
+
+}
+
+// watchMaintenanceMode periodically reloads the maintenance mode so that
+// changes made by other server instances are applied.
+func watchMaintenanceMode(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := loadMaintenanceMode(); err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+// setLocalMaintenanceMode changes the maintenance mode of this server instance.
+func setLocalMaintenanceMode(mode MaintenanceMode) {
+
+	maintenance.mutex.Lock()
+	defer maintenance.mutex.Unlock()
+
+	if maintenance.mode != mode {
+		logrus.Infof("maintenance mode: %s", mode)
+	}
+
+	maintenance.mode = mode
+
+}
+
+// validateMaintenanceMode checks that the supplied maintenance mode is valid.
+func validateMaintenanceMode(mode MaintenanceMode) error {
+	switch mode {
+	case MaintenanceOff, MaintenanceOn, MaintenanceReadOnly:
+		return nil
+	}
+	return fmt.Errorf("invalid maintenance mode '%s'", mode)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testEnvironment sets the environment variables required by the package
+// before it is initialized.
+var testEnvironment = os.Setenv(clientBaseURLVariable, "http://localhost")
+
+// serveMaintenance sends a request through the maintenance middleware in the
+// supplied mode. Returns the response status.
+func serveMaintenance(mode MaintenanceMode, method, path string) int {
+
+	setLocalMaintenanceMode(mode)
+	defer setLocalMaintenanceMode(MaintenanceOff)
+
+	router := gin.New()
+	router.Use(MaintenanceMiddleware())
+	router.Handle(method, path, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+
+	return w.Code
+
+}
+
+func TestMaintenanceEndpointAlwaysAllowed(t *testing.T) {
+
+	// a user with the maintenance permission but without the bypass must be
+	// able to end maintenance
+	AllowDuringMaintenance(MaintenanceOn, "/test/maintenance")
+
+	for _, mode := range []MaintenanceMode{MaintenanceOn, MaintenanceReadOnly} {
+		if status := serveMaintenance(mode, http.MethodPut,
+			"/test/maintenance"); status != http.StatusOK {
+			t.Errorf("PUT /test/maintenance in mode %s = %d, want 200", mode,
+				status)
+		}
+	}
+
+}
+
+func TestLoginAllowedInReadOnlyMode(t *testing.T) {
+
+	// users must be able to log in and refresh tokens once their access
+	// token expires
+	AllowDuringMaintenance(MaintenanceReadOnly, "/test/login", "/test/refresh")
+
+	for _, path := range []string{"/test/login", "/test/refresh"} {
+
+		if status := serveMaintenance(MaintenanceReadOnly, http.MethodPost,
+			path); status != http.StatusOK {
+			t.Errorf("POST %s in read-only mode = %d, want 200", path, status)
+		}
+
+		if status := serveMaintenance(MaintenanceOn, http.MethodPost,
+			path); status != http.StatusServiceUnavailable {
+			t.Errorf("POST %s in mode on = %d, want 503", path, status)
+		}
+
+	}
+
+}
+
+func TestMaintenanceRefusesRequests(t *testing.T) {
+
+	tests := []struct {
+		mode   MaintenanceMode
+		method string
+		status int
+	}{
+		{MaintenanceOff, http.MethodPost, http.StatusOK},
+		{MaintenanceReadOnly, http.MethodGet, http.StatusOK},
+		{MaintenanceReadOnly, http.MethodPost, http.StatusServiceUnavailable},
+		{MaintenanceOn, http.MethodGet, http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		if status := serveMaintenance(test.mode, test.method,
+			"/test/other"); status != test.status {
+			t.Errorf("%s /test/other in mode %s = %d, want %d", test.method,
+				test.mode, status, test.status)
+		}
+	}
+
+	if status := serveMaintenance(MaintenanceOn, http.MethodGet,
+		"/health"); status != http.StatusOK {
+		t.Errorf("GET /health in mode on = %d, want 200", status)
+	}
+
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"web-app/httperror"
+	"web-app/identity"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/twinj/uuid"
+)
+
+const (
+	// incidentLogFileVariable defines the environment variable for the path
+	// of a file that incidents are appended to.
+	incidentLogFileVariable = "WEB_APP_INCIDENT_LOG_FILE"
+	// incidentReportURLVariable defines the environment variable for a URL
+	// that incidents are posted to.
+	incidentReportURLVariable = "WEB_APP_INCIDENT_REPORT_URL"
+)
+
+// Incident records the details of a panic encountered while handling a
+// request.
+type Incident struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	UserID    uint      `json:"user_id,omitempty"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Error     string    `json:"error"`
+	Stack     string    `json:"stack"`
+}
+
+// IncidentReporter forwards incidents to an error reporting service.
+type IncidentReporter interface {
+	Report(incident *Incident) error
+}
+
+// incidentReporter is used to forward incidents. If nil incidents are only
+// logged.
+var incidentReporter IncidentReporter
+
+// SetIncidentReporter replaces the reporter used to forward incidents.
+func SetIncidentReporter(reporter IncidentReporter) {
+	incidentReporter = reporter
+}
+
+// RecoveryMiddleware gets middleware that recovers from any panic encountered
+// while handling a request. The panic is logged along with the stack trace and
+// forwarded to the incident reporter. The client receives a 500 - Internal
+// Server Error response containing an incident id that can be quoted to
+// support.
+func RecoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		defer func() {
+
+			r := recover()
+			if r == nil {
+				return
+			}
+
+			incident := &Incident{
+				ID:        uuid.NewV4().String(),
+				Time:      time.Now(),
+				RequestID: RequestID(c),
+				Method:    c.Request.Method,
+				Path:      c.Request.URL.Path,
+				Error:     fmt.Sprint(r),
+				Stack:     string(debug.Stack()),
+			}
+
+			if userID, ok := identity.RequestUserID(c); ok {
+				incident.UserID = userID
+			}
+
+			// log the incident
+			logrus.WithContext(c).WithFields(logrus.Fields{
+				"incident_id": incident.ID,
+				"request_id":  incident.RequestID,
+				"user_id":     incident.UserID,
+				"method":      incident.Method,
+				"path":        incident.Path,
+				"stack":       incident.Stack,
+			}).Errorf("panic recovered: %s", incident.Error)
+
+			// forward the incident without delaying the response
+			if reporter := incidentReporter; reporter != nil {
+				ctx := c.Request.Context()
+				go func() {
+					if err := reporter.Report(incident); err != nil {
+						logrus.WithContext(ctx).Error(err)
+					}
+				}()
+			}
+
+			// the response may have already been partially written in which
+			// case we can only stop processing the request
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+
+			c.AbortWithStatusJSON(http.StatusInternalServerError,
+				httperror.ErrorResponse{
+					ErrorMessage: httperror.InternalServerError,
+					IncidentID:   incident.ID,
+				})
+
+		}()
+
+		c.Next()
+
+	}
+}
+
+// FileIncidentReporter appends incidents to a file as JSON lines.
+type FileIncidentReporter struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+// Report appends the supplied incident to the file.
+func (f *FileIncidentReporter) Report(incident *Incident) error {
+
+	line, err := json.Marshal(incident)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+
+}
+
+// HTTPIncidentReporter posts incidents as JSON to a URL.
+type HTTPIncidentReporter struct {
+	URL    string
+	Client *http.Client
+}
+
+// Report posts the supplied incident to the URL.
+func (h *HTTPIncidentReporter) Report(incident *Incident) error {
+
+	body, err := json.Marshal(incident)
+	if err != nil {
+		return err
+	}
+
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("incident report failed with status %d",
+			resp.StatusCode)
+	}
+
+	return nil
+
+}
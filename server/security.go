@@ -0,0 +1,120 @@
+package server
+
+import (
+	"strings"
+
+	"web-app/env"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// hstsVariable defines the environment variable for the value of the
+	// Strict-Transport-Security header.
+	hstsVariable = "WEB_APP_HSTS"
+	// contentTypeOptionsVariable defines the environment variable for the
+	// value of the X-Content-Type-Options header.
+	contentTypeOptionsVariable = "WEB_APP_CONTENT_TYPE_OPTIONS"
+	// referrerPolicyVariable defines the environment variable for the value of
+	// the Referrer-Policy header.
+	referrerPolicyVariable = "WEB_APP_REFERRER_POLICY"
+	// frameOptionsVariable defines the environment variable for the value of
+	// the X-Frame-Options header.
+	frameOptionsVariable = "WEB_APP_FRAME_OPTIONS"
+	// contentSecurityPolicyVariable defines the environment variable for the
+	// value of the Content-Security-Policy header.
+	contentSecurityPolicyVariable = "WEB_APP_CONTENT_SECURITY_POLICY"
+	// authCacheControlVariable defines the environment variable for the value
+	// of the Cache-Control header sent with responses containing auth tokens.
+	authCacheControlVariable = "WEB_APP_AUTH_CACHE_CONTROL"
+	// securityHeaderDisabled is the value that may be assigned to any security
+	// header environment variable to omit the header from responses.
+	securityHeaderDisabled = "none"
+)
+
+// useTLS indicates whether the server is configured to use TLS encryption.
+var useTLS bool
+
+// hstsHeader stores the value of the Strict-Transport-Security header.
+var hstsHeader string
+
+// contentTypeOptionsHeader stores the value of the X-Content-Type-Options
+// header.
+var contentTypeOptionsHeader string
+
+// referrerPolicyHeader stores the value of the Referrer-Policy header.
+var referrerPolicyHeader string
+
+// frameOptionsHeader stores the value of the X-Frame-Options header.
+var frameOptionsHeader string
+
+// contentSecurityPolicyHeader stores the value of the Content-Security-Policy
+// header.
+var contentSecurityPolicyHeader string
+
+// authCacheControlHeader stores the value of the Cache-Control header sent with
+// responses that contain auth tokens.
+var authCacheControlHeader string
+
+// SecurityHeadersMiddleware gets middleware that adds standard hardening
+// headers to every response. The Strict-Transport-Security header is only sent
+// when the server is run using TLS encryption.
+func SecurityHeadersMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		header := c.Writer.Header()
+
+		if useTLS && hstsHeader != "" {
+			header.Set("Strict-Transport-Security", hstsHeader)
+		}
+
+		if contentTypeOptionsHeader != "" {
+			header.Set("X-Content-Type-Options", contentTypeOptionsHeader)
+		}
+
+		if referrerPolicyHeader != "" {
+			header.Set("Referrer-Policy", referrerPolicyHeader)
+		}
+
+		if frameOptionsHeader != "" {
+			header.Set("X-Frame-Options", frameOptionsHeader)
+		}
+
+		if contentSecurityPolicyHeader != "" {
+			header.Set("Content-Security-Policy", contentSecurityPolicyHeader)
+		}
+
+		c.Next()
+
+	}
+}
+
+// NoStoreMiddleware gets middleware that prevents clients and intermediate
+// caches from storing the response. This should be used on any endpoint that
+// responds with auth tokens.
+func NoStoreMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		if authCacheControlHeader != "" {
+			c.Writer.Header().Set("Cache-Control", authCacheControlHeader)
+			c.Writer.Header().Set("Pragma", "no-cache")
+		}
+
+		c.Next()
+
+	}
+}
+
+// securityHeaderValue reads the value of a security header from the specified
+// environment variable, returning the supplied default value if the variable is
+// not set. Returns an empty string if the header has been disabled.
+func securityHeaderValue(key, defaultVal string) string {
+
+	val := env.GetStringSafe(key, defaultVal)
+	if strings.EqualFold(val, securityHeaderDisabled) {
+		return ""
+	}
+
+	return val
+
+}
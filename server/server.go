@@ -1,17 +1,100 @@
 // Package server exposes a server router than can be used to bind API endpoints
 // and provides functions for managing the server.
 //
+// The server may be started by systemd socket activation in which case it
+// serves requests on the sockets passed through LISTEN_FDS rather than opening
+// its own. Sockets named admin or redirect with FileDescriptorName are used by
+// the internal admin listener and the HTTP redirect listener respectively, all
+// other sockets serve the application.
+//
 // Environment:
 //     WEB_APP_PORT
 //         int - the port on which we listen for incoming requests.
+//     WEB_APP_LISTEN_ADDRESS
+//         string - the address on which we listen for incoming requests, either
+//                  a TCP address such as 127.0.0.1:8080 or the path of a Unix
+//                  domain socket prefixed with unix:, e.g.
+//                  unix:/run/web-app/web-app.sock. Takes precedence over the
+//                  port. Ignored when the server is socket activated.
+//     WEB_APP_SOCKET_MODE
+//         string - the octal file mode of Unix domain sockets created by the
+//                  server.
+//                  Default: 0660
 //     WEB_APP_CERT
 //         string - the path to the certificate used for TLS encryption.
 //     WEB_APP_KEY:
 //         string - the path to the key used for TLS encryption.
+//     WEB_APP_CERT_RELOAD_INTERVAL
+//         int - the number of seconds between checks for a changed TLS
+//               certificate or key. Changed files are loaded without
+//               restarting the server.
+//               Default: 30
+//     WEB_APP_ACME_DOMAINS
+//         string - a comma separated list of domains to obtain certificates
+//                  for through ACME. If set the server will be run using TLS
+//                  encryption and the certificate and key files are ignored.
+//     WEB_APP_ACME_EMAIL
+//         string - the contact email address registered with the ACME
+//                  certificate authority.
+//     WEB_APP_ACME_CACHE_DIR
+//         string - the directory used to cache ACME account keys and
+//                  certificates.
+//                  Default: acme-cache
+//     WEB_APP_ACME_DIRECTORY_URL
+//         string - the directory URL of the ACME certificate authority.
+//                  Default: https://acme-v02.api.letsencrypt.org/directory
+//     WEB_APP_ACME_CA_BUNDLE
+//         string - the path to a PEM encoded bundle of additional certificate
+//                  authorities trusted when connecting to the ACME directory.
+//     WEB_APP_CLIENT_CA
+//         string - the path to a PEM encoded bundle of certificate authorities
+//                  used to verify client certificates.
+//     WEB_APP_CLIENT_AUTH
+//         string - the client certificate policy when the server is run using
+//                  TLS encryption; one of none, request, or require. Clients
+//                  that do not supply a certificate are rejected when set to
+//                  require.
+//                  Default: request if a client CA is set, otherwise none
+//     WEB_APP_ADMIN_PORT
+//         int - the port on which a separate internal listener serves admin,
+//               debug, and metrics endpoints. If not set these endpoints are
+//               served by the public listener.
+//     WEB_APP_ADMIN_HOST
+//         string - the host address the internal admin listener binds to.
+//                  Default: 127.0.0.1
+//     WEB_APP_HTTP_REDIRECT
+//         bool - a flag that indicates whether an HTTP listener should redirect
+//                clients to HTTPS when the server is run using TLS. When ACME
+//                is enabled this listener also answers HTTP-01 challenges.
+//                Default: false
+//     WEB_APP_HTTP_REDIRECT_PORT
+//         int - the port on which we listen for HTTP requests to redirect.
+//               Default: 80
+//     WEB_APP_READ_TIMEOUT
+//         int - the number of seconds allowed to read an entire request.
+//               Default: 15
+//     WEB_APP_READ_HEADER_TIMEOUT
+//         int - the number of seconds allowed to read request headers.
+//               Default: 5
+//     WEB_APP_WRITE_TIMEOUT
+//         int - the number of seconds allowed to write a response.
+//               Default: 30
+//     WEB_APP_IDLE_TIMEOUT
+//         int - the number of seconds an idle keep-alive connection is kept
+//               open.
+//               Default: 120
+//     WEB_APP_MAX_HEADER_BYTES
+//         int - the maximum size in bytes of request headers.
+//               Default: 1048576
+//     WEB_APP_MAX_BODY_BYTES
+//         int - the maximum size in bytes of a request body.
+//               Default: 1048576
 //     WEB_APP_CORS_ALLOW_ORIGINS
 //         string - a comma separated list of origins a cross-domain request
-//                  can be executed from.
-//                  Default: *
+//                  can be executed from. An origin may use a wildcard to match
+//                  any subdomain, e.g. https://*.example.com, or be * to allow
+//                  any origin. * may not be used when credentials are allowed.
+//                  Default: the origin of the client base URL
 //     WEB_APP_CORS_ALLOW_METHODS
 //         string - a comma separated list of HTTP methods a client is allowed
 //                  to use in a cross-domain request.
@@ -33,21 +116,86 @@
 //     WEB_APP_CORS_MAX_AGE
 //         int - the number of seconds a preflight response may be cached.
 //               Default: 600
+//     WEB_APP_CORS_ALLOWLIST
+//         bool - a flag that indicates whether cross-domain requests are also
+//                allowed from origins added to the database allowlist at
+//                runtime.
+//                Default: false
+//     WEB_APP_CORS_POLL_INTERVAL
+//         int - the number of seconds between reloads of the origin allowlist
+//               so that changes made by other server instances are applied.
+//               Default: 10
 //     WEB_APP_CLIENT_BASE_URL
 //         string - the base URL of the server that is used to serve the
 //                  application front-end.
+//     WEB_APP_HSTS
+//         string - the value of the Strict-Transport-Security header. The
+//                  header is only sent when the server is run using TLS.
+//                  Default: max-age=63072000; includeSubDomains
+//     WEB_APP_CONTENT_TYPE_OPTIONS
+//         string - the value of the X-Content-Type-Options header.
+//                  Default: nosniff
+//     WEB_APP_REFERRER_POLICY
+//         string - the value of the Referrer-Policy header.
+//                  Default: no-referrer
+//     WEB_APP_FRAME_OPTIONS
+//         string - the value of the X-Frame-Options header.
+//                  Default: DENY
+//     WEB_APP_CONTENT_SECURITY_POLICY
+//         string - the value of the Content-Security-Policy header.
+//                  Default: default-src 'none'; frame-ancestors 'none'
+//     WEB_APP_AUTH_CACHE_CONTROL
+//         string - the value of the Cache-Control header sent with responses
+//                  that contain auth tokens.
+//                  Default: no-store
+//     WEB_APP_INCIDENT_LOG_FILE
+//         string - the path of a file that incidents are appended to when the
+//                  server recovers from a panic.
+//     WEB_APP_INCIDENT_REPORT_URL
+//         string - a URL that incidents are posted to when the server recovers
+//                  from a panic. Takes precedence over the incident log file.
+//     WEB_APP_COMPRESSION_MIN_SIZE
+//         int - the minimum size in bytes of a response body before it is
+//               compressed.
+//               Default: 1024
+//     WEB_APP_COMPRESSION_TYPES
+//         string - a comma separated list of content types that may be
+//                  compressed.
+//                  Default: application/json, application/javascript,
+//                           application/xml, image/svg+xml, text/css,
+//                           text/html, text/plain, text/xml
+//     WEB_APP_MAINTENANCE_MODE
+//         string - the maintenance mode used when no mode has been set at
+//                  runtime; one of off, on, or read-only.
+//                  Default: off
+//     WEB_APP_MAINTENANCE_RETRY_AFTER
+//         int - the number of seconds clients are asked to wait before
+//               retrying a request refused during maintenance.
+//               Default: 300
+//     WEB_APP_MAINTENANCE_POLL_INTERVAL
+//         int - the number of seconds between checks for a maintenance mode
+//               change made by another server instance.
+//               Default: 10
+//
+// Any security header may be omitted from responses by setting the associated
+// environment variable to "none".
 package server
 
 import (
 	"fmt"
+	"net"
+	"net/http"
 	"regexp"
+	"strconv"
 	"time"
 
+	"web-app/data"
 	"web-app/env"
+	"web-app/tracing"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
 )
 
 // init initializes the application server router.
@@ -59,33 +207,167 @@ func init() {
 
 	r := regexp.MustCompile("\\s*,\\s*")
 
-	// parse CORS settings from environment
-	allowOrigins = r.Split(env.GetStringSafe(allowOriginsVariable,
-		"*"), -1)
-	allowMethods = r.Split(env.GetStringSafe(allowMethodsVariable,
-		"POST,GET,PUT,PATCH,DELETE"), -1)
-	allowHeaders = r.Split(env.GetStringSafe(allowHeadersVariable,
-		"Accept,Content-Type,Content-Length,Accept-Encoding,X-CSRF-Token,Authorization,Origin,Cache-Control,X-Requested-With"), -1)
-	allowCredentials = env.GetBoolSafe(allowCredentialsVariable, true)
-	exposeHeaders = r.Split(env.GetStringSafe(exposeHeadersVariable,
-		"X-Requested-With,X-Total-Records"), -1)
-	preflightMaxAge = env.GetIntSafe(preflightMaxAgeVariable, 600)
-
 	// get client base URL
 	clientBaseURL = env.MustGetString(clientBaseURLVariable)
 
+	// parse TLS settings from environment
+	certReloadInterval = time.Duration(
+		env.GetIntSafe(certReloadIntervalVariable, 30)) * time.Second
+	if domains := env.GetString(acmeDomainsVariable); domains != "" {
+		acmeDomains = r.Split(domains, -1)
+	}
+	acmeEmail = env.GetString(acmeEmailVariable)
+	acmeCacheDir = env.GetStringSafe(acmeCacheDirVariable, "acme-cache")
+	acmeDirectoryURL = env.GetStringSafe(acmeDirectoryURLVariable,
+		acme.LetsEncryptURL)
+	acmeCABundle = env.GetString(acmeCABundleVariable)
+	clientCA = env.GetString(clientCAVariable)
+	if clientCA != "" {
+		clientAuth = env.GetStringSafe(clientAuthVariable, clientAuthRequest)
+	} else {
+		clientAuth = env.GetStringSafe(clientAuthVariable, clientAuthNone)
+	}
+
+	useTLS = env.GetString(tlsCertVariable) != "" ||
+		env.GetString(tlsKeyVariable) != "" || len(acmeDomains) > 0
+
+	// parse security header settings from environment
+	hstsHeader = securityHeaderValue(hstsVariable,
+		"max-age=63072000; includeSubDomains")
+	contentTypeOptionsHeader = securityHeaderValue(contentTypeOptionsVariable,
+		"nosniff")
+	referrerPolicyHeader = securityHeaderValue(referrerPolicyVariable,
+		"no-referrer")
+	frameOptionsHeader = securityHeaderValue(frameOptionsVariable, "DENY")
+	contentSecurityPolicyHeader = securityHeaderValue(
+		contentSecurityPolicyVariable,
+		"default-src 'none'; frame-ancestors 'none'")
+	authCacheControlHeader = securityHeaderValue(authCacheControlVariable,
+		"no-store")
+
+	// parse HTTP server limits from environment
+	readTimeout = time.Duration(
+		env.GetIntSafe(readTimeoutVariable, 15)) * time.Second
+	readHeaderTimeout = time.Duration(
+		env.GetIntSafe(readHeaderTimeoutVariable, 5)) * time.Second
+	writeTimeout = time.Duration(
+		env.GetIntSafe(writeTimeoutVariable, 30)) * time.Second
+	idleTimeout = time.Duration(
+		env.GetIntSafe(idleTimeoutVariable, 120)) * time.Second
+	maxHeaderBytes = env.GetIntSafe(maxHeaderBytesVariable, 1<<20)
+	maxBodyBytes = int64(env.GetIntSafe(maxBodyBytesVariable, 1<<20))
+
+	// parse CORS settings from environment, by default only the client may
+	// execute cross-domain requests
+	clientOrigin, err := originOf(clientBaseURL)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := SetCORSPolicy("", &CORSPolicy{
+		AllowOrigins: r.Split(env.GetStringSafe(allowOriginsVariable,
+			clientOrigin), -1),
+		AllowMethods: r.Split(env.GetStringSafe(allowMethodsVariable,
+			"POST,GET,PUT,PATCH,DELETE"), -1),
+		AllowHeaders: r.Split(env.GetStringSafe(allowHeadersVariable,
+			"Accept,Content-Type,Content-Length,Accept-Encoding,X-CSRF-Token,Authorization,Origin,Cache-Control,X-Requested-With"), -1),
+		ExposeHeaders: r.Split(env.GetStringSafe(exposeHeadersVariable,
+			"X-Requested-With,X-Total-Records"), -1),
+		AllowCredentials: env.GetBoolSafe(allowCredentialsVariable, true),
+		MaxAge:           env.GetIntSafe(preflightMaxAgeVariable, 600),
+		UseAllowlist:     env.GetBoolSafe(corsAllowlistVariable, false),
+	}); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// configure the CORS origin allowlist
+	data.DB().AutoMigrate(CORSOrigin{})
+
+	corsPollInterval = time.Duration(
+		env.GetIntSafe(corsPollIntervalVariable, 10)) * time.Second
+
+	if err := loadCORSOrigins(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// configure maintenance mode
+	data.DB().AutoMigrate(maintenanceSetting{})
+
+	defaultMaintenanceMode = MaintenanceMode(env.GetStringSafe(
+		maintenanceModeVariable, string(MaintenanceOff)))
+	if err := validateMaintenanceMode(defaultMaintenanceMode); err != nil {
+		logrus.Fatal(err)
+	}
+	maintenanceRetryAfter = time.Duration(
+		env.GetIntSafe(maintenanceRetryAfterVariable, 300)) * time.Second
+	maintenancePollInterval = time.Duration(
+		env.GetIntSafe(maintenancePollIntervalVariable, 10)) * time.Second
+
+	if err := loadMaintenanceMode(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// configure incident reporting
+	if reportURL := env.GetString(incidentReportURLVariable); reportURL != "" {
+		SetIncidentReporter(&HTTPIncidentReporter{URL: reportURL})
+	} else if logFile := env.GetString(incidentLogFileVariable); logFile != "" {
+		SetIncidentReporter(&FileIncidentReporter{Path: logFile})
+	}
+
+	// parse compression settings from environment
+	compressionMinSize = env.GetIntSafe(compressionMinSizeVariable, 1024)
+	for _, contentType := range r.Split(env.GetStringSafe(
+		compressionTypesVariable,
+		"application/json,application/javascript,application/xml,image/svg+xml,text/css,text/html,text/plain,text/xml"), -1) {
+		compressionTypes[contentType] = struct{}{}
+	}
+
+	// parse admin listener settings from environment
+	adminPort = env.GetIntSafe(adminPortVariable, 0)
+	adminHost = env.GetStringSafe(adminHostVariable, "127.0.0.1")
+
+	// parse listener settings from environment and take any sockets passed by
+	// systemd socket activation
+	socketMode, err = parseSocketMode(
+		env.GetStringSafe(socketModeVariable, "0660"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	inheritedListeners, err = inheritListeners()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
 	// initialize application server router
-	router = gin.Default()
+	router = gin.New()
+
+	// initialize logging, request id, tracing, and panic recovery middleware
+	router.Use(gin.Logger(), RequestIDMiddleware(), tracing.Middleware(),
+		RecoveryMiddleware())
+
+	// initialize response compression middleware
+	router.Use(CompressionMiddleware())
 
 	// initialize CORS middleware
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     allowOrigins,
-		AllowMethods:     allowMethods,
-		AllowHeaders:     allowHeaders,
-		AllowCredentials: allowCredentials,
-		ExposeHeaders:    exposeHeaders,
-		MaxAge:           time.Duration(preflightMaxAge) * time.Second,
-	}))
+	router.Use(CORSMiddleware())
+
+	// initialize security headers middleware
+	router.Use(SecurityHeadersMiddleware())
+
+	// initialize request body size limit middleware
+	router.Use(BodyLimitMiddleware(maxBodyBytes))
+
+	// initialize maintenance mode middleware
+	router.Use(MaintenanceMiddleware())
+
+	// initialize the internal admin router if a separate admin listener was
+	// configured, the admin router is not subject to CORS or maintenance mode
+	if adminPort != 0 || len(inheritedListeners[adminListenerName]) > 0 {
+		adminRouter = gin.New()
+		adminRouter.Use(gin.Logger(), RequestIDMiddleware(),
+			tracing.Middleware(), RecoveryMiddleware(), CompressionMiddleware(),
+			SecurityHeadersMiddleware(), BodyLimitMiddleware(maxBodyBytes))
+	}
 }
 
 const (
@@ -97,6 +379,37 @@ const (
 	// tlsKeyVariable defines the environment variable for the TLS key. If set
 	// the server will run using TLS encryption.
 	tlsKeyVariable = "WEB_APP_KEY"
+	// adminPortVariable defines the environment variable for the port of the
+	// internal admin listener.
+	adminPortVariable = "WEB_APP_ADMIN_PORT"
+	// adminHostVariable defines the environment variable for the host address
+	// the internal admin listener binds to.
+	adminHostVariable = "WEB_APP_ADMIN_HOST"
+	// httpRedirectVariable defines the environment variable that when set to
+	// true will cause the server to redirect HTTP requests to HTTPS when the
+	// server is run using TLS encryption.
+	httpRedirectVariable = "WEB_APP_HTTP_REDIRECT"
+	// httpRedirectPortVariable defines the environment variable for the port
+	// on which we listen for HTTP requests to redirect.
+	httpRedirectPortVariable = "WEB_APP_HTTP_REDIRECT_PORT"
+	// readTimeoutVariable defines the environment variable for the number of
+	// seconds allowed to read an entire request.
+	readTimeoutVariable = "WEB_APP_READ_TIMEOUT"
+	// readHeaderTimeoutVariable defines the environment variable for the
+	// number of seconds allowed to read request headers.
+	readHeaderTimeoutVariable = "WEB_APP_READ_HEADER_TIMEOUT"
+	// writeTimeoutVariable defines the environment variable for the number of
+	// seconds allowed to write a response.
+	writeTimeoutVariable = "WEB_APP_WRITE_TIMEOUT"
+	// idleTimeoutVariable defines the environment variable for the number of
+	// seconds an idle keep-alive connection is kept open.
+	idleTimeoutVariable = "WEB_APP_IDLE_TIMEOUT"
+	// maxHeaderBytesVariable defines the environment variable for the maximum
+	// size of request headers.
+	maxHeaderBytesVariable = "WEB_APP_MAX_HEADER_BYTES"
+	// maxBodyBytesVariable defines the environment variable for the maximum
+	// size of a request body.
+	maxBodyBytesVariable = "WEB_APP_MAX_BODY_BYTES"
 	// httpDefaultPort the default port when running the server without TLS
 	// encryption and no explicit port.
 	httpDefaultPort = 80
@@ -129,32 +442,40 @@ const (
 // router is used to bind API endpoints.
 var router *gin.Engine
 
-// allowOrigins determines which origins may execute a cross-domain request.
-var allowOrigins []string
+// adminRouter is used to bind admin, debug, and metrics endpoints when a
+// separate admin listener is configured.
+var adminRouter *gin.Engine
 
-// allowMethods determines which HTTP methods a client may use in a cross-domain
-// request.
-var allowMethods []string
+// adminPort stores the port of the internal admin listener. The admin listener
+// is disabled if the port is zero.
+var adminPort int
+
+// adminHost stores the host address the internal admin listener binds to.
+var adminHost string
+
+// clientBaseURL stores the base URL of the server that is used to serve the
+// application front-end. This value is used when formatting links.
+var clientBaseURL string
 
-// allowHeaders determines which headers may be supplied in a cross-domain
+// readTimeout determines how long the server may spend reading an entire
 // request.
-var allowHeaders []string
+var readTimeout time.Duration
 
-// allowCredentials determines whether a cross-domain request may include user
-// credentials.
-var allowCredentials bool
+// readHeaderTimeout determines how long the server may spend reading request
+// headers.
+var readHeaderTimeout time.Duration
 
-// exposeHeaders determines which headers the server may expose in responses to
-// cross-domain requests.
-var exposeHeaders []string
+// writeTimeout determines how long the server may spend writing a response.
+var writeTimeout time.Duration
 
-// preflightMaxAge determines how long in seconds we may cache a response to a
-// preflight request.
-var preflightMaxAge int
+// idleTimeout determines how long an idle keep-alive connection is kept open.
+var idleTimeout time.Duration
 
-// clientBaseURL stores the base URL of the server that is used to serve the
-// application front-end. This value is used when formatting links.
-var clientBaseURL string
+// maxHeaderBytes determines the maximum size in bytes of request headers.
+var maxHeaderBytes int
+
+// maxBodyBytes determines the maximum size in bytes of a request body.
+var maxBodyBytes int64
 
 // Router retrieves the application server router which can be used to bind
 // handler functions to API endpoints.
@@ -162,6 +483,22 @@ func Router() *gin.Engine {
 	return router
 }
 
+// AdminRouter retrieves the router used to bind admin, debug, and metrics
+// endpoints. If a separate admin listener is configured these endpoints are
+// only reachable through the admin listener, otherwise the application server
+// router is returned.
+func AdminRouter() gin.IRouter {
+	if adminRouter != nil {
+		return adminRouter
+	}
+	return router
+}
+
+// WriteTimeout retrieves how long the server may spend writing a response.
+func WriteTimeout() time.Duration {
+	return writeTimeout
+}
+
 // ClientBaseURL retrieves the client base URL.
 func ClientBaseURL() string {
 	return clientBaseURL
@@ -170,30 +507,129 @@ func ClientBaseURL() string {
 // Run starts the application server. Returns when the server is terminated.
 func Run() {
 
+	// apply maintenance mode changes made by other server instances
+	go watchMaintenanceMode(maintenancePollInterval)
+
+	// apply origin allowlist changes made by other server instances
+	go watchCORSOrigins(corsPollInterval)
+
+	// run the internal admin listener
+	if adminRouter != nil {
+		go runAdmin()
+	}
+
 	cert, key := env.GetString(tlsCertVariable), env.GetString(tlsKeyVariable)
 
 	// check if we should be running the server using TLS encryption
-	if cert != "" || key != "" {
+	if useTLS {
+
+		// load TLS certificates
+		tlsConfig, err := newTLSConfig(cert, key)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		// optionally redirect HTTP requests to HTTPS
+		if env.GetBoolSafe(httpRedirectVariable, false) {
+			go runRedirect(env.GetIntSafe(httpRedirectPortVariable,
+				httpDefaultPort))
+		}
 
 		// run the server using HTTPS
-		port := env.GetIntSafe(portVariable, httpsDefaultPort)
+		listeners, err := mainListeners(httpsDefaultPort)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		srv := newHTTPServer("", router)
+		srv.TLSConfig = tlsConfig
 
-		logrus.Infof("starting HTTPS server on port %d", port)
-		logrus.Error(router.RunTLS(
-			fmt.Sprintf(":%d", port),
-			cert, key,
-		))
+		logrus.Error(serve(srv, listeners, true))
 
 	} else {
 
 		// run the server using HTTP
-		port := env.GetIntSafe(portVariable, httpDefaultPort)
+		listeners, err := mainListeners(httpDefaultPort)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		logrus.Error(serve(newHTTPServer("", router), listeners, false))
+
+	}
+
+}
 
-		logrus.Infof("starting HTTP server on port %d", port)
-		logrus.Error(router.Run(
-			fmt.Sprintf(":%d", port),
-		))
+// runAdmin starts the internal admin listener. Returns when the listener is
+// terminated.
+func runAdmin() {
 
+	listener, err := namedListener(adminListenerName,
+		net.JoinHostPort(adminHost, strconv.Itoa(adminPort)))
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	logrus.Infof("starting admin HTTP server on %s", describeListener(listener))
+	logrus.Error(newHTTPServer("", adminRouter).Serve(listener))
+
+}
+
+// runRedirect starts an HTTP listener on the specified port that redirects all
+// requests to HTTPS. If ACME is enabled the listener also responds to HTTP-01
+// challenges. Returns when the listener is terminated.
+func runRedirect(port int) {
+
+	var handler http.Handler = http.HandlerFunc(redirectHandler)
+	if acmeManager != nil {
+		handler = acmeManager.HTTPHandler(handler)
+	}
+
+	listener, err := namedListener(redirectListenerName, fmt.Sprintf(":%d", port))
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	logrus.Infof("starting HTTP redirect server on %s",
+		describeListener(listener))
+	logrus.Error(newHTTPServer("", handler).Serve(listener))
+
+}
+
+// redirectHandler responds to HTTP requests with a permanent redirect to the
+// same resource over HTTPS.
+func redirectHandler(w http.ResponseWriter, r *http.Request) {
+
+	// strip the HTTP port from the request host
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
 	}
 
+	// add the HTTPS port to the host if it is not the default
+	if port := env.GetIntSafe(portVariable,
+		httpsDefaultPort); port != httpsDefaultPort {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(),
+		http.StatusMovedPermanently)
+
+}
+
+// newHTTPServer creates an HTTP server that listens on the supplied address
+// using the configured timeouts and limits.
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+		ConnContext:       withConn,
+	}
 }
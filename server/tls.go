@@ -0,0 +1,332 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// certReloadIntervalVariable defines the environment variable for the
+	// number of seconds between checks for a changed TLS certificate or key.
+	certReloadIntervalVariable = "WEB_APP_CERT_RELOAD_INTERVAL"
+	// acmeDomainsVariable defines the environment variable for the list of
+	// domains we should obtain certificates for through ACME. If set the server
+	// will be run using TLS encryption.
+	acmeDomainsVariable = "WEB_APP_ACME_DOMAINS"
+	// acmeEmailVariable defines the environment variable for the contact email
+	// address registered with the ACME certificate authority.
+	acmeEmailVariable = "WEB_APP_ACME_EMAIL"
+	// acmeCacheDirVariable defines the environment variable for the directory
+	// used to cache ACME account keys and certificates.
+	acmeCacheDirVariable = "WEB_APP_ACME_CACHE_DIR"
+	// acmeDirectoryURLVariable defines the environment variable for the ACME
+	// certificate authority directory URL.
+	acmeDirectoryURLVariable = "WEB_APP_ACME_DIRECTORY_URL"
+	// clientCAVariable defines the environment variable for the path to a PEM
+	// encoded bundle of certificate authorities used to verify client
+	// certificates.
+	clientCAVariable = "WEB_APP_CLIENT_CA"
+	// clientAuthVariable defines the environment variable for the client
+	// certificate policy.
+	clientAuthVariable = "WEB_APP_CLIENT_AUTH"
+	// clientAuthNone indicates the server does not request client
+	// certificates.
+	clientAuthNone = "none"
+	// clientAuthRequest indicates the server requests a client certificate
+	// and verifies it if one is supplied.
+	clientAuthRequest = "request"
+	// clientAuthRequire indicates the server requires every client to supply
+	// a valid certificate.
+	clientAuthRequire = "require"
+	// acmeCABundleVariable defines the environment variable for the path to a
+	// PEM encoded bundle of additional certificate authorities trusted when
+	// connecting to the ACME directory.
+	acmeCABundleVariable = "WEB_APP_ACME_CA_BUNDLE"
+)
+
+// certReloadInterval determines how often we check whether the TLS certificate
+// or key have changed.
+var certReloadInterval time.Duration
+
+// acmeDomains stores the list of domains we obtain certificates for through
+// ACME. ACME is disabled if the list is empty.
+var acmeDomains []string
+
+// acmeEmail stores the contact email address registered with the ACME
+// certificate authority.
+var acmeEmail string
+
+// acmeCacheDir stores the directory used to cache ACME account keys and
+// certificates.
+var acmeCacheDir string
+
+// acmeDirectoryURL stores the ACME certificate authority directory URL.
+var acmeDirectoryURL string
+
+// acmeCABundle stores the path to a bundle of additional certificate
+// authorities trusted when connecting to the ACME directory.
+var acmeCABundle string
+
+// clientCA stores the path to a bundle of certificate authorities used to
+// verify client certificates.
+var clientCA string
+
+// clientAuth stores the client certificate policy.
+var clientAuth string
+
+// acmeManager is used to obtain and renew certificates through ACME. This
+// value is nil if ACME is disabled.
+var acmeManager *autocert.Manager
+
+// newTLSConfig creates the TLS configuration used to run the server with TLS
+// encryption. If ACME is enabled certificates will be obtained automatically,
+// otherwise the certificate and key files will be loaded and reloaded whenever
+// they change.
+func newTLSConfig(cert, key string) (*tls.Config, error) {
+
+	tlsConfig, err := newServerCertConfig(cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := configureClientAuth(tlsConfig); err != nil {
+		return nil, err
+	}
+
+	return tlsConfig, nil
+
+}
+
+// newServerCertConfig creates a TLS configuration that serves either ACME
+// certificates or the certificate loaded from the supplied files.
+func newServerCertConfig(cert, key string) (*tls.Config, error) {
+
+	// check if we should obtain certificates through ACME
+	if len(acmeDomains) > 0 {
+
+		client, err := newACMEClient()
+		if err != nil {
+			return nil, err
+		}
+
+		acmeManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(acmeCacheDir),
+			HostPolicy: autocert.HostWhitelist(acmeDomains...),
+			Client:     client,
+			Email:      acmeEmail,
+		}
+
+		logrus.Infof("obtaining certificates through ACME from %s",
+			acmeDirectoryURL)
+
+		return acmeManager.TLSConfig(), nil
+
+	}
+
+	// load the certificate and key files and watch them for changes
+	reloader := &certReloader{
+		certFile: cert,
+		keyFile:  key,
+	}
+
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+
+	go reloader.watch(certReloadInterval)
+
+	return &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+
+}
+
+// configureClientAuth applies the configured client certificate policy to the
+// supplied TLS configuration.
+func configureClientAuth(tlsConfig *tls.Config) error {
+
+	if clientAuth == clientAuthNone {
+		return nil
+	}
+
+	if clientCA == "" {
+		return fmt.Errorf("client auth '%s' requires a client CA bundle",
+			clientAuth)
+	}
+
+	// load the certificate authorities used to verify client certificates
+	bundle, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return errors.New("no certificates found in client CA bundle")
+	}
+
+	tlsConfig.ClientCAs = pool
+
+	switch clientAuth {
+	case clientAuthRequest:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case clientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("invalid client auth '%s'", clientAuth)
+	}
+
+	logrus.Infof("client certificate auth enabled: %s", clientAuth)
+
+	return nil
+
+}
+
+// newACMEClient creates a client for the configured ACME certificate
+// authority.
+func newACMEClient() (*acme.Client, error) {
+
+	client := &acme.Client{
+		DirectoryURL: acmeDirectoryURL,
+	}
+
+	if acmeCABundle == "" {
+		return client, nil
+	}
+
+	// trust the supplied certificate authorities in addition to the system
+	// certificate authorities when connecting to the ACME directory
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	bundle, err := ioutil.ReadFile(acmeCABundle)
+	if err != nil {
+		return nil, err
+	}
+
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("no certificates found in ACME CA bundle")
+	}
+
+	client.HTTPClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	return client, nil
+
+}
+
+// certReloader serves a TLS certificate loaded from disk, reloading the
+// certificate whenever the certificate or key file changes. Connections that
+// are already established are unaffected by a reload.
+type certReloader struct {
+	certFile    string
+	keyFile     string
+	mutex       sync.RWMutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// GetCertificate returns the most recently loaded certificate.
+func (r *certReloader) GetCertificate(
+	hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.cert, nil
+
+}
+
+// load reads the certificate and key files from disk.
+func (r *certReloader) load() error {
+
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+
+	return nil
+
+}
+
+// changed checks whether the certificate or key file has been modified since
+// it was last loaded.
+func (r *certReloader) changed() bool {
+
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return !certInfo.ModTime().Equal(r.certModTime) ||
+		!keyInfo.ModTime().Equal(r.keyModTime)
+
+}
+
+// watch periodically checks the certificate and key files, reloading them if
+// they have changed. If the new files cannot be loaded the previous
+// certificate continues to be served.
+func (r *certReloader) watch(interval time.Duration) {
+
+	for range time.Tick(interval) {
+
+		if !r.changed() {
+			continue
+		}
+
+		if err := r.load(); err != nil {
+			logrus.Errorf("failed to reload TLS certificate: %v", err)
+			continue
+		}
+
+		logrus.Infof("reloaded TLS certificate %s", r.certFile)
+
+	}
+
+}
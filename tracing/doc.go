@@ -0,0 +1,31 @@
+// Package tracing records spans describing the work done to handle a request
+// so that slow requests can be broken down into the database queries, password
+// hashing, and email delivery they performed. Trace context is read from the
+// W3C traceparent header so that spans join traces started by clients or
+// upstream services, and finished spans are exported to stdout or to an
+// OpenTelemetry collector using OTLP over HTTP.
+//
+// Spans are started with Start using a context that carries the parent span,
+// such as the gin context of a request. The ids of the current trace and span
+// are added to log entries created with logrus.WithContext.
+//
+// Environment:
+//     WEB_APP_TRACING_EXPORTER
+//         string - where finished spans are exported; one of none, stdout, or
+//                  otlp. Spans are not recorded when set to none.
+//                  Default: none
+//     WEB_APP_TRACING_OTLP_ENDPOINT
+//         string - the URL of the OTLP/HTTP traces endpoint of an
+//                  OpenTelemetry collector.
+//                  Default: http://localhost:4318/v1/traces
+//     WEB_APP_TRACING_SERVICE_NAME
+//         string - the service name reported with exported spans.
+//                  Default: web-app
+//     WEB_APP_TRACING_SAMPLE_RATIO
+//         float - the fraction of new traces that are recorded. Traces started
+//                 by a caller follow the caller's sampling decision.
+//                 Default: 1
+//     WEB_APP_TRACING_EXPORT_INTERVAL
+//         int - the number of seconds between exports of finished spans.
+//               Default: 5
+package tracing
@@ -0,0 +1,362 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// queueSize is the number of finished spans that may wait to be exported.
+	// Spans are dropped while the queue is full.
+	queueSize = 2048
+	// batchSize is the maximum number of spans exported at once.
+	batchSize = 512
+	// exportTimeout is the maximum duration of a request to an OTLP endpoint.
+	exportTimeout = 10 * time.Second
+)
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// exportInterval determines how often finished spans are exported.
+var exportInterval time.Duration
+
+// processor stores the exporter and the finished spans waiting to be exported.
+var processor = struct {
+	mutex    *sync.RWMutex
+	exporter Exporter
+	queue    chan *Span
+	flush    chan chan struct{}
+}{
+	mutex: &sync.RWMutex{},
+	queue: make(chan *Span, queueSize),
+	flush: make(chan chan struct{}),
+}
+
+// SetExporter replaces the exporter finished spans are sent to. Spans are only
+// recorded once an exporter has been set.
+func SetExporter(exporter Exporter) {
+
+	processor.mutex.Lock()
+	defer processor.mutex.Unlock()
+
+	if processor.exporter == nil && exporter != nil {
+		go exportSpans()
+	}
+
+	processor.exporter = exporter
+
+}
+
+// Flush exports all finished spans waiting to be exported. This should be
+// called before the application exits.
+func Flush() {
+
+	if !exporting() {
+		return
+	}
+
+	done := make(chan struct{})
+	processor.flush <- done
+	<-done
+
+}
+
+// exporting checks whether an exporter has been set.
+func exporting() bool {
+
+	processor.mutex.RLock()
+	defer processor.mutex.RUnlock()
+
+	return processor.exporter != nil
+
+}
+
+// enqueue queues a finished span to be exported.
+func enqueue(span *Span) {
+	select {
+	case processor.queue <- span:
+	default:
+		logrus.Debugf("span queue is full, dropping span '%s'", span.Name)
+	}
+}
+
+// exportSpans exports finished spans in batches each export interval, or as
+// soon as a full batch is waiting.
+func exportSpans() {
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+
+	export := func() {
+
+		if len(batch) == 0 {
+			return
+		}
+
+		processor.mutex.RLock()
+		exporter := processor.exporter
+		processor.mutex.RUnlock()
+
+		if err := exporter.Export(batch); err != nil {
+			logrus.Errorf("failed to export %d spans: %v", len(batch), err)
+		}
+
+		batch = make([]*Span, 0, batchSize)
+
+	}
+
+	for {
+		select {
+		case span := <-processor.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case done := <-processor.flush:
+			for len(processor.queue) > 0 {
+				batch = append(batch, <-processor.queue)
+				if len(batch) >= batchSize {
+					export()
+				}
+			}
+			export()
+			close(done)
+		}
+	}
+
+}
+
+// StdoutExporter writes each span as a line of JSON.
+type StdoutExporter struct {
+	Writer io.Writer // where spans are written, defaults to stdout
+}
+
+// stdoutSpan is used to format a span written by the stdout exporter.
+type stdoutSpan struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	DurationMS float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Export writes the supplied spans.
+func (e *StdoutExporter) Export(spans []*Span) error {
+
+	w := e.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+
+	encoder := json.NewEncoder(w)
+
+	for _, span := range spans {
+
+		item := stdoutSpan{
+			TraceID: span.Context.TraceID.String(),
+			SpanID:  span.Context.SpanID.String(),
+			Name:    span.Name,
+			Start:   span.StartTime,
+			DurationMS: float64(span.EndTime.Sub(span.StartTime)) /
+				float64(time.Millisecond),
+			Attributes: span.Attributes,
+		}
+
+		if span.Parent.IsValid() {
+			item.ParentID = span.Parent.String()
+		}
+
+		if span.Error {
+			item.Error = span.Description
+		}
+
+		if err := encoder.Encode(item); err != nil {
+			return err
+		}
+
+	}
+
+	return nil
+
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector using the OTLP/HTTP
+// JSON encoding.
+type OTLPExporter struct {
+	Endpoint    string       // URL of the collector traces endpoint
+	ServiceName string       // service name reported with the spans
+	Client      *http.Client // client used to post spans, defaults to a client with a timeout
+}
+
+// otlpRequest is used to format an OTLP/HTTP trace export request.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// otlpResourceSpans groups the spans reported by a service.
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+// otlpResource describes the service that reported spans.
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+// otlpScopeSpans groups the spans recorded by an instrumentation scope.
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+// otlpScope identifies the instrumentation that recorded spans.
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+// otlpSpan is used to format a span in an OTLP/HTTP export request.
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// otlpAttribute is used to format a key value pair in an OTLP/HTTP export
+// request.
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// otlpStatus is used to format the status of a span in an OTLP/HTTP export
+// request.
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Export posts the supplied spans to the collector.
+func (e *OTLPExporter) Export(spans []*Span) error {
+
+	scope := otlpScopeSpans{
+		Scope: otlpScope{Name: "web-app/tracing"},
+		Spans: make([]otlpSpan, 0, len(spans)),
+	}
+
+	for _, span := range spans {
+
+		item := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			TraceState:        span.Context.TraceState,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		}
+
+		if span.Parent.IsValid() {
+			item.ParentSpanID = span.Parent.String()
+		}
+
+		for key, value := range span.Attributes {
+			item.Attributes = append(item.Attributes, otlpAttributeOf(key, value))
+		}
+
+		// status codes are unset (0), ok (1), and error (2)
+		if span.Error {
+			item.Status = otlpStatus{Code: 2, Message: span.Description}
+		}
+
+		scope.Spans = append(scope.Spans, item)
+
+	}
+
+	body, err := json.Marshal(otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{
+					otlpAttributeOf("service.name", e.ServiceName),
+				},
+			},
+			ScopeSpans: []otlpScopeSpans{scope},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: exportTimeout}
+	}
+
+	resp, err := client.Post(e.Endpoint, "application/json",
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("OTLP endpoint responded with status %d",
+			resp.StatusCode)
+	}
+
+	return nil
+
+}
+
+// otlpAttributeOf formats a key value pair using the OTLP value type matching
+// the type of the value.
+func otlpAttributeOf(key string, value interface{}) otlpAttribute {
+
+	var v map[string]interface{}
+
+	switch value := value.(type) {
+	case string:
+		v = map[string]interface{}{"stringValue": value}
+	case bool:
+		v = map[string]interface{}{"boolValue": value}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(int64(value), 10)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case uint:
+		v = map[string]interface{}{"intValue": strconv.FormatUint(uint64(value), 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": value}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+	}
+
+	return otlpAttribute{Key: key, Value: v}
+
+}
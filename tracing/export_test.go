@@ -0,0 +1,269 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is an OTLP/HTTP collector stand-in that records the export
+// requests it receives.
+type collector struct {
+	mutex    *sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   []otlpRequest
+}
+
+// newCollector starts a collector that responds with the supplied status.
+func newCollector(status int) (*collector, *httptest.Server) {
+
+	c := &collector{mutex: &sync.Mutex{}, status: status}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+
+		var body otlpRequest
+		raw, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(raw, &body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		c.mutex.Lock()
+		c.requests = append(c.requests, r)
+		c.bodies = append(c.bodies, body)
+		c.mutex.Unlock()
+
+		w.WriteHeader(c.status)
+
+	}))
+
+	return c, server
+
+}
+
+// spans gets every span received by the collector.
+func (c *collector) spans() []otlpSpan {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var spans []otlpSpan
+	for _, body := range c.bodies {
+		for _, resource := range body.ResourceSpans {
+			for _, scope := range resource.ScopeSpans {
+				spans = append(spans, scope.Spans...)
+			}
+		}
+	}
+
+	return spans
+
+}
+
+// testSpan creates a finished, sampled span.
+func testSpan(name string) *Span {
+
+	start := time.Unix(1600000000, 123)
+
+	return &Span{
+		mutex: &sync.Mutex{},
+		Name:  name,
+		Kind:  SpanKindServer,
+		Context: SpanContext{
+			TraceID:    TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			SpanID:     SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+			Sampled:    true,
+			TraceState: "vendor=value",
+		},
+		Parent:    SpanID{8, 7, 6, 5, 4, 3, 2, 1},
+		StartTime: start,
+		EndTime:   start.Add(time.Millisecond),
+		Attributes: map[string]interface{}{
+			"http.method": "GET",
+			"http.status": 500,
+			"cache.hit":   false,
+			"ratio":       0.5,
+		},
+		Error:       true,
+		Description: "internal error",
+	}
+
+}
+
+func TestOTLPExporterExport(t *testing.T) {
+
+	c, server := newCollector(http.StatusOK)
+	defer server.Close()
+
+	exporter := &OTLPExporter{
+		Endpoint:    server.URL + "/v1/traces",
+		ServiceName: "test-service",
+	}
+
+	if err := exporter.Export([]*Span{testSpan("GET /users")}); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	if len(c.requests) != 1 {
+		t.Fatalf("collector received %d requests, want 1", len(c.requests))
+	}
+
+	r := c.requests[0]
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+		t.Errorf("request = %s %s, want POST /v1/traces", r.Method, r.URL.Path)
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+
+	resource := c.bodies[0].ResourceSpans[0].Resource
+	if len(resource.Attributes) != 1 ||
+		resource.Attributes[0].Key != "service.name" ||
+		resource.Attributes[0].Value["stringValue"] != "test-service" {
+		t.Errorf("resource attributes = %v, want service.name test-service",
+			resource.Attributes)
+	}
+
+	spans := c.spans()
+	if len(spans) != 1 {
+		t.Fatalf("collector received %d spans, want 1", len(spans))
+	}
+
+	span := spans[0]
+	want := otlpSpan{
+		TraceID:           "0102030405060708090a0b0c0d0e0f10",
+		SpanID:            "0102030405060708",
+		ParentSpanID:      "0807060504030201",
+		TraceState:        "vendor=value",
+		Name:              "GET /users",
+		Kind:              SpanKindServer,
+		StartTimeUnixNano: "1600000000000000123",
+		EndTimeUnixNano:   "1600000000001000123",
+		Status:            otlpStatus{Code: 2, Message: "internal error"},
+	}
+	got := span
+	got.Attributes = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("span = %+v, want %+v", got, want)
+	}
+
+	attributes := map[string]map[string]interface{}{}
+	for _, attribute := range span.Attributes {
+		attributes[attribute.Key] = attribute.Value
+	}
+
+	for key, value := range map[string]map[string]interface{}{
+		"http.method": {"stringValue": "GET"},
+		"http.status": {"intValue": "500"},
+		"cache.hit":   {"boolValue": false},
+		"ratio":       {"doubleValue": 0.5},
+	} {
+		raw, _ := json.Marshal(attributes[key])
+		expected, _ := json.Marshal(value)
+		if !bytes.Equal(raw, expected) {
+			t.Errorf("attribute %s = %s, want %s", key, raw, expected)
+		}
+	}
+
+}
+
+func TestOTLPExporterStatus(t *testing.T) {
+
+	_, server := newCollector(http.StatusServiceUnavailable)
+	defer server.Close()
+
+	exporter := &OTLPExporter{Endpoint: server.URL}
+
+	if err := exporter.Export([]*Span{testSpan("GET /users")}); err == nil {
+		t.Error("Export() error = nil, want error for status 503")
+	}
+
+}
+
+func TestStdoutExporterExport(t *testing.T) {
+
+	var buffer bytes.Buffer
+	exporter := &StdoutExporter{Writer: &buffer}
+
+	if err := exporter.Export([]*Span{testSpan("a"), testSpan("b")}); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	decoder := json.NewDecoder(&buffer)
+	for _, name := range []string{"a", "b"} {
+
+		var item stdoutSpan
+		if err := decoder.Decode(&item); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+
+		if item.Name != name || item.ParentID != "0807060504030201" ||
+			item.DurationMS != 1 || item.Error != "internal error" {
+			t.Errorf("span = %+v, want %s with parent, 1ms, and error", item, name)
+		}
+
+	}
+
+}
+
+func TestSpanExportedOnFlush(t *testing.T) {
+
+	c, server := newCollector(http.StatusOK)
+	defer server.Close()
+
+	SetExporter(&OTLPExporter{Endpoint: server.URL})
+	defer SetExporter(nil)
+
+	ctx, parent := StartKind(context.Background(), "parent", SpanKindServer)
+	_, child := Start(ctx, "child")
+	child.SetAttribute("attempt", 1)
+	child.RecordError(errors.New("failed"))
+	child.End()
+	parent.End()
+
+	// ending a span twice must not export it twice
+	parent.End()
+
+	Flush()
+
+	spans := c.spans()
+	if len(spans) != 2 {
+		t.Fatalf("collector received %d spans, want 2", len(spans))
+	}
+
+	byName := map[string]otlpSpan{}
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+
+	p, ch := byName["parent"], byName["child"]
+	if p.TraceID != ch.TraceID {
+		t.Errorf("child trace id = %s, want %s", ch.TraceID, p.TraceID)
+	}
+	if ch.ParentSpanID != p.SpanID || p.ParentSpanID != "" {
+		t.Errorf("child parent = %s, want %s", ch.ParentSpanID, p.SpanID)
+	}
+	if p.Kind != SpanKindServer || ch.Kind != SpanKindInternal {
+		t.Errorf("kinds = %d, %d, want %d, %d", p.Kind, ch.Kind,
+			SpanKindServer, SpanKindInternal)
+	}
+	if ch.Status.Code != 2 || ch.Status.Message != "failed" {
+		t.Errorf("child status = %+v, want error failed", ch.Status)
+	}
+	if len(ch.Attributes) != 1 || ch.Attributes[0].Key != "attempt" ||
+		ch.Attributes[0].Value["intValue"] != strconv.Itoa(1) {
+		t.Errorf("child attributes = %v, want attempt 1", ch.Attributes)
+	}
+
+}
@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"errors"
+
+	"web-app/data"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// init registers database callbacks that record a span for each query.
+func init() {
+
+	callbacks := data.DB().Callback()
+
+	for _, err := range []error{
+		callbacks.Create().Before("gorm:create").
+			Register("tracing:before_create", startQuery("create")),
+		callbacks.Create().After("gorm:create").
+			Register("tracing:after_create", endQuery),
+		callbacks.Query().Before("gorm:query").
+			Register("tracing:before_query", startQuery("query")),
+		callbacks.Query().After("gorm:query").
+			Register("tracing:after_query", endQuery),
+		callbacks.Update().Before("gorm:update").
+			Register("tracing:before_update", startQuery("update")),
+		callbacks.Update().After("gorm:update").
+			Register("tracing:after_update", endQuery),
+		callbacks.Delete().Before("gorm:delete").
+			Register("tracing:before_delete", startQuery("delete")),
+		callbacks.Delete().After("gorm:delete").
+			Register("tracing:after_delete", endQuery),
+		callbacks.Row().Before("gorm:row").
+			Register("tracing:before_row", startQuery("row")),
+		callbacks.Row().After("gorm:row").
+			Register("tracing:after_row", endQuery),
+		callbacks.Raw().Before("gorm:raw").
+			Register("tracing:before_raw", startQuery("raw")),
+		callbacks.Raw().After("gorm:raw").
+			Register("tracing:after_raw", endQuery),
+	} {
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+}
+
+const (
+	// gormSpanKey is the statement setting used to store the span of a query.
+	gormSpanKey = "tracing:span"
+)
+
+// startQuery gets a database callback that starts a span for the specified
+// operation. Queries are only recorded if they are made with a context that
+// carries a sampled span, such as the context of a traced request.
+func startQuery(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+
+		ctx := db.Statement.Context
+		if ctx == nil {
+			return
+		}
+
+		parent := SpanFromContext(ctx)
+		if parent == nil || !parent.Context.Sampled {
+			return
+		}
+
+		_, span := StartKind(ctx, "gorm."+operation, SpanKindClient)
+		db.InstanceSet(gormSpanKey, span)
+
+	}
+}
+
+// endQuery is a database callback that ends the span of a query.
+func endQuery(db *gorm.DB) {
+
+	value, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+
+	span, ok := value.(*Span)
+	if !ok {
+		return
+	}
+
+	span.SetAttribute("db.system", db.Dialector.Name())
+	span.SetAttribute("db.statement", db.Statement.SQL.String())
+	span.SetAttribute("db.sql.table", db.Statement.Table)
+	span.SetAttribute("db.rows_affected", db.RowsAffected)
+
+	// a missing record is an expected outcome rather than a failed query
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+	}
+
+	span.End()
+
+}
@@ -0,0 +1,33 @@
+package tracing
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// LogHook adds the ids of the current trace and span to log entries created
+// with logrus.WithContext using a context that carries a span.
+type LogHook struct{}
+
+// Levels gets the log levels the hook applies to.
+func (h *LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire adds the trace and span ids to the supplied log entry.
+func (h *LogHook) Fire(entry *logrus.Entry) error {
+
+	if entry.Context == nil {
+		return nil
+	}
+
+	span := SpanFromContext(entry.Context)
+	if span == nil {
+		return nil
+	}
+
+	entry.Data["trace_id"] = span.Context.TraceID.String()
+	entry.Data["span_id"] = span.Context.SpanID.String()
+
+	return nil
+
+}
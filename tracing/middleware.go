@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware gets middleware that records a span for each request. The span
+// joins the caller's trace if the request has a traceparent header and is
+// stored in the request context so that it is the parent of spans started with
+// the gin context. This should be bound before recovery middleware so that
+// panics are recorded as server errors.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		name := c.Request.Method
+		if route := c.FullPath(); route != "" {
+			name += " " + route
+		}
+
+		ctx, span := StartKind(
+			Extract(c.Request.Context(), c.Request.Header), name, SpanKindServer)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.route", c.FullPath())
+		span.SetAttribute("http.target", c.Request.URL.Path)
+		span.SetAttribute("http.client_ip", c.ClientIP())
+		span.SetAttribute("http.user_agent", c.Request.UserAgent())
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttribute("http.status_code", status)
+
+		if status >= http.StatusInternalServerError {
+			if err := c.Errors.Last(); err != nil {
+				span.RecordError(err)
+			} else {
+				span.RecordError(errors.New(http.StatusText(status)))
+			}
+		}
+
+	}
+}
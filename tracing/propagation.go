@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	// traceparentHeader is the W3C trace context header that identifies the
+	// caller's span.
+	traceparentHeader = "traceparent"
+	// tracestateHeader is the W3C trace context header that carries vendor
+	// specific trace state.
+	tracestateHeader = "tracestate"
+	// sampledFlag is the trace flag that indicates the caller recorded the
+	// trace.
+	sampledFlag = 0x01
+)
+
+// Extract returns a copy of the supplied context that carries the span context
+// read from the trace context headers of a request. Spans started with the
+// returned context are children of the caller's span. The context is returned
+// unchanged if the headers are missing or invalid.
+func Extract(ctx context.Context, header http.Header) context.Context {
+
+	sc, ok := parseTraceparent(header.Get(traceparentHeader))
+	if !ok {
+		return ctx
+	}
+
+	sc.TraceState = header.Get(tracestateHeader)
+
+	// the caller's sampling decision is ignored when spans are not exported
+	sc.Sampled = sc.Sampled && exporting()
+
+	return context.WithValue(ctx, remoteContextKey{}, sc)
+
+}
+
+// Inject writes the trace context headers identifying the span carried by the
+// supplied context so that a remote service can continue the trace.
+func Inject(ctx context.Context, header http.Header) {
+
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+
+	header.Set(traceparentHeader, formatTraceparent(span.Context))
+	if span.Context.TraceState != "" {
+		header.Set(tracestateHeader, span.Context.TraceState)
+	}
+
+}
+
+// parseTraceparent parses the value of a traceparent header. Returns false if
+// the value is not valid.
+func parseTraceparent(value string) (SpanContext, bool) {
+
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 ||
+		len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+
+	// version ff is forbidden and version 00 has exactly four fields
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || version[0] == 0xff ||
+		(version[0] == 0 && len(parts) != 4) {
+		return sc, false
+	}
+
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil ||
+		parts[1] != strings.ToLower(parts[1]) {
+		return sc, false
+	}
+
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil ||
+		parts[2] != strings.ToLower(parts[2]) {
+		return sc, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&sampledFlag != 0
+
+	return sc, sc.IsValid()
+
+}
+
+// formatTraceparent formats the supplied span context as the value of a
+// traceparent header.
+func formatTraceparent(sc SpanContext) string {
+
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+
+}
@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseTraceparent(t *testing.T) {
+
+	tests := []struct {
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true, true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", true, false},
+		{"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", true, true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", false, false},
+		{"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false, false},
+		{"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01", false, false},
+		{"00-00000000000000000000000000000000-b7ad6b7169203331-01", false, false},
+		{"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01", false, false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331", false, false},
+		{"", false, false},
+	}
+
+	for _, test := range tests {
+
+		sc, ok := parseTraceparent(test.value)
+		if ok != test.valid {
+			t.Errorf("parseTraceparent(%q) valid = %v, want %v", test.value, ok,
+				test.valid)
+			continue
+		}
+
+		if ok && sc.Sampled != test.sampled {
+			t.Errorf("parseTraceparent(%q) sampled = %v, want %v", test.value,
+				sc.Sampled, test.sampled)
+		}
+
+	}
+
+}
+
+func TestExtractInject(t *testing.T) {
+
+	SetExporter(&StdoutExporter{Writer: &bytes.Buffer{}})
+	defer SetExporter(nil)
+
+	incoming := http.Header{}
+	incoming.Set(traceparentHeader,
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	incoming.Set(tracestateHeader, "vendor=value")
+
+	ctx, span := Start(Extract(context.Background(), incoming), "request")
+	defer span.End()
+
+	if span.Context.TraceID.String() != "0af7651916cd43dd8448eb211c80319c" ||
+		span.Parent.String() != "b7ad6b7169203331" || !span.Context.Sampled {
+		t.Fatalf("span = %+v, want child of the caller's span", span.Context)
+	}
+
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+
+	want := "00-0af7651916cd43dd8448eb211c80319c-" + span.Context.SpanID.String() +
+		"-01"
+	if got := outgoing.Get(traceparentHeader); got != want {
+		t.Errorf("traceparent = %q, want %q", got, want)
+	}
+	if got := outgoing.Get(tracestateHeader); got != "vendor=value" {
+		t.Errorf("tracestate = %q, want vendor=value", got)
+	}
+
+}
+
+func TestLogHookGinContext(t *testing.T) {
+
+	var buffer bytes.Buffer
+
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetOutput(&buffer)
+	logger.AddHook(&LogHook{})
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	ctx, span := Start(c.Request.Context(), "request")
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	logger.WithContext(c).Info("handled")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buffer.Bytes(), &entry); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if entry["trace_id"] != span.Context.TraceID.String() ||
+		entry["span_id"] != span.Context.SpanID.String() {
+		t.Errorf("log entry = %v, want trace and span ids of %+v", entry,
+			span.Context)
+	}
+
+}
@@ -0,0 +1,297 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"web-app/env"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// init configures span sampling and export from the environment.
+func init() {
+
+	sampleRatio = env.GetFloat64Safe(sampleRatioVariable, 1)
+	exportInterval = time.Duration(
+		env.GetIntSafe(exportIntervalVariable, 5)) * time.Second
+
+	switch exporter := env.GetStringSafe(exporterVariable, "none"); exporter {
+	case "none":
+	case "stdout":
+		SetExporter(&StdoutExporter{})
+	case "otlp":
+		SetExporter(&OTLPExporter{
+			Endpoint: env.GetStringSafe(otlpEndpointVariable,
+				"http://localhost:4318/v1/traces"),
+			ServiceName: env.GetStringSafe(serviceNameVariable, "web-app"),
+		})
+	default:
+		logrus.Fatalf("invalid tracing exporter '%s'", exporter)
+	}
+
+	// add trace ids to log entries
+	logrus.AddHook(&LogHook{})
+
+}
+
+const (
+	// exporterVariable defines the environment variable for where finished
+	// spans are exported.
+	exporterVariable = "WEB_APP_TRACING_EXPORTER"
+	// otlpEndpointVariable defines the environment variable for the URL of the
+	// OTLP/HTTP traces endpoint.
+	otlpEndpointVariable = "WEB_APP_TRACING_OTLP_ENDPOINT"
+	// serviceNameVariable defines the environment variable for the service
+	// name reported with exported spans.
+	serviceNameVariable = "WEB_APP_TRACING_SERVICE_NAME"
+	// sampleRatioVariable defines the environment variable for the fraction of
+	// new traces that are recorded.
+	sampleRatioVariable = "WEB_APP_TRACING_SAMPLE_RATIO"
+	// exportIntervalVariable defines the environment variable for the number
+	// of seconds between exports of finished spans.
+	exportIntervalVariable = "WEB_APP_TRACING_EXPORT_INTERVAL"
+)
+
+// spanContextKey is the context key used to store the current span.
+type spanContextKey struct{}
+
+// remoteContextKey is the context key used to store the span context received
+// from a caller.
+type remoteContextKey struct{}
+
+// sampleRatio determines the fraction of new traces that are recorded.
+var sampleRatio float64
+
+// TraceID uniquely identifies a trace.
+type TraceID [16]byte
+
+// String formats the trace id as lowercase hex.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid checks that the trace id is not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID uniquely identifies a span within a trace.
+type SpanID [8]byte
+
+// String formats the span id as lowercase hex.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid checks that the span id is not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext identifies a span and carries the state propagated to its
+// children.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid checks that the span context has a trace and span id.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// SpanKind describes the relationship between a span and its caller.
+type SpanKind int
+
+const (
+	// SpanKindInternal indicates an operation within the application.
+	SpanKindInternal SpanKind = 1
+	// SpanKindServer indicates the handling of a request from a client.
+	SpanKindServer SpanKind = 2
+	// SpanKindClient indicates a request made to a remote service.
+	SpanKindClient SpanKind = 3
+)
+
+// Span records a single operation within a trace. A span that is not sampled
+// still carries its span context so that it is propagated but is never
+// exported.
+type Span struct {
+	mutex *sync.Mutex
+
+	Name        string
+	Kind        SpanKind
+	Context     SpanContext
+	Parent      SpanID
+	StartTime   time.Time
+	EndTime     time.Time
+	Attributes  map[string]interface{}
+	Error       bool
+	Description string
+}
+
+// Start starts a span that is a child of the span carried by the supplied
+// context, or of the caller's span if the context was extracted from a request.
+// A new trace is started if the context carries neither. The returned context
+// carries the new span and must be used to start its children. The span must
+// be ended by calling End.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartKind(ctx, name, SpanKindInternal)
+}
+
+// StartKind starts a span of the specified kind. See Start.
+func StartKind(ctx context.Context, name string,
+	kind SpanKind) (context.Context, *Span) {
+
+	span := &Span{
+		mutex:      &sync.Mutex{},
+		Name:       name,
+		Kind:       kind,
+		StartTime:  time.Now(),
+		Attributes: map[string]interface{}{},
+	}
+
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.Context = parent.Context
+		span.Parent = parent.Context.SpanID
+	} else if remote, ok := valueOf(ctx,
+		remoteContextKey{}).(SpanContext); ok && remote.IsValid() {
+		span.Context = remote
+		span.Parent = remote.SpanID
+	} else {
+		span.Context.TraceID = newTraceID()
+		span.Context.Sampled = exporting() && sample(span.Context.TraceID)
+	}
+
+	span.Context.SpanID = newSpanID()
+
+	return context.WithValue(ctx, spanContextKey{}, span), span
+
+}
+
+// SpanFromContext retrieves the current span from the supplied context.
+// Returns nil if the context does not carry a span.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := valueOf(ctx, spanContextKey{}).(*Span)
+	return span
+}
+
+// SetAttribute records a key value pair describing the operation. Values
+// should be strings, numbers, or bools.
+func (s *Span) SetAttribute(key string, value interface{}) {
+
+	if s == nil || !s.Context.Sampled {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// ended spans may already be being exported
+	if !s.EndTime.IsZero() {
+		return
+	}
+
+	s.Attributes[key] = value
+
+}
+
+// RecordError marks the span as failed with the supplied error. Nil errors are
+// ignored.
+func (s *Span) RecordError(err error) {
+
+	if s == nil || err == nil || !s.Context.Sampled {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.EndTime.IsZero() {
+		return
+	}
+
+	s.Error = true
+	s.Description = err.Error()
+
+}
+
+// End completes the span and queues it for export if it is sampled. Calling
+// End more than once has no effect.
+func (s *Span) End() {
+
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	if !s.EndTime.IsZero() {
+		s.mutex.Unlock()
+		return
+	}
+	s.EndTime = time.Now()
+	s.mutex.Unlock()
+
+	if s.Context.Sampled {
+		enqueue(s)
+	}
+
+}
+
+// valueOf retrieves a value from the supplied context. Values of a gin context
+// are read from the context of its request.
+func valueOf(ctx context.Context, key interface{}) interface{} {
+
+	if c, ok := ctx.(*gin.Context); ok {
+		if c.Request == nil {
+			return nil
+		}
+		return c.Request.Context().Value(key)
+	}
+
+	return ctx.Value(key)
+
+}
+
+// sample determines whether a new trace is recorded. The decision is based on
+// the trace id so it is the same wherever it is made.
+func sample(id TraceID) bool {
+
+	if sampleRatio >= 1 {
+		return true
+	} else if sampleRatio <= 0 {
+		return false
+	}
+
+	bound := uint64(sampleRatio * (1 << 63))
+	return binary.BigEndian.Uint64(id[8:])>>1 < bound
+
+}
+
+// newTraceID generates a random trace id.
+func newTraceID() TraceID {
+	var id TraceID
+	if _, err := rand.Read(id[:]); err != nil || !id.IsValid() {
+		binary.BigEndian.PutUint64(id[8:], uint64(time.Now().UnixNano()))
+	}
+	return id
+}
+
+// newSpanID generates a random span id.
+func newSpanID() SpanID {
+	var id SpanID
+	if _, err := rand.Read(id[:]); err != nil || !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], uint64(time.Now().UnixNano()))
+	}
+	return id
+}
@@ -0,0 +1,261 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"web-app/cache"
+	"web-app/data"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// authStateKeyPrefix is prepended to a user id to form the cache key of
+	// the user auth state.
+	authStateKeyPrefix = "user:auth:"
+	// permissionsKeyPrefix is prepended to a user id to form the cache key of
+	// the permissions resolved for the user.
+	permissionsKeyPrefix = "user:permissions:"
+	// permissionsTag is the cache tag of every resolved permission set. It is
+	// invalidated when any permission changes.
+	permissionsTag = "permissions"
+)
+
+// authState stores the details of a user needed to authorize requests. Auth
+// states are cached so that requests can be authorized without loading the
+// user record. The cached auth state is removed when the user, their roles, or
+// any permission changes so the permission version is always current.
+type authState struct {
+	UserID            uint       `json:"user_id"`
+	Admin             bool       `json:"admin"`
+	LoggedOutAt       *time.Time `json:"logged_out_at"`
+	PermissionVersion uint       `json:"permission_version"`
+}
+
+// authStateOf gets the auth state of the supplied user.
+func authStateOf(u *User) *authState {
+	return &authState{
+		UserID:            u.ID,
+		Admin:             u.Admin,
+		LoggedOutAt:       u.LoggedOutAt,
+		PermissionVersion: u.PermissionVersion,
+	}
+}
+
+// getAuthState retrieves the auth state of the specified user, loading the user
+// record if the auth state is not cached.
+func getAuthState(ctx context.Context, userID uint) (*authState, error) {
+
+	key := fmt.Sprintf("%s%d", authStateKeyPrefix, userID)
+
+	var state authState
+	if getCached(ctx, key, &state) {
+		return &state, nil
+	}
+
+	u, err := GetUserByID(ctx, data.DB(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the permission version changes with the roles of the user so the auth
+	// state is removed along with the resolved permissions
+	roleIDs, err := listRoleIDByUser(ctx, data.DB(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{userTag(userID), permissionsTag}
+	for _, roleID := range roleIDs {
+		tags = append(tags, roleTag(roleID))
+	}
+
+	setCached(ctx, key, authStateOf(u), tags...)
+
+	return authStateOf(u), nil
+
+}
+
+// getResolvedPermissions retrieves every permission associated with the
+// specified user and the user's assigned roles, resolving the permissions if
+// they are not cached. The cached permissions are removed when the user, their
+// roles, or any permission changes.
+func getResolvedPermissions(ctx context.Context,
+	userID uint) ([]*Permission, error) {
+
+	key := fmt.Sprintf("%s%d", permissionsKeyPrefix, userID)
+
+	var results []*Permission
+	if getCached(ctx, key, &results) {
+		return results, nil
+	}
+
+	// retrieve permissions directly associated with the user
+	results, err := ListPermissionByUser(ctx, data.DB(), userID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// retrieve roles associated with the user
+	roles, err := ListRoleByUser(ctx, data.DB(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep track of permissions we have already added
+	added := map[string]struct{}{}
+	for _, permission := range results {
+		added[permission.Key] = struct{}{}
+	}
+
+	tags := []string{userTag(userID), permissionsTag}
+
+	// retrieve permissions associated with the user roles
+	for _, role := range roles {
+		permissions, err := ListPermissionByRole(ctx, data.DB(), role.ID, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < len(permissions); i++ {
+			if _, ok := added[permissions[i].Key]; !ok {
+				results = append(results, permissions[i])
+				added[permissions[i].Key] = struct{}{}
+			}
+		}
+
+		tags = append(tags, roleTag(role.ID))
+	}
+
+	setCached(ctx, key, results, tags...)
+
+	return results, nil
+
+}
+
+// getCached decodes the value cached under the supplied key into the supplied
+// value. Returns whether a value was found. Cache errors are logged and treated
+// as a cache miss.
+func getCached(ctx context.Context, key string, v interface{}) bool {
+
+	if permissionCacheTTL <= 0 {
+		return false
+	}
+
+	value, ok, err := cache.Get(key)
+	if err != nil {
+		logrus.WithContext(ctx).Error(err)
+		return false
+	} else if !ok {
+		return false
+	}
+
+	if err := json.Unmarshal(value, v); err != nil {
+		logrus.WithContext(ctx).Error(err)
+		return false
+	}
+
+	return true
+
+}
+
+// setCached encodes and caches the supplied value with the supplied tags.
+// Cache errors are logged.
+func setCached(ctx context.Context, key string, v interface{},
+	tags ...string) {
+
+	if permissionCacheTTL <= 0 {
+		return
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		logrus.WithContext(ctx).Error(err)
+		return
+	}
+
+	if err := cache.SetWithTags(key, value, permissionCacheTTL,
+		tags...); err != nil {
+		logrus.WithContext(ctx).Error(err)
+	}
+
+}
+
+// InvalidateUser removes the cached auth state and permissions of the
+// specified user. Functions that change the user within a transaction passed
+// by the caller leave the cache alone, since a concurrent request could cache
+// data read before the transaction commits, and InvalidateUser must be called
+// once it has committed. Cache errors are logged as the change has already
+// been made.
+func InvalidateUser(ctx context.Context, userID uint) {
+	invalidateTag(ctx, userTag(userID))
+}
+
+// InvalidateRole removes the cached permissions of every user with the
+// specified role. Like InvalidateUser it must be called once a transaction
+// that changed the role commits.
+func InvalidateRole(ctx context.Context, roleID uint) {
+	invalidateTag(ctx, roleTag(roleID))
+}
+
+// InvalidatePermissions removes the cached permissions of every user. Like
+// InvalidateUser it must be called once a transaction that changed a
+// permission commits.
+func InvalidatePermissions(ctx context.Context) {
+	invalidateTag(ctx, permissionsTag)
+}
+
+// invalidateUser invalidates the cached state of the specified user after a
+// change made with the supplied database handle, unless the handle is a
+// transaction of the caller.
+func invalidateUser(ctx context.Context, db *gorm.DB, userID uint) {
+	if !inTransaction(db) {
+		InvalidateUser(ctx, userID)
+	}
+}
+
+// invalidateRole invalidates the cached permissions of the users with the
+// specified role after a change made with the supplied database handle,
+// unless the handle is a transaction of the caller.
+func invalidateRole(ctx context.Context, db *gorm.DB, roleID uint) {
+	if !inTransaction(db) {
+		InvalidateRole(ctx, roleID)
+	}
+}
+
+// invalidatePermissions invalidates the cached permissions of every user after
+// a change made with the supplied database handle, unless the handle is a
+// transaction of the caller.
+func invalidatePermissions(ctx context.Context, db *gorm.DB) {
+	if !inTransaction(db) {
+		InvalidatePermissions(ctx)
+	}
+}
+
+// inTransaction checks whether the supplied database handle is a transaction.
+func inTransaction(db *gorm.DB) bool {
+	committer, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok && committer != nil
+}
+
+// invalidateTag removes the cached values with the supplied tag, logging any
+// error.
+func invalidateTag(ctx context.Context, tag string) {
+	if err := cache.InvalidateTag(tag); err != nil {
+		logrus.WithContext(ctx).Error(err)
+	}
+}
+
+// userTag gets the cache tag of values cached for the specified user.
+func userTag(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// roleTag gets the cache tag of values that depend on the specified role.
+func roleTag(roleID uint) string {
+	return fmt.Sprintf("role:%d", roleID)
+}
@@ -8,14 +8,16 @@ import (
 
 	"web-app/data"
 	"web-app/email"
+	"web-app/events"
+	"web-app/flags"
 	"web-app/httperror"
+	"web-app/idempotency"
 	"web-app/server"
 	"web-app/user"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"github.com/twinj/uuid"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -23,16 +25,21 @@ import (
 func init() {
 
 	// bind public endpoints
-	server.Router().POST(signupEndpoint, signup)
+	server.Router().POST(signupEndpoint, idempotency.KeyMiddleware(), signup)
 	server.Router().POST(signupVerifyEndpoint, signupVerify)
-	server.Router().POST(loginEndpoint, login)
-	server.Router().POST(refreshEndpoint, refresh)
-	server.Router().POST(recoverEndpoint, recover)
+	server.Router().POST(loginEndpoint, server.NoStoreMiddleware(), login)
+	server.Router().POST(refreshEndpoint, server.NoStoreMiddleware(), refresh)
+	server.Router().POST(recoverEndpoint, idempotency.KeyMiddleware(), recover)
 	server.Router().POST(recoverResetEndpoint, recoverReset)
 
 	// bind private endpoints
 	server.Router().POST(logoutEndpoint, user.JWTAuthMiddleware(), logout)
 	server.Router().POST(resetEndpoint, user.JWTAuthMiddleware(), reset)
+
+	// allow users to log in and refresh tokens in read-only mode so that
+	// access is not lost once an access token expires
+	server.AllowDuringMaintenance(server.MaintenanceReadOnly, loginEndpoint,
+		refreshEndpoint)
 }
 
 const (
@@ -100,16 +107,16 @@ func signup(c *gin.Context) {
 		return
 	}
 
-	// check if a verified user account with the same email address already
-	// exists
+	// check if a verified user account or a service account with the same
+	// email address already exists
 	u, err := user.GetUserByEmail(c, data.DB(), req.Email)
 	if err != nil && err != gorm.ErrRecordNotFound {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
 		return
-	} else if u != nil && u.Verified {
+	} else if u != nil && (u.Verified || u.Service) {
 		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
 			ErrorMessage: "email address is already registered",
 		})
@@ -132,7 +139,7 @@ func signup(c *gin.Context) {
 
 		// create the user account record
 		if err := user.SaveUser(c, tx, u); err != nil {
-			logrus.Error(err)
+			logrus.WithContext(c).Error(err)
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 				ErrorMessage: httperror.InternalServerError,
@@ -143,10 +150,9 @@ func signup(c *gin.Context) {
 	}
 
 	// set user password
-	hash, err := bcrypt.GenerateFromPassword(
-		[]byte(fmt.Sprintf("%d:%s", u.ID, req.Password)), bcrypt.DefaultCost)
+	hash, err := user.HashPassword(c, u, req.Password)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
@@ -154,10 +160,10 @@ func signup(c *gin.Context) {
 		return
 	}
 
-	u.Password = string(hash)
+	u.Password = hash
 
 	if err := user.SaveUser(c, tx, u); err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
@@ -168,7 +174,7 @@ func signup(c *gin.Context) {
 	// generate the verification token
 	token, err := user.GenerateSecretToken(c, u, u.Email)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
@@ -178,6 +184,7 @@ func signup(c *gin.Context) {
 
 	// send the verification email
 	if err := email.SendEmailTemplate(
+		c,
 		email.DefaultFromAddress(),
 		email.DefaultReplyToAddress(),
 		[]string{u.Email},
@@ -189,7 +196,7 @@ func signup(c *gin.Context) {
 			VerificationToken: token,
 		},
 	); err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: "failed to send verification email, please try again later",
@@ -199,13 +206,15 @@ func signup(c *gin.Context) {
 
 	// commit the transaction
 	if err := tx.Commit().Error; err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: "failed to create user account, please try again later",
 		})
 		return
 	}
 
+	user.InvalidateUser(c, u.ID)
+
 }
 
 // signupVerify checks the supplied verification token to determine if the user
@@ -225,7 +234,7 @@ func signupVerify(c *gin.Context) {
 	// decode the verification token
 	u, payload, err := user.ParseSecretToken(c, req.Token)
 	if err != nil {
-		logrus.Warn(err)
+		logrus.WithContext(c).Warn(err)
 		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
 			ErrorMessage: invalidToken,
 		})
@@ -245,7 +254,7 @@ func signupVerify(c *gin.Context) {
 
 	// save user record
 	if err := user.SaveUser(c, data.DB(), u); err != nil {
-		logrus.WithError(err)
+		logrus.WithContext(c).WithError(err)
 		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
 			ErrorMessage: invalidToken,
 		})
@@ -289,19 +298,27 @@ func login(c *gin.Context) {
 	// retrieve user account by email address
 	u, err := user.GetUserByEmail(c, data.DB(), req.Email)
 	if err == gorm.ErrRecordNotFound {
-		logrus.Warn(err)
+		logrus.WithContext(c).Warn(err)
 		c.JSON(http.StatusUnauthorized, httperror.ErrorResponse{
 			ErrorMessage: invalidUserCredentials,
 		})
 		return
 	} else if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
 		return
 	}
 
+	// service accounts authenticate with client certificates only
+	if u.Service {
+		c.JSON(http.StatusUnauthorized, httperror.ErrorResponse{
+			ErrorMessage: invalidUserCredentials,
+		})
+		return
+	}
+
 	if !u.Verified {
 		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
 			ErrorMessage: "account email has not been verified",
@@ -310,11 +327,8 @@ func login(c *gin.Context) {
 	}
 
 	// compare supplied password with user password
-	if err := bcrypt.CompareHashAndPassword(
-		[]byte(u.Password),
-		[]byte(fmt.Sprintf("%d:%s", u.ID, req.Password)),
-	); err != nil {
-		logrus.Debug(err)
+	if err := user.ComparePassword(c, u, req.Password); err != nil {
+		logrus.WithContext(c).Debug(err)
 		c.JSON(http.StatusUnauthorized, httperror.ErrorResponse{
 			ErrorMessage: invalidUserCredentials,
 		})
@@ -324,7 +338,7 @@ func login(c *gin.Context) {
 	// generate access and refresh tokens
 	accessToken, refreshToken, err := user.CreateAuth(c, u)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
@@ -336,7 +350,7 @@ func login(c *gin.Context) {
 	// get public user permissions
 	permissions, err := user.GetUserPermissions(c, u, ptrToBool(true))
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
@@ -347,18 +361,22 @@ func login(c *gin.Context) {
 		permissionKeys = append(permissionKeys, permission.Key)
 	}
 
-	// delete expired user login records to keep persistent storage clean
-	go func() {
-		if err := user.DeleteExpiredLogin(c, data.DB(), u.ID); err != nil {
-			logrus.Error(err)
-		}
-	}()
+	// evaluate public feature flags for the user
+	publicFlags, err := flags.PublicFlags(c, u)
+	if err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
 
 	// repond with auth tokens
 	c.JSON(http.StatusOK, loginResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		Permissions:  permissionKeys,
+		Flags:        publicFlags,
 	})
 
 }
@@ -388,7 +406,7 @@ func refresh(c *gin.Context) {
 	// validate the supplied refresh token
 	login, err := user.JWTValidateRefreshToken(c, req.RefreshToken)
 	if err != nil {
-		logrus.Warn(err)
+		logrus.WithContext(c).Warn(err)
 		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
 			ErrorMessage: invalidRefreshToken,
 		})
@@ -398,7 +416,7 @@ func refresh(c *gin.Context) {
 	// retrieve user record
 	u, err := user.GetUserByID(c, data.DB(), login.UserID)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
 			ErrorMessage: invalidRefreshToken,
 		})
@@ -408,7 +426,7 @@ func refresh(c *gin.Context) {
 	// generate access and refresh tokens
 	accessToken, refreshToken, err := user.CreateAuth(c, u)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
@@ -417,7 +435,7 @@ func refresh(c *gin.Context) {
 
 	// delete original refresh token
 	if err := user.DeleteLogin(c, data.DB(), login); err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 	}
 
 	var permissionKeys []string
@@ -425,7 +443,7 @@ func refresh(c *gin.Context) {
 	// get public user permissions
 	permissions, err := user.GetUserPermissions(c, u, ptrToBool(true))
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
@@ -436,11 +454,22 @@ func refresh(c *gin.Context) {
 		permissionKeys = append(permissionKeys, permission.Key)
 	}
 
+	// evaluate public feature flags for the user
+	publicFlags, err := flags.PublicFlags(c, u)
+	if err != nil {
+		logrus.WithContext(c).Error(err)
+		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
+			ErrorMessage: httperror.InternalServerError,
+		})
+		return
+	}
+
 	// repond with auth tokens
 	c.JSON(http.StatusOK, refreshResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		Permissions:  permissionKeys,
+		Flags:        publicFlags,
 	})
 
 }
@@ -451,7 +480,7 @@ func logout(c *gin.Context) {
 	// get user from JWT
 	u, err := user.JWTGetUser(c)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusUnauthorized, httperror.ErrorResponse{
 			ErrorMessage: logoutFailedGeneric,
 		})
@@ -461,7 +490,7 @@ func logout(c *gin.Context) {
 	// get user auth record from JWT
 	login, err := user.JWTGetUserLogin(c)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusUnauthorized, httperror.ErrorResponse{
 			ErrorMessage: logoutFailedGeneric,
 		})
@@ -470,7 +499,7 @@ func logout(c *gin.Context) {
 
 	// delete user auth record, this will invalidate the refresh token
 	if err := user.DeleteLogin(c, data.DB(), login); err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: logoutFailedGeneric,
 		})
@@ -484,13 +513,18 @@ func logout(c *gin.Context) {
 
 	// update the user record
 	if err := user.SaveUser(c, data.DB(), u); err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: logoutFailedGeneric,
 		})
 		return
 	}
 
+	// notify any connected clients that the user has logged out
+	if err := events.Publish(u.ID, user.LogoutEvent, nil); err != nil {
+		logrus.WithContext(c).Error(err)
+	}
+
 	// respond with 200 - OK if logout was successful
 	c.Status(http.StatusOK)
 
@@ -510,15 +544,16 @@ func recover(c *gin.Context) {
 		return
 	}
 
-	// retrieve user account by email address
+	// retrieve user account by email address, service accounts have no
+	// password to recover
 	u, err := user.GetUserByEmail(c, data.DB(), req.Email)
-	if err == gorm.ErrRecordNotFound {
+	if err == gorm.ErrRecordNotFound || (err == nil && u.Service) {
 		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
 			ErrorMessage: "email address not found",
 		})
 		return
 	} else if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
@@ -528,7 +563,7 @@ func recover(c *gin.Context) {
 	// generate the verification token
 	token, err := user.GenerateSecretToken(c, u, u.Email)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
@@ -537,6 +572,7 @@ func recover(c *gin.Context) {
 
 	// send the verification email
 	if err := email.SendEmailTemplate(
+		c,
 		email.DefaultFromAddress(),
 		email.DefaultReplyToAddress(),
 		[]string{u.Email},
@@ -572,7 +608,7 @@ func recoverReset(c *gin.Context) {
 	// decode the verification token
 	u, payload, err := user.ParseSecretToken(c, req.Token)
 	if err != nil {
-		logrus.Warn(err)
+		logrus.WithContext(c).Warn(err)
 		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
 			ErrorMessage: invalidToken,
 		})
@@ -588,20 +624,19 @@ func recoverReset(c *gin.Context) {
 	}
 
 	// set user password
-	hash, err := bcrypt.GenerateFromPassword(
-		[]byte(fmt.Sprintf("%d:%s", u.ID, req.Password)), bcrypt.DefaultCost)
+	hash, err := user.HashPassword(c, u, req.Password)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
 		return
 	}
 
-	u.Password = string(hash)
+	u.Password = hash
 
 	if err := user.SaveUser(c, data.DB(), u); err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
@@ -619,7 +654,7 @@ func reset(c *gin.Context) {
 	// get user from JWT
 	u, err := user.JWTGetUser(c)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusUnauthorized, httperror.ErrorResponse{
 			ErrorMessage: resetFailedGeneric,
 		})
@@ -652,11 +687,8 @@ func reset(c *gin.Context) {
 	}
 
 	// verify current password
-	if err := bcrypt.CompareHashAndPassword(
-		[]byte(u.Password),
-		[]byte(fmt.Sprintf("%d:%s", u.ID, req.CurrentPassword)),
-	); err != nil {
-		logrus.Debug(err)
+	if err := user.ComparePassword(c, u, req.CurrentPassword); err != nil {
+		logrus.WithContext(c).Debug(err)
 		c.JSON(http.StatusBadRequest, httperror.ErrorResponse{
 			ErrorMessage: "current password is incorrect",
 		})
@@ -672,20 +704,19 @@ func reset(c *gin.Context) {
 	}
 
 	// set user password
-	hash, err := bcrypt.GenerateFromPassword(
-		[]byte(fmt.Sprintf("%d:%s", u.ID, req.NewPassword)), bcrypt.DefaultCost)
+	hash, err := user.HashPassword(c, u, req.NewPassword)
 	if err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
 		return
 	}
 
-	u.Password = string(hash)
+	u.Password = hash
 
 	if err := user.SaveUser(c, data.DB(), u); err != nil {
-		logrus.Error(err)
+		logrus.WithContext(c).Error(err)
 		c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 			ErrorMessage: httperror.InternalServerError,
 		})
@@ -25,9 +25,10 @@ type loginRequest struct {
 
 // loginResponse is used to format responses from the login endpoint.
 type loginResponse struct {
-	AccessToken  string   `json:"access_token"`
-	RefreshToken string   `json:"refresh_token"`
-	Permissions  []string `json:"permissions"`
+	AccessToken  string          `json:"access_token"`
+	RefreshToken string          `json:"refresh_token"`
+	Permissions  []string        `json:"permissions"`
+	Flags        map[string]bool `json:"flags"`
 }
 
 // refreshRequest is used to read a request to the refresh endpoint.
@@ -37,9 +38,10 @@ type refreshRequest struct {
 
 // refreshResponse is used to format responses from the refresh endpoint.
 type refreshResponse struct {
-	AccessToken  string   `json:"access_token"`
-	RefreshToken string   `json:"refresh_token"`
-	Permissions  []string `json:"permissions"`
+	AccessToken  string          `json:"access_token"`
+	RefreshToken string          `json:"refresh_token"`
+	Permissions  []string        `json:"permissions"`
+	Flags        map[string]bool `json:"flags"`
 }
 
 // recoverRequest is used to read a request to the recover endpoint.
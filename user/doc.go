@@ -12,4 +12,20 @@
 //     WEB_APP_REFRESH_EXPIRATION_HOURS:
 //         int - the number of hours before a refresh token is expired
 //         Default: 72
+//     WEB_APP_SOFT_DELETE_RETENTION_DAYS:
+//         int - the number of days deleted users, roles, permissions, and
+//         service identities are kept before they are purged
+//         Default: 30
+//     WEB_APP_PERMISSION_CACHE_TTL:
+//         int - the number of seconds user auth states and resolved
+//         permissions are cached, set to 0 to disable caching; other server
+//         instances only see logouts, deletions and permission changes once
+//         cached values expire unless the cache store is redis or the cache
+//         broadcaster is database
+//         Default: 60 with a redis cache store or database cache
+//         broadcaster, otherwise 0
+//     WEB_APP_PERMISSION_CLAIMS:
+//         bool - whether access tokens carry the permissions of the user, the
+//         permissions are trusted until the user's permissions change
+//         Default: false
 package user
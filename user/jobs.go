@@ -0,0 +1,51 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"web-app/data"
+	"web-app/jobs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// init registers jobs that keep persistent storage clean.
+func init() {
+
+	// remove expired login records for all users
+	if err := jobs.Register(&jobs.Job{
+		Name:     "login_cleanup",
+		Schedule: "5 * * * *",
+		Run: func(ctx context.Context) error {
+			return DeleteExpiredLogins(ctx, data.DB())
+		},
+	}); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// permanently remove records that have been deleted for longer than the
+	// retention period
+	if err := jobs.Register(&jobs.Job{
+		Name:     "soft_delete_purge",
+		Schedule: "30 3 * * *",
+		Run: func(ctx context.Context) error {
+			before := time.Now().AddDate(0, 0, -softDeleteRetentionDays)
+			for _, model := range []interface{}{
+				&ServiceIdentity{},
+				&Permission{},
+				&Role{},
+				&User{},
+			} {
+				if err := purgeDeleted(ctx, data.DB(), model,
+					before); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}); err != nil {
+		logrus.Fatal(err)
+	}
+
+}
@@ -1,6 +1,7 @@
 package user
 
 import (
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net/http"
@@ -10,18 +11,22 @@ import (
 
 	"web-app/data"
 	"web-app/httperror"
+	"web-app/identity"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 // jwtAccessMetadata stores information embedded in a JWT access token.
 type jwtAccessMetadata struct {
-	authUUID  string
-	userID    uint
-	createdAt time.Time
-	expiresAt time.Time
+	authUUID          string
+	userID            uint
+	createdAt         time.Time
+	expiresAt         time.Time
+	permissions       map[string]struct{} // nil if the token carries no permissions
+	permissionVersion uint
 }
 
 // jwtRefreshMetadata stores information embedded in a JWT refresh token.
@@ -39,31 +44,93 @@ const (
 	// insufficientPermissionsGeneric is returned when a user does not have
 	// required permissions to complete a request.
 	insufficientPermissionsGeneric = "insufficient user permissions"
+	// requestUserKey is the gin context key used to store the user record
+	// that made the request so that it is only loaded once per request.
+	requestUserKey = "web-app/user.requestUser"
+	// requestAuthStateKey is the gin context key used to store the auth state
+	// of the user that made the request.
+	requestAuthStateKey = "web-app/user.requestAuthState"
+	// requestPermissionsKey is the gin context key used to store the keys of
+	// the permissions of the user that made the request.
+	requestPermissionsKey = "web-app/user.requestPermissions"
 )
 
 // JWTAuthMiddleware gets middleware that handles request authentication using
-// a JWT bearer token.
+// a JWT bearer token. The request is authenticated using the cached auth state
+// of the user so the user record is only loaded if a handler requests it. The
+// permissions carried by the access token are used by the permission
+// middlewares while they are current.
 func JWTAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if err := jwtAccessTokenValid(c); err != nil {
-			logrus.Debug(err)
+		metadata, state, err := jwtAccessTokenAuthState(c)
+		if err != nil {
+			logrus.WithContext(c).Debug(err)
+			c.JSON(http.StatusUnauthorized, httperror.ErrorResponse{
+				ErrorMessage: authorizationFailedGeneric,
+			})
+			c.Abort()
+			return
+		}
+		c.Set(requestAuthStateKey, state)
+		c.Set(identity.UserIDKey, state.UserID)
+
+		// trust the permissions carried by the access token unless the
+		// permissions of the user have changed since it was issued
+		if metadata.permissions != nil &&
+			metadata.permissionVersion == state.PermissionVersion {
+			c.Set(requestPermissionsKey, metadata.permissions)
+		}
+
+		c.Next()
+	}
+}
+
+// ClientCertAuthMiddleware gets middleware that handles request authentication
+// using a verified TLS client certificate. The certificate subject alternative
+// names and common name are matched against the configured service identities
+// and the associated user account is used to authorize the request. This
+// middleware may be used in place of JWTAuthMiddleware.
+func ClientCertAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, err := clientCertGetUser(c)
+		if err != nil {
+			logrus.WithContext(c).Debug(err)
 			c.JSON(http.StatusUnauthorized, httperror.ErrorResponse{
 				ErrorMessage: authorizationFailedGeneric,
 			})
 			c.Abort()
 			return
 		}
+		c.Set(requestUserKey, u)
+		c.Set(requestAuthStateKey, authStateOf(u))
+		c.Set(identity.UserIDKey, u.ID)
 		c.Next()
 	}
 }
 
+// AuthMiddleware gets middleware that handles request authentication using a
+// verified TLS client certificate if one was presented without a bearer token,
+// otherwise using a JWT bearer token. Routes that service identities may call
+// should use this middleware in place of JWTAuthMiddleware.
+func AuthMiddleware() gin.HandlerFunc {
+	jwtAuth := JWTAuthMiddleware()
+	clientCertAuth := ClientCertAuthMiddleware()
+	return func(c *gin.Context) {
+		if getAccessToken(c) == "" && verifiedClientCert(c) != nil {
+			clientCertAuth(c)
+			return
+		}
+		jwtAuth(c)
+	}
+}
+
 // RequireAllPermissionsMiddleware checks that the user making the request has
 // all of the specified permissions.
 func RequireAllPermissionsMiddleware(permissionKeys ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		u, err := JWTGetUser(c)
+		state, err := requestAuthState(c)
 		if err != nil {
-			logrus.Debug(err)
+			logrus.WithContext(c).Debug(err)
 			c.JSON(http.StatusUnauthorized, httperror.ErrorResponse{
 				ErrorMessage: authorizationFailedGeneric,
 			})
@@ -71,13 +138,14 @@ func RequireAllPermissionsMiddleware(permissionKeys ...string) gin.HandlerFunc {
 			return
 		}
 
-		if u.Admin {
+		if state.Admin {
 			c.Next()
 			return
 		}
 
-		permissions, err := GetUserPermissions(c, u, nil)
+		userPermissionKeys, err := requestPermissionKeys(c, state)
 		if err != nil {
+			logrus.WithContext(c).Error(err)
 			c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 				ErrorMessage: httperror.InternalServerError,
 			})
@@ -85,11 +153,6 @@ func RequireAllPermissionsMiddleware(permissionKeys ...string) gin.HandlerFunc {
 			return
 		}
 
-		userPermissionKeys := map[string]struct{}{}
-		for _, permission := range permissions {
-			userPermissionKeys[permission.Key] = struct{}{}
-		}
-
 		for _, permissionKey := range permissionKeys {
 			if _, ok := userPermissionKeys[permissionKey]; !ok {
 				c.JSON(http.StatusForbidden, httperror.ErrorResponse{
@@ -108,9 +171,9 @@ func RequireAllPermissionsMiddleware(permissionKeys ...string) gin.HandlerFunc {
 // at least one of the specified permissions.
 func RequireAnyPermissionsMiddleware(permissionKeys ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		u, err := JWTGetUser(c)
+		state, err := requestAuthState(c)
 		if err != nil {
-			logrus.Debug(err)
+			logrus.WithContext(c).Debug(err)
 			c.JSON(http.StatusUnauthorized, httperror.ErrorResponse{
 				ErrorMessage: authorizationFailedGeneric,
 			})
@@ -118,13 +181,14 @@ func RequireAnyPermissionsMiddleware(permissionKeys ...string) gin.HandlerFunc {
 			return
 		}
 
-		if u.Admin {
+		if state.Admin {
 			c.Next()
 			return
 		}
 
-		permissions, err := GetUserPermissions(c, u, nil)
+		userPermissionKeys, err := requestPermissionKeys(c, state)
 		if err != nil {
+			logrus.WithContext(c).Error(err)
 			c.JSON(http.StatusInternalServerError, httperror.ErrorResponse{
 				ErrorMessage: httperror.InternalServerError,
 			})
@@ -132,11 +196,6 @@ func RequireAnyPermissionsMiddleware(permissionKeys ...string) gin.HandlerFunc {
 			return
 		}
 
-		userPermissionKeys := map[string]struct{}{}
-		for _, permission := range permissions {
-			userPermissionKeys[permission.Key] = struct{}{}
-		}
-
 		for _, permissionKey := range permissionKeys {
 			if _, ok := userPermissionKeys[permissionKey]; ok {
 				c.Next()
@@ -152,6 +211,94 @@ func RequireAnyPermissionsMiddleware(permissionKeys ...string) gin.HandlerFunc {
 	}
 }
 
+// AuthenticateRequest checks whether the request was made by an authenticated
+// user without aborting the request, returns the user record if the request is
+// authenticated. Requests are authenticated in the same way as AuthMiddleware,
+// using either a verified client certificate or a JWT access token.
+func AuthenticateRequest(c *gin.Context) (*User, error) {
+
+	if getAccessToken(c) == "" && verifiedClientCert(c) != nil {
+		return clientCertGetUser(c)
+	}
+
+	return jwtAccessTokenValid(c)
+
+}
+
+// RequestUser retrieves the user record that made the request. If the request
+// was authenticated with a client certificate the associated service user is
+// returned, otherwise the user is extracted from the request access token. The
+// user record is only loaded once per request.
+func RequestUser(c *gin.Context) (*User, error) {
+
+	if item, ok := c.Get(requestUserKey); ok {
+		if u, ok := item.(*User); ok {
+			return u, nil
+		}
+	}
+
+	u, err := JWTGetUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Set(requestUserKey, u)
+
+	return u, nil
+
+}
+
+// requestAuthState retrieves the auth state of the user that made the request.
+// The auth state stored by the authentication middleware is used if present.
+func requestAuthState(c *gin.Context) (*authState, error) {
+
+	if item, ok := c.Get(requestAuthStateKey); ok {
+		if state, ok := item.(*authState); ok {
+			return state, nil
+		}
+	}
+
+	u, err := RequestUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	state := authStateOf(u)
+	c.Set(requestAuthStateKey, state)
+
+	return state, nil
+
+}
+
+// requestPermissionKeys retrieves the keys of the permissions of the user with
+// the supplied auth state. The keys carried by a current access token are used
+// if present, otherwise the keys are resolved once per request so that every
+// permission middleware bound to a route shares them.
+func requestPermissionKeys(c *gin.Context,
+	state *authState) (map[string]struct{}, error) {
+
+	if item, ok := c.Get(requestPermissionsKey); ok {
+		if keys, ok := item.(map[string]struct{}); ok {
+			return keys, nil
+		}
+	}
+
+	permissions, err := getResolvedPermissions(c, state.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]struct{}{}
+	for _, permission := range permissions {
+		keys[permission.Key] = struct{}{}
+	}
+
+	c.Set(requestPermissionsKey, keys)
+
+	return keys, nil
+
+}
+
 // JWTGetUser extracts a user record from the request access token.
 func JWTGetUser(c *gin.Context) (*User, error) {
 
@@ -200,25 +347,102 @@ func JWTValidateRefreshToken(c *gin.Context,
 
 }
 
-// jwtAccessTokenValid checks whether the request access token is valid.
-func jwtAccessTokenValid(c *gin.Context) error {
+// clientCertGetUser retrieves the user record associated with the verified
+// client certificate supplied with the request.
+func clientCertGetUser(c *gin.Context) (*User, error) {
+
+	cert := verifiedClientCert(c)
+	if cert == nil {
+		return nil, errors.New("no verified client certificate")
+	}
+
+	// collect the identities presented by the certificate, preferring subject
+	// alternative names over the common name
+	var subjects []string
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	subjects = append(subjects, cert.DNSNames...)
+	subjects = append(subjects, cert.EmailAddresses...)
+	if cert.Subject.CommonName != "" {
+		subjects = append(subjects, cert.Subject.CommonName)
+	}
+
+	for _, subject := range subjects {
+		identity, err := GetServiceIdentityBySubject(c, data.DB(), subject)
+		if err == gorm.ErrRecordNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		u, err := GetUserByID(c, data.DB(), identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		// only service accounts may be used through a client certificate
+		if !u.Service {
+			return nil, fmt.Errorf("user %d is not a service account", u.ID)
+		}
+
+		return u, nil
+	}
+
+	return nil, fmt.Errorf("no service identity for client certificate '%s'",
+		cert.Subject.String())
+
+}
+
+// verifiedClientCert retrieves the client certificate presented with the
+// request. Returns nil if the client did not present a certificate that was
+// verified during the TLS handshake.
+func verifiedClientCert(c *gin.Context) *x509.Certificate {
+
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 ||
+		len(c.Request.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return c.Request.TLS.VerifiedChains[0][0]
+
+}
+
+// jwtAccessTokenValid checks whether the request access token is valid,
+// returns the associated user record if the token is valid.
+func jwtAccessTokenValid(c *gin.Context) (*User, error) {
+
+	if _, _, err := jwtAccessTokenAuthState(c); err != nil {
+		return nil, err
+	}
+
+	return RequestUser(c)
+
+}
+
+// jwtAccessTokenAuthState checks whether the request access token is valid,
+// returns the token metadata and the auth state of the associated user if the
+// token is valid.
+func jwtAccessTokenAuthState(c *gin.Context) (*jwtAccessMetadata, *authState,
+	error) {
 
 	metadata, err := jwtGetAccessMetadata(c)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	u, err := GetUserByID(c, data.DB(), metadata.userID)
+	state, err := getAuthState(c, metadata.userID)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	if metadata.expiresAt.Before(time.Now()) ||
-		(u.LoggedOutAt != nil && metadata.createdAt.Before(*u.LoggedOutAt)) {
-		return errors.New("access token expired")
+		(state.LoggedOutAt != nil &&
+			metadata.createdAt.Before(*state.LoggedOutAt)) {
+		return nil, nil, errors.New("access token expired")
 	}
 
-	return nil
+	return metadata, state, nil
 
 }
 
@@ -267,12 +491,42 @@ func jwtGetAccessMetadata(c *gin.Context) (*jwtAccessMetadata, error) {
 		return nil, genericErr
 	}
 
-	return &jwtAccessMetadata{
+	metadata := &jwtAccessMetadata{
 		authUUID:  authUUID,
 		userID:    uint(userID),
 		createdAt: time.Unix(int64(createdAtUnix), 0),
 		expiresAt: time.Unix(int64(expiresAtUnix), 0),
-	}, nil
+	}
+
+	// permissions are only present if permission claims were enabled when the
+	// token was issued
+	if _, ok := claims["permissions"]; ok {
+
+		permissionKeys, ok := claims["permissions"].([]interface{})
+		if !ok {
+			return nil, genericErr
+		}
+
+		metadata.permissions = map[string]struct{}{}
+		for _, item := range permissionKeys {
+			permissionKey, ok := item.(string)
+			if !ok {
+				return nil, genericErr
+			}
+			metadata.permissions[permissionKey] = struct{}{}
+		}
+
+		permissionVersion, err := jwtParseIntFromClaims(claims,
+			"permission_version")
+		if err != nil {
+			return nil, genericErr
+		}
+
+		metadata.permissionVersion = uint(permissionVersion)
+
+	}
+
+	return metadata, nil
 }
 
 // getAccessToken retrieves the bearer auth token from the supplied request.
@@ -20,6 +20,7 @@ func init() {
 		userRole{},
 		rolePermission{},
 		userPermission{},
+		ServiceIdentity{},
 	)
 
 	// check if we should use mock data
@@ -52,8 +53,11 @@ type User struct {
 	Admin     bool   `json:"admin"`      // admins have the broadest set of user permissions
 	SecretKey string `json:"secret_key"` // used to sign tokens when generating links for this user
 	Verified  bool   `json:"verified"`   // whether the user has completed email verification
+	Service   bool   `json:"service"`    // service accounts authenticate with client certificates and can never log in
 
 	LoggedOutAt *time.Time `json:"logged_out_at"` // records the last time the user explicitly logged out
+
+	PermissionVersion uint `gorm:"default:0" json:"permission_version"` // incremented whenever the permissions of the user change
 }
 
 // Login stores identifiers for validating user auth tokens.
@@ -123,6 +127,20 @@ type userPermission struct {
 	Permission   Permission `gorm:"constraint:OnDelete:CASCADE"`
 }
 
+// ServiceIdentity maps the identity presented in a client certificate to a user
+// account. Requests authenticated with the client certificate are granted the
+// roles and permissions of the associated user account.
+type ServiceIdentity struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+
+	Subject string `gorm:"index,unique" json:"subject"` // certificate common name or subject alternative name
+	UserID  uint   `json:"user_id"`
+	User    User   `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+}
+
 /* Mock Data */
 
 var mockUsers = []User{
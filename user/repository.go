@@ -16,7 +16,7 @@ func GetUserByID(ctx context.Context, db *gorm.DB, id uint) (*User, error) {
 
 	var item User
 
-	if err := db.Model(&User{}).
+	if err := db.WithContext(ctx).Model(&User{}).
 		Where("id = ?", id).
 		First(&item).Error; err != nil {
 		return nil, err
@@ -32,7 +32,7 @@ func GetUserByEmail(ctx context.Context, db *gorm.DB,
 
 	var item User
 
-	if err := db.Model(&User{}).
+	if err := db.WithContext(ctx).Model(&User{}).
 		Where("LOWER(email) = LOWER(?)", email).
 		First(&item).Error; err != nil {
 		return nil, err
@@ -42,14 +42,80 @@ func GetUserByEmail(ctx context.Context, db *gorm.DB,
 
 }
 
-// SaveUser inserts or updates the supplied user record.
+// SaveUser inserts or updates the supplied user record. The permission version
+// of the user is only changed when the user's permissions change.
 func SaveUser(ctx context.Context, db *gorm.DB, item *User) error {
-	return db.Save(item).Error
+
+	if err := db.WithContext(ctx).Omit("PermissionVersion").
+		Save(item).Error; err != nil {
+		return err
+	}
+
+	invalidateUser(ctx, db, item.ID)
+
+	return nil
+
 }
 
 // DeleteUser deletes the supplied user record.
 func DeleteUser(ctx context.Context, db *gorm.DB, item *User) error {
-	return db.Delete(item).Error
+
+	if err := db.WithContext(ctx).Delete(item).Error; err != nil {
+		return err
+	}
+
+	invalidateUser(ctx, db, item.ID)
+
+	return nil
+
+}
+
+// incrementPermissionVersionByUser increments the permission version of the
+// specified user.
+func incrementPermissionVersionByUser(ctx context.Context, db *gorm.DB,
+	userID uint) error {
+	return incrementPermissionVersion(ctx, db, "id = ?", userID)
+}
+
+// incrementPermissionVersionByRole increments the permission version of every
+// user assigned the specified role.
+func incrementPermissionVersionByRole(ctx context.Context, db *gorm.DB,
+	roleID uint) error {
+
+	users := db.Model(&userRole{}).Select("user_id").
+		Where("role_id = ?", roleID)
+
+	return incrementPermissionVersion(ctx, db, "id IN (?)", users)
+
+}
+
+// incrementPermissionVersionByPermission increments the permission version of
+// every user granted the specified permission directly or through a role.
+func incrementPermissionVersionByPermission(ctx context.Context, db *gorm.DB,
+	permissionID uint) error {
+
+	users := db.Model(&userPermission{}).Select("user_id").
+		Where("permission_id = ?", permissionID)
+
+	roles := db.Model(&rolePermission{}).Select("role_id").
+		Where("permission_id = ?", permissionID)
+
+	roleUsers := db.Model(&userRole{}).Select("user_id").
+		Where("role_id IN (?)", roles)
+
+	return incrementPermissionVersion(ctx, db, "id IN (?) OR id IN (?)", users,
+		roleUsers)
+
+}
+
+// incrementPermissionVersion increments the permission version of the users
+// matching the supplied conditions so that access tokens issued with their
+// previous permissions are no longer trusted.
+func incrementPermissionVersion(ctx context.Context, db *gorm.DB,
+	query string, args ...interface{}) error {
+	return db.WithContext(ctx).Model(&User{}).Where(query, args...).
+		UpdateColumn("permission_version",
+			gorm.Expr("permission_version + ?", 1)).Error
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -62,7 +128,7 @@ func GetLoginByID(ctx context.Context, db *gorm.DB,
 
 	var item Login
 
-	if err := db.Model(&Login{}).
+	if err := db.WithContext(ctx).Model(&Login{}).
 		Where("id = ?", id).
 		First(&item).Error; err != nil {
 		return nil, err
@@ -78,7 +144,7 @@ func GetLoginByUUID(ctx context.Context, db *gorm.DB,
 
 	var item Login
 
-	if err := db.Model(&Login{}).
+	if err := db.WithContext(ctx).Model(&Login{}).
 		Where("uuid = ?", uuid).
 		First(&item).Error; err != nil {
 		return nil, err
@@ -95,7 +161,7 @@ func ListLoginByUserID(ctx context.Context, db *gorm.DB,
 
 	var items []*Login
 
-	if err := db.Model(&Login{}).
+	if err := db.WithContext(ctx).Model(&Login{}).
 		Where("user_id = ?", userID).
 		Find(&items).Error; err != nil {
 		return nil, err
@@ -107,23 +173,30 @@ func ListLoginByUserID(ctx context.Context, db *gorm.DB,
 
 // SaveLogin inserts or updates the supplied user login record.
 func SaveLogin(ctx context.Context, db *gorm.DB, item *Login) error {
-	return db.Save(item).Error
+	return db.WithContext(ctx).Save(item).Error
 }
 
 // DeleteLogin deletes the supplied user login record.
 func DeleteLogin(ctx context.Context, db *gorm.DB, item *Login) error {
-	return db.Delete(item).Error
+	return db.WithContext(ctx).Delete(item).Error
 }
 
 // DeleteExpiredLogin deletes all expires user login records associated with
 // the specified user id.
 func DeleteExpiredLogin(ctx context.Context, db *gorm.DB, userID uint) error {
-	return db.
+	return db.WithContext(ctx).
 		Where("user_id = ?", userID).
 		Where("expires_at < ?", time.Now()).
 		Delete(&Login{}).Error
 }
 
+// DeleteExpiredLogins deletes all expired login records for every user.
+func DeleteExpiredLogins(ctx context.Context, db *gorm.DB) error {
+	return db.WithContext(ctx).
+		Where("expires_at < ?", time.Now()).
+		Delete(&Login{}).Error
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // Role                                                                       //
 ////////////////////////////////////////////////////////////////////////////////
@@ -133,7 +206,7 @@ func GetRoleByID(ctx context.Context, db *gorm.DB, id uint) (*Role, error) {
 
 	var item Role
 
-	if err := db.Model(&Role{}).
+	if err := db.WithContext(ctx).Model(&Role{}).
 		Where("id = ?", id).
 		First(&item).Error; err != nil {
 		return nil, err
@@ -148,7 +221,7 @@ func GetRoleByKey(ctx context.Context, db *gorm.DB, key string) (*Role, error) {
 
 	var item Role
 
-	if err := db.Model(&Role{}).
+	if err := db.WithContext(ctx).Model(&Role{}).
 		Where("key = ?", key).
 		First(&item).Error; err != nil {
 		return nil, err
@@ -163,7 +236,7 @@ func ListRole(ctx context.Context, db *gorm.DB) ([]*Role, error) {
 
 	var items []*Role
 
-	if err := db.Model(&Role{}).
+	if err := db.WithContext(ctx).Model(&Role{}).
 		Find(&items).Error; err != nil {
 		return nil, err
 	}
@@ -178,7 +251,7 @@ func ListRoleByUser(ctx context.Context, db *gorm.DB,
 
 	var items []*userRole
 
-	if err := db.Model(&userRole{}).
+	if err := db.WithContext(ctx).Model(&userRole{}).
 		Where("user_id = ?", userID).
 		Preload("Role").Find(&items).Error; err != nil {
 		return nil, err
@@ -194,24 +267,106 @@ func ListRoleByUser(ctx context.Context, db *gorm.DB,
 
 }
 
+// listRoleIDByUser retrieves the ids of all roles associated with the specified
+// user.
+func listRoleIDByUser(ctx context.Context, db *gorm.DB,
+	userID uint) ([]uint, error) {
+
+	var ids []uint
+
+	if err := db.WithContext(ctx).Model(&userRole{}).
+		Where("user_id = ?", userID).
+		Pluck("role_id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+
+}
+
 // SaveRole inserts or updates the supplied role record.
 func SaveRole(ctx context.Context, db *gorm.DB, item *Role) error {
-	return db.Save(item).Error
+	return db.WithContext(ctx).Save(item).Error
 }
 
 // DeleteRole deletes the supplied role record.
 func DeleteRole(ctx context.Context, db *gorm.DB, item *Role) error {
-	return db.Delete(item).Error
+
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Delete(item).Error; err != nil {
+			return err
+		}
+
+		return incrementPermissionVersionByRole(ctx, tx, item.ID)
+
+	}); err != nil {
+		return err
+	}
+
+	invalidateRole(ctx, db, item.ID)
+
+	return nil
+
+}
+
+// getUserRole retrieves the record associating the specified user and role.
+func getUserRole(ctx context.Context, db *gorm.DB, userID,
+	roleID uint) (*userRole, error) {
+
+	var item userRole
+
+	if err := db.WithContext(ctx).Model(&userRole{}).
+		Where("user_id = ?", userID).
+		Where("role_id = ?", roleID).
+		First(&item).Error; err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+
 }
 
 // saveUserRole inserts or updates the supplied user role record.
 func saveUserRole(ctx context.Context, db *gorm.DB, item *userRole) error {
-	return db.Save(item).Error
+
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Save(item).Error; err != nil {
+			return err
+		}
+
+		return incrementPermissionVersionByUser(ctx, tx, item.UserID)
+
+	}); err != nil {
+		return err
+	}
+
+	invalidateUser(ctx, db, item.UserID)
+
+	return nil
+
 }
 
 // deleteUserRole deletes the supplied user role record.
 func deleteUserRole(ctx context.Context, db *gorm.DB, item *userRole) error {
-	return db.Delete(item).Error
+
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Delete(item).Error; err != nil {
+			return err
+		}
+
+		return incrementPermissionVersionByUser(ctx, tx, item.UserID)
+
+	}); err != nil {
+		return err
+	}
+
+	invalidateUser(ctx, db, item.UserID)
+
+	return nil
+
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -224,7 +379,7 @@ func GetPermissionByID(ctx context.Context, db *gorm.DB,
 
 	var item Permission
 
-	if err := db.Model(&Permission{}).
+	if err := db.WithContext(ctx).Model(&Permission{}).
 		Where("id = ?", id).
 		First(&item).Error; err != nil {
 		return nil, err
@@ -240,7 +395,7 @@ func GetPermissionByKey(ctx context.Context, db *gorm.DB,
 
 	var item Permission
 
-	if err := db.Model(&Permission{}).
+	if err := db.WithContext(ctx).Model(&Permission{}).
 		Where("key = ?", key).
 		First(&item).Error; err != nil {
 		return nil, err
@@ -257,7 +412,7 @@ func ListPermission(ctx context.Context, db *gorm.DB,
 
 	var items []*Permission
 
-	q := db.Model(&Permission{})
+	q := db.WithContext(ctx).Model(&Permission{})
 
 	if public != nil {
 		q = q.Where("public = ?", *public)
@@ -278,7 +433,7 @@ func ListPermissionByRole(ctx context.Context, db *gorm.DB,
 
 	var items []*rolePermission
 
-	q := db.Model(&rolePermission{}).
+	q := db.WithContext(ctx).Model(&rolePermission{}).
 		Where("role_id = ?", roleID)
 
 	if err := q.Preload("Permission").Find(&items).Error; err != nil {
@@ -305,7 +460,7 @@ func ListPermissionByUser(ctx context.Context, db *gorm.DB,
 
 	var items []*userPermission
 
-	q := db.Model(&userPermission{}).
+	q := db.WithContext(ctx).Model(&userPermission{}).
 		Where("user_id = ?", userID)
 
 	if err := q.Preload("Permission").Find(&items).Error; err != nil {
@@ -327,35 +482,212 @@ func ListPermissionByUser(ctx context.Context, db *gorm.DB,
 
 // SavePermission inserts or updates the supplied permission record.
 func SavePermission(ctx context.Context, db *gorm.DB, item *Permission) error {
-	return db.Save(item).Error
+
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Save(item).Error; err != nil {
+			return err
+		}
+
+		return incrementPermissionVersionByPermission(ctx, tx,
+			item.ID)
+
+	}); err != nil {
+		return err
+	}
+
+	invalidatePermissions(ctx, db)
+
+	return nil
+
 }
 
 // DeletePermission deletes the supplied permission record.
 func DeletePermission(ctx context.Context, db *gorm.DB,
 	item *Permission) error {
-	return db.Delete(item).Error
+
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Delete(item).Error; err != nil {
+			return err
+		}
+
+		return incrementPermissionVersionByPermission(ctx, tx,
+			item.ID)
+
+	}); err != nil {
+		return err
+	}
+
+	invalidatePermissions(ctx, db)
+
+	return nil
+
+}
+
+// getUserPermission retrieves the record associating the specified user and
+// permission.
+func getUserPermission(ctx context.Context, db *gorm.DB, userID,
+	permissionID uint) (*userPermission, error) {
+
+	var item userPermission
+
+	if err := db.WithContext(ctx).Model(&userPermission{}).
+		Where("user_id = ?", userID).
+		Where("permission_id = ?", permissionID).
+		First(&item).Error; err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+
 }
 
 // saveUserPermission inserts or updates the supplied user permission record.
 func saveUserPermission(ctx context.Context, db *gorm.DB,
 	item *userPermission) error {
-	return db.Save(item).Error
+
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Save(item).Error; err != nil {
+			return err
+		}
+
+		return incrementPermissionVersionByUser(ctx, tx, item.UserID)
+
+	}); err != nil {
+		return err
+	}
+
+	invalidateUser(ctx, db, item.UserID)
+
+	return nil
+
 }
 
 // saveRolePermission inserts or updates the supplied role permission record.
 func saveRolePermission(ctx context.Context, db *gorm.DB,
 	item *rolePermission) error {
-	return db.Save(item).Error
+
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Save(item).Error; err != nil {
+			return err
+		}
+
+		return incrementPermissionVersionByRole(ctx, tx, item.RoleID)
+
+	}); err != nil {
+		return err
+	}
+
+	invalidateRole(ctx, db, item.RoleID)
+
+	return nil
+
 }
 
 // deleteUserPermission deletes the supplied user permission record.
 func deleteUserPermission(ctx context.Context, db *gorm.DB,
 	item *userPermission) error {
-	return db.Delete(item).Error
+
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Delete(item).Error; err != nil {
+			return err
+		}
+
+		return incrementPermissionVersionByUser(ctx, tx, item.UserID)
+
+	}); err != nil {
+		return err
+	}
+
+	invalidateUser(ctx, db, item.UserID)
+
+	return nil
+
 }
 
 // deleteRolePermission deletes the supplied role permission record.
 func deleteRolePermission(ctx context.Context, db *gorm.DB,
 	item *rolePermission) error {
-	return db.Delete(item).Error
+
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Delete(item).Error; err != nil {
+			return err
+		}
+
+		return incrementPermissionVersionByRole(ctx, tx, item.RoleID)
+
+	}); err != nil {
+		return err
+	}
+
+	invalidateRole(ctx, db, item.RoleID)
+
+	return nil
+
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Service Identity                                                           //
+////////////////////////////////////////////////////////////////////////////////
+
+// GetServiceIdentityBySubject retrieves a service identity by the certificate
+// subject it is associated with.
+func GetServiceIdentityBySubject(ctx context.Context, db *gorm.DB,
+	subject string) (*ServiceIdentity, error) {
+
+	var item ServiceIdentity
+
+	if err := db.WithContext(ctx).Model(&ServiceIdentity{}).
+		Where("subject = ?", subject).
+		First(&item).Error; err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+
+}
+
+// ListServiceIdentity retrieves all defined service identities.
+func ListServiceIdentity(ctx context.Context,
+	db *gorm.DB) ([]*ServiceIdentity, error) {
+
+	var items []*ServiceIdentity
+
+	if err := db.WithContext(ctx).Model(&ServiceIdentity{}).
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	return items, nil
+
+}
+
+// SaveServiceIdentity inserts or updates the supplied service identity record.
+func SaveServiceIdentity(ctx context.Context, db *gorm.DB,
+	item *ServiceIdentity) error {
+	return db.WithContext(ctx).Save(item).Error
+}
+
+// DeleteServiceIdentity deletes the supplied service identity record.
+func DeleteServiceIdentity(ctx context.Context, db *gorm.DB,
+	item *ServiceIdentity) error {
+	return db.WithContext(ctx).Delete(item).Error
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Housekeeping                                                               //
+////////////////////////////////////////////////////////////////////////////////
+
+// purgeDeleted permanently deletes all records of the supplied model that were
+// deleted before the supplied time.
+func purgeDeleted(ctx context.Context, db *gorm.DB, model interface{},
+	before time.Time) error {
+	return db.WithContext(ctx).Unscoped().
+		Where("deleted_at < ?", before).
+		Delete(model).Error
 }
@@ -4,18 +4,25 @@ import (
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/md5"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
+	"sort"
 	"time"
 
+	"web-app/cache"
 	"web-app/data"
 	"web-app/env"
+	"web-app/events"
+	"web-app/tracing"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/sirupsen/logrus"
 	"github.com/twinj/uuid"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -38,6 +45,22 @@ func init() {
 	refreshExpirationHours = time.Duration(
 		env.GetIntSafe(refreshExpirationHoursVariable, 72)) * time.Hour
 
+	// configure how long deleted records are kept
+	softDeleteRetentionDays = env.GetIntSafe(softDeleteRetentionDaysVariable,
+		30)
+
+	// configure how long resolved permissions are cached, by default only
+	// when changes are removed from the cache of every server instance
+	defaultPermissionCacheTTL := 0
+	if cache.Shared() {
+		defaultPermissionCacheTTL = 60
+	}
+	permissionCacheTTL = time.Duration(env.GetIntSafe(
+		permissionCacheTTLVariable, defaultPermissionCacheTTL)) * time.Second
+
+	// configure whether access tokens carry the permissions of the user
+	permissionClaims = env.GetBoolSafe(permissionClaimsVariable, false)
+
 }
 
 const (
@@ -53,6 +76,22 @@ const (
 	// refreshExpirationHoursVariable defines an environment variable for the
 	// number of hours before we should consider a refresh token expired.
 	refreshExpirationHoursVariable = "WEB_APP_REFRESH_EXPIRATION_HOURS"
+	// softDeleteRetentionDaysVariable defines an environment variable for the
+	// number of days deleted records are kept before they are purged.
+	softDeleteRetentionDaysVariable = "WEB_APP_SOFT_DELETE_RETENTION_DAYS"
+	// permissionCacheTTLVariable defines an environment variable for the
+	// number of seconds user auth states and resolved permissions are cached.
+	permissionCacheTTLVariable = "WEB_APP_PERMISSION_CACHE_TTL"
+	// permissionClaimsVariable defines an environment variable for whether
+	// access tokens carry the permissions of the user.
+	permissionClaimsVariable = "WEB_APP_PERMISSION_CLAIMS"
+	// LogoutEvent is the event published to a user when the user logs out.
+	// Clients should discard any auth tokens when this event is received.
+	LogoutEvent = "logout"
+	// PermissionsChangedEvent is the event published to a user when the roles
+	// or permissions granted to the user change. Clients should refresh their
+	// auth tokens to receive the updated permissions.
+	PermissionsChangedEvent = "permissions_changed"
 )
 
 // accessKey is used to sign JWT access tokens.
@@ -69,6 +108,22 @@ var accessExpirationHours time.Duration
 // refresh token to be expired.
 var refreshExpirationHours time.Duration
 
+// softDeleteRetentionDays determines the number of days deleted records are kept
+// before they are purged.
+var softDeleteRetentionDays int
+
+// permissionCacheTTL determines how long user auth states and resolved
+// permissions are cached. Changes are applied as soon as they are made, the
+// time to live only limits how long a change made while the values were being
+// loaded may be missed, or how long other server instances may miss a change
+// when the application cache is not shared. Nothing is cached if this is zero.
+var permissionCacheTTL time.Duration
+
+// permissionClaims determines whether access tokens carry the permission keys
+// and permission version of the user so that requests can be authorized
+// without resolving the user's permissions.
+var permissionClaims bool
+
 // CreateAuth generates JWT access and refresh tokens for the supplied user.
 func CreateAuth(ctx context.Context, u *User) (accessToken,
 	refreshToken string, err error) {
@@ -76,13 +131,23 @@ func CreateAuth(ctx context.Context, u *User) (accessToken,
 	// generate UUID to track issued credentials in peristent storage
 	authUUID := uuid.NewV4().String()
 
-	// create the access token
-	accessJWT := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	accessClaims := jwt.MapClaims{
 		"auth_uuid":  authUUID,
 		"user_id":    u.ID,
 		"created_at": time.Now().Unix(),
 		"expires_at": time.Now().Add(accessExpirationHours).Unix(),
-	})
+	}
+
+	// embed the permissions of the user, admins are not restricted by their
+	// permissions so they never need them
+	if permissionClaims && !u.Admin {
+		if err := addPermissionClaims(ctx, u, accessClaims); err != nil {
+			return "", "", err
+		}
+	}
+
+	// create the access token
+	accessJWT := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
 
 	accessToken, err = accessJWT.SignedString([]byte(accessKey))
 	if err != nil {
@@ -117,6 +182,71 @@ func CreateAuth(ctx context.Context, u *User) (accessToken,
 
 }
 
+// addPermissionClaims adds the permission keys and permission version of the
+// supplied user to the supplied access token claims. The permission keys are
+// trusted while the permission version of the user is unchanged.
+func addPermissionClaims(ctx context.Context, u *User,
+	claims jwt.MapClaims) error {
+
+	// the version is read before the permissions so that a concurrent change
+	// leaves the claims with an outdated version rather than outdated keys
+	state, err := getAuthState(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := getResolvedPermissions(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		keys = append(keys, permission.Key)
+	}
+	sort.Strings(keys)
+
+	claims["permissions"] = keys
+	claims["permission_version"] = state.PermissionVersion
+
+	return nil
+
+}
+
+// HashPassword hashes the supplied password for storage as the password of the
+// supplied user. The password is salted with the user id so the user must have
+// been saved first.
+func HashPassword(ctx context.Context, u *User, password string) (string,
+	error) {
+
+	_, span := tracing.Start(ctx, "bcrypt.GenerateFromPassword")
+	defer span.End()
+
+	hash, err := bcrypt.GenerateFromPassword(
+		[]byte(fmt.Sprintf("%d:%s", u.ID, password)), bcrypt.DefaultCost)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	return string(hash), nil
+
+}
+
+// ComparePassword checks the supplied password against the password of the
+// supplied user. Returns an error if the passwords do not match.
+func ComparePassword(ctx context.Context, u *User, password string) error {
+
+	_, span := tracing.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+
+	return bcrypt.CompareHashAndPassword(
+		[]byte(u.Password),
+		[]byte(fmt.Sprintf("%d:%s", u.ID, password)),
+	)
+
+}
+
 // GenerateSecretToken creates a base64 encoded token that includes both the
 // supplied user id as well as the supplied payload encrypted with the user
 // secret key.
@@ -187,6 +317,11 @@ func ParseSecretToken(ctx context.Context,
 		return nil, "", err
 	}
 
+	// service accounts are never issued secret tokens
+	if u.Service {
+		return nil, "", fmt.Errorf("user %d is a service account", u.ID)
+	}
+
 	// base64 decode encrypted payload
 	encryptData, err := base64.URLEncoding.DecodeString(tokenData.Payload)
 	if err != nil {
@@ -222,7 +357,8 @@ func ParseSecretToken(ctx context.Context,
 }
 
 // GetUserPermissions returns a list of permissions associated with the supplied
-// user and the user's assigned roles.
+// user and the user's assigned roles. The permissions of users other than
+// admins are cached until the user, their roles, or any permission changes.
 func GetUserPermissions(ctx context.Context, u *User,
 	public *bool) ([]*Permission, error) {
 
@@ -231,40 +367,49 @@ func GetUserPermissions(ctx context.Context, u *User,
 		return ListPermission(ctx, data.DB(), public)
 	}
 
-	// retrieve permissions directly associated with the user
-	results, err := ListPermissionByUser(ctx, data.DB(), u.ID, public)
+	permissions, err := getResolvedPermissions(ctx, u.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	// retrieve roles associated with the user
-	roles, err := ListRoleByUser(ctx, data.DB(), u.ID)
-	if err != nil {
-		return nil, err
+	if public == nil {
+		return permissions, nil
 	}
 
-	// keep track of permissions we have already added
-	added := map[string]struct{}{}
-	for _, permission := range results {
-		added[permission.Key] = struct{}{}
+	var results []*Permission
+	for _, permission := range permissions {
+		if permission.Public == *public {
+			results = append(results, permission)
+		}
 	}
 
-	// retrieve permissions associated with the user roles
-	for _, role := range roles {
-		permissions, err := ListPermissionByRole(ctx, data.DB(), role.ID, public)
-		if err != nil {
-			return nil, err
-		}
+	return results, nil
+
+}
+
+// HasAnyPermissions checks whether the supplied user has at least one of the
+// specified permissions. Admins are considered to have all permissions.
+func HasAnyPermissions(ctx context.Context, u *User,
+	permissionKeys ...string) (bool, error) {
+
+	if u.Admin {
+		return true, nil
+	}
+
+	permissions, err := GetUserPermissions(ctx, u, nil)
+	if err != nil {
+		return false, err
+	}
 
-		for i := 0; i < len(permissions); i++ {
-			if _, ok := added[permissions[i].Key]; !ok {
-				results = append(results, permissions[i])
-				added[permissions[i].Key] = struct{}{}
+	for _, permission := range permissions {
+		for _, permissionKey := range permissionKeys {
+			if permission.Key == permissionKey {
+				return true, nil
 			}
 		}
 	}
 
-	return results, nil
+	return false, nil
 
 }
 
@@ -384,12 +529,218 @@ func CreatePermission(ctx context.Context, permissionKey string, public bool,
 	}(); err != nil {
 		// if an error was encountered roll back the transaction
 		if err := tx.Rollback(); err != nil {
-			logrus.Error(err)
+			logrus.WithContext(ctx).Error(err)
+		}
+		return err
+	}
+
+	// if no error was encountered commit the transaction
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	InvalidatePermissions(ctx)
+
+	return nil
+
+}
+
+// CreateServiceIdentity will create a service identity for the specified
+// certificate subject if it does not already exist. A user account is created
+// to hold the permissions of the service and is associated with the supplied
+// list of roles. If the service identity already exists nothing will happen and
+// no error will be returned.
+func CreateServiceIdentity(ctx context.Context, subject string,
+	roles ...string) error {
+
+	// check if the service identity already exists
+	_, err := GetServiceIdentityBySubject(ctx, data.DB(), subject)
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	// create a new transaction
+	tx := data.DB().Begin()
+
+	var u *User
+
+	// wrap the work in a function to capture any errors and simplify committing
+	// or rolling back the transaction
+	if err := func() error {
+
+		// create the user account that holds the service permissions, the
+		// account is marked as a service account so it cannot be claimed
+		// through signup or used to log in
+		u = &User{
+			Email:     subject,
+			SecretKey: fmt.Sprintf("%x", md5.Sum(uuid.NewV4().Bytes())),
+			Service:   true,
+		}
+
+		if err := SaveUser(ctx, tx, u); err != nil {
+			return err
+		}
+
+		if err := SaveServiceIdentity(ctx, tx, &ServiceIdentity{
+			Subject: subject,
+			UserID:  u.ID,
+		}); err != nil {
+			return err
+		}
+
+		// associate the service user account with the supplied roles
+		for _, roleKey := range roles {
+			role, err := GetRoleByKey(ctx, tx, roleKey)
+			if err != nil {
+				return err
+			}
+
+			if err := saveUserRole(ctx, tx, &userRole{
+				UserID: u.ID,
+				RoleID: role.ID,
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	}(); err != nil {
+		// if an error was encountered roll back the transaction
+		if err := tx.Rollback().Error; err != nil {
+			logrus.WithContext(ctx).Error(err)
 		}
 		return err
 	}
 
 	// if no error was encountered commit the transaction
-	return tx.Commit().Error
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	InvalidateUser(ctx, u.ID)
+
+	return nil
+
+}
 
+// GrantRole associates the specified role with the supplied user. If the user
+// already has the role nothing will happen and no error will be returned.
+func GrantRole(ctx context.Context, u *User, roleKey string) error {
+
+	role, err := GetRoleByKey(ctx, data.DB(), roleKey)
+	if err != nil {
+		return err
+	}
+
+	// check if the user already has the role
+	_, err = getUserRole(ctx, data.DB(), u.ID, role.ID)
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	if err := saveUserRole(ctx, data.DB(), &userRole{
+		UserID: u.ID,
+		RoleID: role.ID,
+	}); err != nil {
+		return err
+	}
+
+	publishPermissionsChanged(ctx, u)
+
+	return nil
+
+}
+
+// RevokeRole removes the association between the specified role and the
+// supplied user. If the user does not have the role nothing will happen and no
+// error will be returned.
+func RevokeRole(ctx context.Context, u *User, roleKey string) error {
+
+	role, err := GetRoleByKey(ctx, data.DB(), roleKey)
+	if err != nil {
+		return err
+	}
+
+	item, err := getUserRole(ctx, data.DB(), u.ID, role.ID)
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := deleteUserRole(ctx, data.DB(), item); err != nil {
+		return err
+	}
+
+	publishPermissionsChanged(ctx, u)
+
+	return nil
+
+}
+
+// GrantPermission associates the specified permission with the supplied user.
+// If the user already has the permission nothing will happen and no error will
+// be returned.
+func GrantPermission(ctx context.Context, u *User, permissionKey string) error {
+
+	permission, err := GetPermissionByKey(ctx, data.DB(), permissionKey)
+	if err != nil {
+		return err
+	}
+
+	// check if the user already has the permission
+	_, err = getUserPermission(ctx, data.DB(), u.ID, permission.ID)
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	if err := saveUserPermission(ctx, data.DB(), &userPermission{
+		UserID:       u.ID,
+		PermissionID: permission.ID,
+	}); err != nil {
+		return err
+	}
+
+	publishPermissionsChanged(ctx, u)
+
+	return nil
+
+}
+
+// RevokePermission removes the association between the specified permission
+// and the supplied user. If the user does not have the permission directly
+// nothing will happen and no error will be returned.
+func RevokePermission(ctx context.Context, u *User,
+	permissionKey string) error {
+
+	permission, err := GetPermissionByKey(ctx, data.DB(), permissionKey)
+	if err != nil {
+		return err
+	}
+
+	item, err := getUserPermission(ctx, data.DB(), u.ID, permission.ID)
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := deleteUserPermission(ctx, data.DB(), item); err != nil {
+		return err
+	}
+
+	publishPermissionsChanged(ctx, u)
+
+	return nil
+
+}
+
+// publishPermissionsChanged notifies the supplied user that their permissions
+// have changed. Failing to publish the event does not undo the change so any
+// error is only logged.
+func publishPermissionsChanged(ctx context.Context, u *User) {
+	if err := events.Publish(u.ID, PermissionsChangedEvent, nil); err != nil {
+		logrus.WithContext(ctx).Error(err)
+	}
 }